@@ -2,17 +2,25 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"golang-backend/internal/config"
+	"golang-backend/internal/database"
+	"golang-backend/internal/logging"
+	"golang-backend/internal/metrics"
 	"golang-backend/internal/server"
+	"golang-backend/internal/tracing"
 )
 
-func gracefulShutdown(apiServer *http.Server, done chan bool) {
+func gracefulShutdown(logger *slog.Logger, apiServer *http.Server, grpcServer *server.GRPCServer, done chan bool) {
 	// Create context that listens for the interrupt signal from the OS.
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
@@ -20,7 +28,7 @@ func gracefulShutdown(apiServer *http.Server, done chan bool) {
 	// Listen for the interrupt signal.
 	<-ctx.Done()
 
-	log.Println("shutting down gracefully, press Ctrl+C again to force")
+	logger.Info("shutting down gracefully, press Ctrl+C again to force")
 	stop() // Allow Ctrl+C to force shutdown
 
 	// The context is used to inform the server it has 5 seconds to finish
@@ -28,31 +36,124 @@ func gracefulShutdown(apiServer *http.Server, done chan bool) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	if err := apiServer.Shutdown(ctx); err != nil {
-		log.Printf("Server forced to shutdown with error: %v", err)
+		logger.Error("server forced to shutdown", "error", err)
+	}
+	if grpcServer != nil {
+		grpcServer.Shutdown(ctx)
 	}
 
-	log.Println("Server exiting")
+	logger.Info("server exiting")
 
 	// Notify the main goroutine that the shutdown is complete
 	done <- true
 }
 
 func main() {
+	cfg, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err == nil {
+		if len(args) > 0 && args[0] == "migrate" {
+			// Migrations only connect to the database, so they run
+			// without the secrets of the server
+			err = cfg.Database.Validate()
+		} else {
+			err = cfg.Validate()
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(1)
+	}
+
+	logger, err := logging.New(os.Stderr, logging.Options{Format: cfg.Log.Format, Level: cfg.Log.Level})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			if err := runMigrate(cfg, logger, args[1:]); err != nil {
+				logging.Fatal(logger, "migration failed", err)
+			}
+		case "config":
+			// Print the effective configuration, secrets redacted
+			if err := cfg.Write(os.Stdout); err != nil {
+				logging.Fatal(logger, "failed to print configuration", err)
+			}
+		default:
+			fmt.Fprintf(os.Stderr, "unknown command %q, want migrate or config\n", args[0])
+			os.Exit(2)
+		}
+		return
+	}
 
-	server := server.NewServer()
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Exporter: cfg.Tracing.Exporter,
+		File:     cfg.Tracing.File,
+		Protocol: cfg.Tracing.Protocol,
+	})
+	if err != nil {
+		logging.Fatal(logger, "failed to set up tracing", err)
+	}
+	defer func() {
+		// Flush the spans of the last requests
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error("failed to flush traces", "error", err)
+		}
+	}()
+
+	m := metrics.New()
+	db, err := mysql.New(cfg.Database, logger, m)
+	if err != nil {
+		logging.Fatal(logger, "failed to connect to database", err)
+	}
+	defer db.Close()
+	m.RegisterDBStats(db.Stats)
+
+	srv, err := server.New(
+		server.WithStore(db),
+		server.WithConfig(cfg),
+		server.WithLogger(logger),
+		server.WithMetrics(m),
+	)
+	if err != nil {
+		logging.Fatal(logger, "invalid configuration", err)
+	}
+	apiServer, grpcServer := srv.HTTPServer(), srv.GRPCServer()
+
+	// Run background workers until shutdown
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	srv.StartWorkers(workersCtx)
+	apiServer.RegisterOnShutdown(stopWorkers)
 
 	// Create a done channel to signal when the shutdown is complete
 	done := make(chan bool, 1)
 
 	// Run graceful shutdown in a separate goroutine
-	go gracefulShutdown(server, done)
+	go gracefulShutdown(logger, apiServer, grpcServer, done)
+
+	// Serve gRPC on its own port next to the HTTP API
+	if grpcServer != nil {
+		go func() {
+			if err := grpcServer.ListenAndServe(); err != nil {
+				panic(fmt.Sprintf("grpc server error: %s", err))
+			}
+		}()
+	}
 
-	err := server.ListenAndServe()
+	err = apiServer.ListenAndServe()
 	if err != nil && err != http.ErrServerClosed {
 		panic(fmt.Sprintf("http server error: %s", err))
 	}
 
 	// Wait for the graceful shutdown to complete
 	<-done
-	log.Println("Graceful shutdown complete.")
+	logger.Info("graceful shutdown complete")
 }
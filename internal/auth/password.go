@@ -0,0 +1,265 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrPasswordMismatch = errors.New("password does not match")
+	ErrUnsupportedHash  = errors.New("unsupported password hash format")
+	ErrMalformedHash    = errors.New("malformed password hash")
+)
+
+// hashPrefixes are the prefixes of the hashes produced by the hashers of
+// this package
+var hashPrefixes = []string{argon2idPrefix, "$2a$", "$2b$", "$2y$"}
+
+// IsHash reports whether encoded looks like a hash produced by one of the
+// hashers of this package, whether or not it is well-formed. Values that
+// do not cannot have been produced by Hash.
+func IsHash(encoded string) bool {
+	for _, prefix := range hashPrefixes {
+		if strings.HasPrefix(encoded, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// PasswordHasher hashes and verifies passwords.
+// Implementations encode the algorithm and its parameters in the returned
+// hash, so hashes created with older parameters remain verifiable.
+type PasswordHasher interface {
+	// Hash returns the encoded hash of password.
+	Hash(password string) (string, error)
+
+	// Verify checks password against an encoded hash. It returns
+	// ErrPasswordMismatch if the password is wrong, ErrUnsupportedHash
+	// if the hash was not produced by this hasher and ErrMalformedHash if
+	// it looks like it was but cannot be decoded.
+	Verify(encoded, password string) error
+
+	// NeedsRehash reports whether the encoded hash was produced with
+	// parameters other than the hasher's current ones.
+	NeedsRehash(encoded string) bool
+
+	// Supports reports whether the encoded hash was produced by this hasher.
+	Supports(encoded string) bool
+}
+
+// NewPasswordHasher returns the hasher for the named algorithm ("argon2id"
+// or "bcrypt"; empty selects argon2id). The returned hasher hashes with the
+// selected algorithm but still verifies hashes made by the other one, and
+// reports them as needing a rehash.
+func NewPasswordHasher(algorithm string) (PasswordHasher, error) {
+	argon := NewArgon2idHasher()
+	bc := NewBcryptHasher()
+
+	switch strings.ToLower(algorithm) {
+	case "", "argon2id":
+		return &upgradingHasher{current: argon, legacy: []PasswordHasher{bc}}, nil
+	case "bcrypt":
+		return &upgradingHasher{current: bc, legacy: []PasswordHasher{argon}}, nil
+	default:
+		return nil, fmt.Errorf("unknown password hash algorithm %q", algorithm)
+	}
+}
+
+// upgradingHasher hashes with current and falls back to legacy hashers for
+// verification.
+type upgradingHasher struct {
+	current PasswordHasher
+	legacy  []PasswordHasher
+}
+
+func (h *upgradingHasher) Hash(password string) (string, error) {
+	return h.current.Hash(password)
+}
+
+func (h *upgradingHasher) Verify(encoded, password string) error {
+	if h.current.Supports(encoded) {
+		return h.current.Verify(encoded, password)
+	}
+	for _, l := range h.legacy {
+		if l.Supports(encoded) {
+			return l.Verify(encoded, password)
+		}
+	}
+	return ErrUnsupportedHash
+}
+
+func (h *upgradingHasher) NeedsRehash(encoded string) bool {
+	return !h.current.Supports(encoded) || h.current.NeedsRehash(encoded)
+}
+
+func (h *upgradingHasher) Supports(encoded string) bool {
+	if h.current.Supports(encoded) {
+		return true
+	}
+	for _, l := range h.legacy {
+		if l.Supports(encoded) {
+			return true
+		}
+	}
+	return false
+}
+
+// BcryptHasher hashes passwords with bcrypt. The cost is part of the
+// standard bcrypt encoding ($2a$<cost>$...).
+type BcryptHasher struct {
+	Cost int
+}
+
+// NewBcryptHasher returns a bcrypt hasher using bcrypt.DefaultCost.
+func NewBcryptHasher() *BcryptHasher {
+	return &BcryptHasher{Cost: bcrypt.DefaultCost}
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+func (h *BcryptHasher) Verify(encoded, password string) error {
+	if !h.Supports(encoded) {
+		return ErrUnsupportedHash
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrPasswordMismatch
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedHash, err)
+	}
+	return nil
+}
+
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.Cost
+}
+
+func (h *BcryptHasher) Supports(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") ||
+		strings.HasPrefix(encoded, "$2b$") ||
+		strings.HasPrefix(encoded, "$2y$")
+}
+
+// Argon2idHasher hashes passwords with argon2id. Hashes use the PHC string
+// format: $argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<key>.
+type Argon2idHasher struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// NewArgon2idHasher returns an argon2id hasher using the parameters
+// recommended by RFC 9106 for memory-constrained environments.
+func NewArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 2,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+const argon2idPrefix = "$argon2id$"
+
+// argon2idParams holds the values decoded from an argon2id hash.
+type argon2idParams struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *Argon2idHasher) Verify(encoded, password string) error {
+	p, err := decodeArgon2id(encoded)
+	if err != nil {
+		return err
+	}
+
+	key := argon2.IDKey([]byte(password), p.salt, p.iterations, p.memory, p.parallelism, uint32(len(p.key)))
+	if subtle.ConstantTimeCompare(key, p.key) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	p, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return p.memory != h.Memory ||
+		p.iterations != h.Iterations ||
+		p.parallelism != h.Parallelism ||
+		uint32(len(p.salt)) != h.SaltLength ||
+		uint32(len(p.key)) != h.KeyLength
+}
+
+func (h *Argon2idHasher) Supports(encoded string) bool {
+	return strings.HasPrefix(encoded, argon2idPrefix)
+}
+
+// decodeArgon2id parses a PHC-formatted argon2id hash.
+func decodeArgon2id(encoded string) (*argon2idParams, error) {
+	if !strings.HasPrefix(encoded, argon2idPrefix) {
+		return nil, ErrUnsupportedHash
+	}
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return nil, ErrMalformedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, ErrMalformedHash
+	}
+
+	var p argon2idParams
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.iterations, &p.parallelism); err != nil {
+		return nil, ErrMalformedHash
+	}
+
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, ErrMalformedHash
+	}
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(p.key) == 0 {
+		return nil, ErrMalformedHash
+	}
+
+	return &p, nil
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPasswordHashers(t *testing.T) {
+	argon := NewArgon2idHasher()
+	argon.Memory = 1024 // keep the test fast
+	bc := NewBcryptHasher()
+	bc.Cost = 4
+
+	for name, h := range map[string]PasswordHasher{"argon2id": argon, "bcrypt": bc} {
+		t.Run(name, func(t *testing.T) {
+			hash, err := h.Hash("password123")
+			if err != nil {
+				t.Fatalf("failed to hash password: %v", err)
+			}
+
+			if strings.Contains(hash, "password123") {
+				t.Fatalf("expected hash not to contain the password, got %s", hash)
+			}
+
+			if !h.Supports(hash) {
+				t.Fatalf("expected hasher to support its own hash %s", hash)
+			}
+
+			if err := h.Verify(hash, "password123"); err != nil {
+				t.Fatalf("expected password to verify, got %v", err)
+			}
+
+			if err := h.Verify(hash, "wrong"); !errors.Is(err, ErrPasswordMismatch) {
+				t.Fatalf("expected ErrPasswordMismatch, got %v", err)
+			}
+
+			if h.NeedsRehash(hash) {
+				t.Fatalf("expected fresh hash not to need a rehash")
+			}
+		})
+	}
+}
+
+func TestArgon2idNeedsRehashOnParameterChange(t *testing.T) {
+	h := NewArgon2idHasher()
+	h.Memory = 1024
+
+	hash, err := h.Hash("password123")
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=3,p=2$") {
+		t.Fatalf("expected parameters to be encoded in hash, got %s", hash)
+	}
+
+	h.Iterations = 4
+	if !h.NeedsRehash(hash) {
+		t.Fatalf("expected hash to need a rehash after iterations changed")
+	}
+
+	// Old hashes must still verify with the new parameters.
+	if err := h.Verify(hash, "password123"); err != nil {
+		t.Fatalf("expected old hash to verify, got %v", err)
+	}
+}
+
+func TestNewPasswordHasherUpgradesLegacyHashes(t *testing.T) {
+	h, err := NewPasswordHasher("argon2id")
+	if err != nil {
+		t.Fatalf("failed to create hasher: %v", err)
+	}
+
+	bc := &BcryptHasher{Cost: 4}
+	legacy, err := bc.Hash("password123")
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+
+	if err := h.Verify(legacy, "password123"); err != nil {
+		t.Fatalf("expected bcrypt hash to verify, got %v", err)
+	}
+
+	if !h.NeedsRehash(legacy) {
+		t.Fatalf("expected bcrypt hash to need a rehash when argon2id is preferred")
+	}
+
+	if err := h.Verify("password123", "password123"); !errors.Is(err, ErrUnsupportedHash) {
+		t.Fatalf("expected ErrUnsupportedHash for plaintext, got %v", err)
+	}
+
+	if _, err := NewPasswordHasher("md5"); err == nil {
+		t.Fatalf("expected error for unknown algorithm")
+	}
+}
+
+func TestMalformedHashes(t *testing.T) {
+	h, err := NewPasswordHasher("")
+	if err != nil {
+		t.Fatalf("failed to create hasher: %v", err)
+	}
+
+	for _, encoded := range []string{
+		"$argon2id$v=19$m=65536,t=3,p=2$c2FsdA",
+		"$argon2id$v=19$m=65536,t=3,p=2$!!!$a2V5",
+		"$2a$10$short",
+		"$2b$",
+	} {
+		if !IsHash(encoded) {
+			t.Errorf("expected %q to look like a hash", encoded)
+		}
+		if err := h.Verify(encoded, encoded); !errors.Is(err, ErrMalformedHash) {
+			t.Errorf("expected ErrMalformedHash for %q, got %v", encoded, err)
+		}
+	}
+
+	for _, encoded := range []string{"password123", "$1$md5crypt", ""} {
+		if IsHash(encoded) {
+			t.Errorf("expected %q not to look like a hash", encoded)
+		}
+		if err := h.Verify(encoded, encoded); !errors.Is(err, ErrUnsupportedHash) {
+			t.Errorf("expected ErrUnsupportedHash for %q, got %v", encoded, err)
+		}
+	}
+}
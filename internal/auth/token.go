@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+)
+
+// TokenConfig configures how access tokens are signed and validated.
+type TokenConfig struct {
+	// Algorithm is one of HS256, RS256 or EdDSA. Empty selects HS256.
+	Algorithm string
+
+	// Secret is the HMAC key used by HS256. It must be at least 32 bytes.
+	Secret []byte
+
+	// PrivateKeyPEM is the PEM-encoded PKCS#8 private key used by RS256
+	// and EdDSA. The public key is derived from it.
+	PrivateKeyPEM []byte
+
+	Issuer     string
+	Audience   string
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+
+	// Now returns the time tokens are issued and validated at, time.Now if
+	// nil.
+	Now func() time.Time
+}
+
+// Claims are the claims carried by an access token.
+type Claims struct {
+	jwt.RegisteredClaims
+	Username string   `json:"username,omitempty"`
+	Roles    []string `json:"roles,omitempty"`
+}
+
+// UserID returns the numeric user ID stored in the subject claim.
+func (c *Claims) UserID() (int, error) {
+	id, err := strconv.Atoi(c.Subject)
+	if err != nil {
+		return 0, ErrInvalidToken
+	}
+	return id, nil
+}
+
+// TokenManager issues and validates signed JWT access tokens.
+type TokenManager struct {
+	method     jwt.SigningMethod
+	signKey    any
+	verifyKey  any
+	issuer     string
+	audience   string
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	now        func() time.Time
+}
+
+// NewTokenManager creates a TokenManager from cfg.
+func NewTokenManager(cfg TokenConfig) (*TokenManager, error) {
+	m := &TokenManager{
+		issuer:     cfg.Issuer,
+		audience:   cfg.Audience,
+		accessTTL:  cfg.AccessTTL,
+		refreshTTL: cfg.RefreshTTL,
+		now:        time.Now,
+	}
+	if cfg.Now != nil {
+		m.now = cfg.Now
+	}
+	if m.accessTTL <= 0 {
+		m.accessTTL = 15 * time.Minute
+	}
+	if m.refreshTTL <= 0 {
+		m.refreshTTL = 30 * 24 * time.Hour
+	}
+
+	switch strings.ToUpper(cfg.Algorithm) {
+	case "", "HS256":
+		if len(cfg.Secret) < 32 {
+			return nil, errors.New("HS256 requires a secret of at least 32 bytes")
+		}
+		m.method = jwt.SigningMethodHS256
+		m.signKey = cfg.Secret
+		m.verifyKey = cfg.Secret
+	case "RS256":
+		key, err := parsePrivateKey(cfg.PrivateKeyPEM)
+		if err != nil {
+			return nil, err
+		}
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("RS256 requires an RSA private key")
+		}
+		m.method = jwt.SigningMethodRS256
+		m.signKey = rsaKey
+		m.verifyKey = &rsaKey.PublicKey
+	case "EDDSA":
+		key, err := parsePrivateKey(cfg.PrivateKeyPEM)
+		if err != nil {
+			return nil, err
+		}
+		edKey, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, errors.New("EdDSA requires an Ed25519 private key")
+		}
+		m.method = jwt.SigningMethodEdDSA
+		m.signKey = edKey
+		m.verifyKey = edKey.Public()
+	default:
+		return nil, fmt.Errorf("unsupported token algorithm %q", cfg.Algorithm)
+	}
+
+	return m, nil
+}
+
+// AccessTTL returns the lifetime of issued access tokens.
+func (m *TokenManager) AccessTTL() time.Duration {
+	return m.accessTTL
+}
+
+// RefreshTTL returns the lifetime of issued refresh tokens.
+func (m *TokenManager) RefreshTTL() time.Duration {
+	return m.refreshTTL
+}
+
+// IssueAccessToken returns a signed access token for the given user and
+// roles, and the time it expires.
+func (m *TokenManager) IssueAccessToken(userID int, username string, roles []string) (string, time.Time, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate token id: %w", err)
+	}
+
+	now := m.now()
+	expiresAt := now.Add(m.accessTTL)
+
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    m.issuer,
+			Subject:   strconv.Itoa(userID),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        hex.EncodeToString(jti),
+		},
+		Username: username,
+		Roles:    roles,
+	}
+	if m.audience != "" {
+		claims.Audience = jwt.ClaimStrings{m.audience}
+	}
+
+	signed, err := jwt.NewWithClaims(m.method, claims).SignedString(m.signKey)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to sign token: %w", err)
+	}
+
+	return signed, expiresAt, nil
+}
+
+// ParseAccessToken validates the signature and standard claims of token
+// and returns its claims. Any failure is reported as ErrInvalidToken.
+func (m *TokenManager) ParseAccessToken(token string) (*Claims, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{m.method.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithTimeFunc(m.now),
+	}
+	if m.issuer != "" {
+		opts = append(opts, jwt.WithIssuer(m.issuer))
+	}
+	if m.audience != "" {
+		opts = append(opts, jwt.WithAudience(m.audience))
+	}
+
+	var claims Claims
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (any, error) {
+		return m.verifyKey, nil
+	}, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	if _, err := claims.UserID(); err != nil {
+		return nil, err
+	}
+
+	return &claims, nil
+}
+
+// parsePrivateKey decodes a PEM-encoded PKCS#8 private key.
+func parsePrivateKey(data []byte) (any, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM-encoded private key found")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	return key, nil
+}
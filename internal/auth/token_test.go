@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+)
+
+func mustPKCS8PEM(t *testing.T, key any) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestTokenManagerRoundTrip(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ed25519 key: %v", err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate rsa key: %v", err)
+	}
+
+	configs := map[string]TokenConfig{
+		"HS256": {Algorithm: "HS256", Secret: []byte("0123456789abcdef0123456789abcdef")},
+		"RS256": {Algorithm: "RS256", PrivateKeyPEM: mustPKCS8PEM(t, rsaKey)},
+		"EdDSA": {Algorithm: "EdDSA", PrivateKeyPEM: mustPKCS8PEM(t, edKey)},
+	}
+
+	for name, cfg := range configs {
+		t.Run(name, func(t *testing.T) {
+			cfg.Issuer = "golang-backend"
+			cfg.Audience = "web"
+			m, err := NewTokenManager(cfg)
+			if err != nil {
+				t.Fatalf("failed to create token manager: %v", err)
+			}
+
+			token, expiresAt, err := m.IssueAccessToken(42, "testuser", []string{"admin"})
+			if err != nil {
+				t.Fatalf("failed to issue token: %v", err)
+			}
+
+			if time.Until(expiresAt) > m.AccessTTL() {
+				t.Fatalf("expected token to expire within %s, got %s", m.AccessTTL(), expiresAt)
+			}
+
+			claims, err := m.ParseAccessToken(token)
+			if err != nil {
+				t.Fatalf("failed to parse token: %v", err)
+			}
+
+			id, err := claims.UserID()
+			if err != nil || id != 42 {
+				t.Fatalf("expected user ID 42, got %d (%v)", id, err)
+			}
+
+			if claims.Username != "testuser" {
+				t.Fatalf("expected username to be 'testuser', got %s", claims.Username)
+			}
+
+			if len(claims.Roles) != 1 || claims.Roles[0] != "admin" {
+				t.Fatalf("expected roles to be [admin], got %v", claims.Roles)
+			}
+
+			if _, err := m.ParseAccessToken(token + "x"); !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("expected ErrInvalidToken for tampered token, got %v", err)
+			}
+		})
+	}
+}
+
+func TestTokenManagerRejectsExpiredAndForeignTokens(t *testing.T) {
+	m, err := NewTokenManager(TokenConfig{Secret: []byte("0123456789abcdef0123456789abcdef"), AccessTTL: time.Minute})
+	if err != nil {
+		t.Fatalf("failed to create token manager: %v", err)
+	}
+
+	token, _, err := m.IssueAccessToken(1, "testuser", nil)
+	if err != nil {
+		t.Fatalf("failed to issue token: %v", err)
+	}
+
+	m.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	if _, err := m.ParseAccessToken(token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken for expired token, got %v", err)
+	}
+
+	other, err := NewTokenManager(TokenConfig{Secret: []byte("fedcba9876543210fedcba9876543210")})
+	if err != nil {
+		t.Fatalf("failed to create token manager: %v", err)
+	}
+	if _, err := other.ParseAccessToken(token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken for token signed with another key, got %v", err)
+	}
+
+	if _, err := NewTokenManager(TokenConfig{Secret: []byte("short")}); err == nil {
+		t.Fatalf("expected error for short HS256 secret")
+	}
+}
+
+func TestRefreshTokens(t *testing.T) {
+	token, hash, err := NewRefreshToken()
+	if err != nil {
+		t.Fatalf("failed to create refresh token: %v", err)
+	}
+
+	if hash != HashRefreshToken(token) {
+		t.Fatalf("expected hash to be derived from token")
+	}
+
+	other, _, err := NewRefreshToken()
+	if err != nil {
+		t.Fatalf("failed to create refresh token: %v", err)
+	}
+	if other == token {
+		t.Fatalf("expected refresh tokens to be unique")
+	}
+
+	family, err := NewTokenFamily()
+	if err != nil || len(family) != 32 {
+		t.Fatalf("expected 32 character token family, got %q (%v)", family, err)
+	}
+}
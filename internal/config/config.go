@@ -0,0 +1,232 @@
+// Package config loads the configuration of the application.
+//
+// Settings are taken from, in increasing order of precedence: their
+// defaults, a YAML or TOML file, environment variables (including a .env
+// file) and command-line flags. Every setting has a key, used in files and
+// as flag name, and an environment variable, both given by the tags of its
+// field. Secrets can be read from files by appending _FILE to their
+// environment variable, as with Docker secrets.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	_ "github.com/joho/godotenv/autoload"
+)
+
+// Config is the configuration of the application
+type Config struct {
+	// Env is the environment the application runs in. "production"
+	// disables development features such as the GraphiQL IDE.
+	Env string `config:"env" env:"APP_ENV"`
+
+	HTTP     HTTP     `config:"http"`
+	GRPC     GRPC     `config:"grpc"`
+	Database Database `config:"database"`
+	Auth     Auth     `config:"auth"`
+	Users    Users    `config:"users"`
+	Log      Log      `config:"log"`
+	Tracing  Tracing  `config:"tracing"`
+}
+
+// HTTP configures the HTTP API
+type HTTP struct {
+	Port int `config:"port" env:"PORT"`
+	// CORSOrigins are the origins allowed to make cross-origin requests.
+	// Cross-origin requests are not allowed if empty.
+	CORSOrigins     []string `config:"cors_origins" env:"CORS_ALLOWED_ORIGINS"`
+	OpenAPIValidate bool     `config:"openapi_validate" env:"OPENAPI_VALIDATE"`
+	// CursorSecret signs pagination cursors and keys the request
+	// fingerprints stored with idempotency keys. A random secret is used
+	// if empty, so that neither survives restarts.
+	CursorSecret      string        `config:"cursor_secret" env:"CURSOR_SECRET" secret:"true"`
+	IdempotencyKeyTTL time.Duration `config:"idempotency_key_ttl" env:"IDEMPOTENCY_KEY_TTL"`
+}
+
+// GRPC configures the gRPC API
+type GRPC struct {
+	Port int `config:"port" env:"GRPC_PORT"` // Disabled if zero
+}
+
+// Database configures the MySQL database
+type Database struct {
+	Host            string        `config:"host" env:"MYSQL_DB_HOST"`
+	Port            int           `config:"port" env:"MYSQL_DB_PORT"`
+	Name            string        `config:"name" env:"MYSQL_DB_DATABASE"`
+	Username        string        `config:"username" env:"MYSQL_DB_USERNAME"`
+	Password        string        `config:"password" env:"MYSQL_DB_PASSWORD" secret:"true"`
+	AutoMigrate     bool          `config:"auto_migrate" env:"MYSQL_DB_AUTO_MIGRATE"`
+	MaxOpenConns    int           `config:"max_open_conns" env:"MYSQL_DB_MAX_OPEN_CONNS"`
+	MaxIdleConns    int           `config:"max_idle_conns" env:"MYSQL_DB_MAX_IDLE_CONNS"`
+	ConnMaxLifetime time.Duration `config:"conn_max_lifetime" env:"MYSQL_DB_CONN_MAX_LIFETIME"` // Unlimited if zero
+
+	// PasswordHashAlgorithm hashes new passwords, "argon2id" or "bcrypt"
+	PasswordHashAlgorithm string `config:"password_hash_algorithm" env:"PASSWORD_HASH_ALGORITHM"`
+}
+
+// Auth configures access and refresh tokens
+type Auth struct {
+	JWTAlgorithm string `config:"jwt_algorithm" env:"JWT_ALGORITHM"` // HS256, RS256 or EdDSA
+	JWTSecret    string `config:"jwt_secret" env:"JWT_SECRET" secret:"true"`
+	// JWTPrivateKey is the PEM-encoded PKCS#8 key for RS256 and EdDSA,
+	// usually read from the file in JWT_PRIVATE_KEY_FILE
+	JWTPrivateKey string        `config:"jwt_private_key" env:"JWT_PRIVATE_KEY" secret:"true"`
+	JWTIssuer     string        `config:"jwt_issuer" env:"JWT_ISSUER"`
+	JWTAudience   string        `config:"jwt_audience" env:"JWT_AUDIENCE"`
+	AccessTTL     time.Duration `config:"access_ttl" env:"JWT_ACCESS_TTL"`
+	RefreshTTL    time.Duration `config:"refresh_ttl" env:"JWT_REFRESH_TTL"`
+}
+
+// Users configures the purging of deleted users
+type Users struct {
+	Retention     time.Duration `config:"retention" env:"USER_RETENTION"`
+	PurgeInterval time.Duration `config:"purge_interval" env:"USER_PURGE_INTERVAL"`
+}
+
+// Log configures logging
+type Log struct {
+	Format string     `config:"format" env:"LOG_FORMAT"` // "text" or "json"
+	Level  slog.Level `config:"level" env:"LOG_LEVEL"`
+}
+
+// Tracing configures the export of traces
+type Tracing struct {
+	Exporter string `config:"exporter" env:"OTEL_TRACES_EXPORTER"` // "none", "stdout", "file" or "otlp"
+	File     string `config:"file" env:"OTEL_TRACES_FILE"`
+	Protocol string `config:"protocol" env:"OTEL_EXPORTER_OTLP_PROTOCOL"` // "http/protobuf" or "grpc"
+}
+
+// Default returns the default configuration
+func Default() *Config {
+	return &Config{
+		HTTP: HTTP{
+			Port:              8080,
+			CORSOrigins:       []string{"http://localhost:5173"},
+			IdempotencyKeyTTL: 24 * time.Hour,
+		},
+		Database: Database{
+			Port:                  3306,
+			AutoMigrate:           true,
+			MaxOpenConns:          50,
+			MaxIdleConns:          50,
+			PasswordHashAlgorithm: "argon2id",
+		},
+		Auth: Auth{
+			JWTAlgorithm: "HS256",
+			AccessTTL:    15 * time.Minute,
+			RefreshTTL:   30 * 24 * time.Hour,
+		},
+		Users: Users{
+			Retention:     30 * 24 * time.Hour,
+			PurgeInterval: time.Hour,
+		},
+		Log: Log{
+			Format: "text",
+			Level:  slog.LevelInfo,
+		},
+		Tracing: Tracing{
+			Exporter: "none",
+			Protocol: "http/protobuf",
+		},
+	}
+}
+
+// Production reports whether the application runs in production
+func (c *Config) Production() bool {
+	return c.Env == "production"
+}
+
+// Validate checks the configuration needed to serve the API, reporting
+// every invalid setting at once
+func (c *Config) Validate() error {
+	var v validator
+
+	v.port("PORT", c.HTTP.Port)
+	if c.GRPC.Port != 0 {
+		v.port("GRPC_PORT", c.GRPC.Port)
+		v.check(c.GRPC.Port != c.HTTP.Port, "GRPC_PORT", "must differ from PORT")
+	}
+	v.positive("IDEMPOTENCY_KEY_TTL", c.HTTP.IdempotencyKeyTTL)
+
+	c.Database.validate(&v)
+
+	switch strings.ToUpper(c.Auth.JWTAlgorithm) {
+	case "HS256":
+		v.check(len(c.Auth.JWTSecret) >= 32, "JWT_SECRET", "must be at least 32 bytes for HS256")
+	case "RS256", "EDDSA":
+		v.check(c.Auth.JWTPrivateKey != "", "JWT_PRIVATE_KEY", "is required for "+c.Auth.JWTAlgorithm)
+	default:
+		v.oneOf("JWT_ALGORITHM", c.Auth.JWTAlgorithm, "HS256", "RS256", "EdDSA")
+	}
+	v.positive("JWT_ACCESS_TTL", c.Auth.AccessTTL)
+	v.positive("JWT_REFRESH_TTL", c.Auth.RefreshTTL)
+
+	v.positive("USER_RETENTION", c.Users.Retention)
+	v.positive("USER_PURGE_INTERVAL", c.Users.PurgeInterval)
+
+	v.oneOf("LOG_FORMAT", c.Log.Format, "text", "json")
+
+	v.oneOf("OTEL_TRACES_EXPORTER", c.Tracing.Exporter, "none", "stdout", "file", "otlp")
+	if c.Tracing.Exporter == "file" {
+		v.required("OTEL_TRACES_FILE", c.Tracing.File)
+	}
+	v.oneOf("OTEL_EXPORTER_OTLP_PROTOCOL", c.Tracing.Protocol, "http/protobuf", "grpc")
+
+	return errors.Join(v.errs...)
+}
+
+// Validate checks the database settings alone, which is all that running
+// migrations needs
+func (d Database) Validate() error {
+	var v validator
+	d.validate(&v)
+	return errors.Join(v.errs...)
+}
+
+func (d Database) validate(v *validator) {
+	v.required("MYSQL_DB_HOST", d.Host)
+	v.port("MYSQL_DB_PORT", d.Port)
+	v.required("MYSQL_DB_DATABASE", d.Name)
+	v.required("MYSQL_DB_USERNAME", d.Username)
+	v.check(d.MaxOpenConns > 0, "MYSQL_DB_MAX_OPEN_CONNS", "must be positive")
+	v.check(d.MaxIdleConns >= 0, "MYSQL_DB_MAX_IDLE_CONNS", "must not be negative")
+	v.check(d.ConnMaxLifetime >= 0, "MYSQL_DB_CONN_MAX_LIFETIME", "must not be negative")
+	v.oneOf("PASSWORD_HASH_ALGORITHM", d.PasswordHashAlgorithm, "argon2id", "bcrypt")
+}
+
+// validator collects the errors of invalid settings, named after their
+// environment variables
+type validator struct {
+	errs []error
+}
+
+func (v *validator) check(ok bool, name, msg string) {
+	if !ok {
+		v.errs = append(v.errs, fmt.Errorf("%s: %s", name, msg))
+	}
+}
+
+func (v *validator) required(name, value string) {
+	v.check(value != "", name, "is required")
+}
+
+func (v *validator) port(name string, port int) {
+	v.check(port > 0 && port <= 65535, name, "must be between 1 and 65535")
+}
+
+func (v *validator) positive(name string, d time.Duration) {
+	v.check(d > 0, name, "must be positive")
+}
+
+func (v *validator) oneOf(name, value string, allowed ...string) {
+	for _, a := range allowed {
+		if strings.EqualFold(value, a) {
+			return
+		}
+	}
+	v.check(false, name, fmt.Sprintf("must be one of %s, got %q", strings.Join(allowed, ", "), value))
+}
@@ -0,0 +1,215 @@
+package config
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setRequired sets the environment variables without defaults
+func setRequired(t *testing.T) {
+	t.Helper()
+	t.Setenv("MYSQL_DB_HOST", "localhost")
+	t.Setenv("MYSQL_DB_DATABASE", "app")
+	t.Setenv("MYSQL_DB_USERNAME", "app")
+	t.Setenv("JWT_SECRET", strings.Repeat("s", 32))
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDefaults(t *testing.T) {
+	setRequired(t)
+
+	cfg, args, err := Load([]string{"migrate", "up"})
+	if err != nil {
+		t.Fatalf("failed to load configuration: %v", err)
+	}
+	if cfg.HTTP.Port != 8080 || cfg.Database.Port != 3306 || cfg.Auth.AccessTTL != 15*time.Minute {
+		t.Errorf("expected defaults, got %+v", cfg)
+	}
+	if strings.Join(args, " ") != "migrate up" {
+		t.Errorf("expected the remaining arguments, got %v", args)
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	setRequired(t)
+	file := writeFile(t, "config.yaml", `
+http:
+  port: 9000
+  cors_origins: [https://a.example, https://b.example]
+grpc:
+  port: 9001
+database:
+  max_open_conns: 10
+log:
+  level: debug
+`)
+	t.Setenv("CONFIG_FILE", file)
+	t.Setenv("GRPC_PORT", "9002")
+	t.Setenv("MYSQL_DB_MAX_OPEN_CONNS", "20")
+
+	cfg, _, err := Load([]string{"-database.max_open_conns", "30"})
+	if err != nil {
+		t.Fatalf("failed to load configuration: %v", err)
+	}
+
+	if cfg.HTTP.Port != 9000 {
+		t.Errorf("expected the file to override the default port, got %d", cfg.HTTP.Port)
+	}
+	if got := strings.Join(cfg.HTTP.CORSOrigins, ","); got != "https://a.example,https://b.example" {
+		t.Errorf("expected the origins of the file, got %s", got)
+	}
+	if cfg.GRPC.Port != 9002 {
+		t.Errorf("expected the environment to override the file, got %d", cfg.GRPC.Port)
+	}
+	if cfg.Database.MaxOpenConns != 30 {
+		t.Errorf("expected the flag to override the environment, got %d", cfg.Database.MaxOpenConns)
+	}
+	if cfg.Log.Level != slog.LevelDebug {
+		t.Errorf("expected debug level, got %v", cfg.Log.Level)
+	}
+}
+
+func TestTOML(t *testing.T) {
+	setRequired(t)
+	file := writeFile(t, "config.toml", `
+env = "production"
+
+[users]
+retention = "48h"
+`)
+
+	cfg, _, err := Load([]string{"-config", file})
+	if err != nil {
+		t.Fatalf("failed to load configuration: %v", err)
+	}
+	if !cfg.Production() || cfg.Users.Retention != 48*time.Hour {
+		t.Errorf("expected the settings of the file, got %+v", cfg)
+	}
+}
+
+func TestUnknownSetting(t *testing.T) {
+	setRequired(t)
+	file := writeFile(t, "config.yaml", "http:\n  prot: 9000\n")
+
+	_, _, err := Load([]string{"-config", file})
+	if err == nil || !strings.Contains(err.Error(), "unknown setting http.prot") {
+		t.Fatalf("expected an unknown setting error, got %v", err)
+	}
+}
+
+func TestSecretFile(t *testing.T) {
+	setRequired(t)
+	t.Setenv("MYSQL_DB_PASSWORD_FILE", writeFile(t, "password", "hunter2\n"))
+
+	cfg, _, err := Load(nil)
+	if err != nil {
+		t.Fatalf("failed to load configuration: %v", err)
+	}
+	if cfg.Database.Password != "hunter2" {
+		t.Errorf("expected the password from the file, got %q", cfg.Database.Password)
+	}
+
+	t.Setenv("MYSQL_DB_PASSWORD", "other")
+	if _, _, err := Load(nil); err == nil || !strings.Contains(err.Error(), "mutually exclusive") {
+		t.Fatalf("expected MYSQL_DB_PASSWORD and its file to conflict, got %v", err)
+	}
+}
+
+func TestValidation(t *testing.T) {
+	setRequired(t)
+	t.Setenv("MYSQL_DB_HOST", "")
+	t.Setenv("PORT", "70000")
+	t.Setenv("JWT_SECRET", "short")
+	t.Setenv("LOG_FORMAT", "xml")
+
+	cfg, _, err := Load(nil)
+	if err != nil {
+		t.Fatalf("failed to load configuration: %v", err)
+	}
+	err = cfg.Validate()
+	if err == nil {
+		t.Fatal("expected an invalid configuration")
+	}
+	for _, name := range []string{"MYSQL_DB_HOST", "PORT", "JWT_SECRET", "LOG_FORMAT"} {
+		if !strings.Contains(err.Error(), name+":") {
+			t.Errorf("expected an error about %s, got %v", name, err)
+		}
+	}
+
+	t.Setenv("JWT_ACCESS_TTL", "soon")
+	if _, _, err := Load(nil); err == nil || !strings.Contains(err.Error(), `env JWT_ACCESS_TTL: invalid auth.access_ttl "soon"`) {
+		t.Errorf("expected a parse error naming its source, got %v", err)
+	}
+}
+
+func TestDatabaseValidation(t *testing.T) {
+	setRequired(t)
+	t.Setenv("JWT_SECRET", "")
+
+	cfg, _, err := Load([]string{"migrate", "up"})
+	if err != nil {
+		t.Fatalf("failed to load configuration: %v", err)
+	}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "JWT_SECRET:") {
+		t.Errorf("expected the server to require JWT_SECRET, got %v", err)
+	}
+	if err := cfg.Database.Validate(); err != nil {
+		t.Errorf("expected migrations to run without JWT_SECRET, got %v", err)
+	}
+
+	t.Setenv("MYSQL_DB_HOST", "")
+	cfg, _, err = Load(nil)
+	if err != nil {
+		t.Fatalf("failed to load configuration: %v", err)
+	}
+	if err := cfg.Database.Validate(); err == nil || !strings.Contains(err.Error(), "MYSQL_DB_HOST:") {
+		t.Errorf("expected an error about MYSQL_DB_HOST, got %v", err)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	setRequired(t)
+	t.Setenv("MYSQL_DB_PASSWORD", "hunter2")
+
+	cfg, _, err := Load(nil)
+	if err != nil {
+		t.Fatalf("failed to load configuration: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := cfg.Write(&buf); err != nil {
+		t.Fatalf("failed to write configuration: %v", err)
+	}
+	out := buf.String()
+	if strings.Contains(out, "hunter2") || strings.Contains(out, strings.Repeat("s", 32)) {
+		t.Errorf("expected secrets to be redacted, got\n%s", out)
+	}
+	for _, line := range []string{"  password: \"[REDACTED]\" # MYSQL_DB_PASSWORD", "  port: 8080 # PORT", "  cursor_secret: \"\" # CURSOR_SECRET"} {
+		if !strings.Contains(out, line) {
+			t.Errorf("expected %q, got\n%s", line, out)
+		}
+	}
+
+	// The output is a valid configuration file
+	file := writeFile(t, "config.yaml", out)
+	reloaded, _, err := Load([]string{"-config", file, "-database.password", "hunter2"})
+	if err != nil {
+		t.Fatalf("failed to reload configuration: %v", err)
+	}
+	if reloaded.Database.Password != "hunter2" || reloaded.HTTP.Port != cfg.HTTP.Port {
+		t.Errorf("expected the same configuration, got %+v", reloaded)
+	}
+}
@@ -0,0 +1,274 @@
+package config
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Redacted replaces the values of secrets when printing a configuration
+const Redacted = "[REDACTED]"
+
+// Load returns the configuration built from Default, the file named by the
+// -config flag or CONFIG_FILE, the environment and the flags in args, in
+// increasing order of precedence. args are the command-line arguments
+// without the program name; the arguments left after the flags are
+// returned. Settings are parsed but not validated, as commands need
+// different ones: see Config.Validate and Database.Validate.
+//
+// Every setting has a flag named after its key, such as -http.port.
+// flag.ErrHelp is returned if -h or -help is given.
+func Load(args []string) (*Config, []string, error) {
+	cfg := Default()
+	fields := fieldsOf(cfg)
+
+	fs := flag.NewFlagSet("api", flag.ContinueOnError)
+	file := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML configuration `file` (env CONFIG_FILE)")
+	flags := map[string]string{}
+	for _, f := range fields {
+		fs.Func(f.key, fmt.Sprintf("overrides env %s", f.env), func(s string) error {
+			flags[f.key] = s
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	var errs []error
+	if *file != "" {
+		values, err := readFile(*file)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, f := range fields {
+			if s, ok := values[f.key]; ok {
+				errs = append(errs, f.set(s, *file))
+				delete(values, f.key)
+			}
+		}
+		for key := range values {
+			errs = append(errs, fmt.Errorf("%s: unknown setting %s", *file, key))
+		}
+	}
+
+	for _, f := range fields {
+		s, ok, err := lookupEnv(f.env)
+		if err != nil {
+			errs = append(errs, err)
+		} else if ok {
+			errs = append(errs, f.set(s, "env "+f.env))
+		}
+	}
+
+	for _, f := range fields {
+		if s, ok := flags[f.key]; ok {
+			errs = append(errs, f.set(s, "flag -"+f.key))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+// lookupEnv returns the value of the environment variable name, or the
+// contents of the file named by name_FILE without trailing newlines. Empty
+// variables count as unset, as in the .env.example template.
+func lookupEnv(name string) (string, bool, error) {
+	value := os.Getenv(name)
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		return value, value != "", nil
+	}
+	if value != "" {
+		return "", false, fmt.Errorf("%s and %s_FILE are mutually exclusive", name, name)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("%s_FILE: %w", name, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), true, nil
+}
+
+// readFile reads a YAML or TOML configuration file, telling them apart by
+// extension, and returns its settings by key
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration: %w", err)
+	}
+
+	var tree map[string]any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	case ".toml":
+		err = toml.Unmarshal(data, &tree)
+	default:
+		return nil, fmt.Errorf("unsupported configuration file extension %q, want .yaml, .yml or .toml", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	values := map[string]string{}
+	flatten("", tree, values)
+	return values, nil
+}
+
+// flatten stores the leaves of tree in values by their dotted key, with
+// lists joined by commas as in environment variables
+func flatten(prefix string, tree map[string]any, values map[string]string) {
+	for key, v := range tree {
+		key = prefix + key
+		switch v := v.(type) {
+		case map[string]any:
+			flatten(key+".", v, values)
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[key] = strings.Join(items, ",")
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+}
+
+// field is a setting of a Config
+type field struct {
+	key    string // Dotted key in files, also the flag name
+	env    string
+	secret bool
+	value  reflect.Value
+}
+
+// fieldsOf returns the settings of cfg in declaration order
+func fieldsOf(cfg *Config) []field {
+	var fields []field
+	var walk func(prefix string, v reflect.Value)
+	walk = func(prefix string, v reflect.Value) {
+		t := v.Type()
+		for i := range t.NumField() {
+			sf := t.Field(i)
+			key := prefix + sf.Tag.Get("config")
+			if sf.Type.Kind() == reflect.Struct && sf.Tag.Get("env") == "" {
+				walk(key+".", v.Field(i))
+				continue
+			}
+			fields = append(fields, field{
+				key:    key,
+				env:    sf.Tag.Get("env"),
+				secret: sf.Tag.Get("secret") == "true",
+				value:  v.Field(i),
+			})
+		}
+	}
+	walk("", reflect.ValueOf(cfg).Elem())
+	return fields
+}
+
+var durationType = reflect.TypeFor[time.Duration]()
+
+// set parses s into the field, naming source in errors
+func (f field) set(s, source string) error {
+	if u, ok := f.value.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		if err := u.UnmarshalText([]byte(s)); err != nil {
+			return fmt.Errorf("%s: invalid %s: %v", source, f.key, err)
+		}
+		return nil
+	}
+
+	var err error
+	switch {
+	case f.value.Type() == durationType:
+		var d time.Duration
+		d, err = time.ParseDuration(s)
+		f.value.SetInt(int64(d))
+	case f.value.Kind() == reflect.String:
+		f.value.SetString(s)
+	case f.value.Kind() == reflect.Int:
+		var n int
+		n, err = strconv.Atoi(s)
+		f.value.SetInt(int64(n))
+	case f.value.Kind() == reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(s)
+		f.value.SetBool(b)
+	case f.value.Kind() == reflect.Slice && f.value.Type().Elem().Kind() == reflect.String:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		f.value.Set(reflect.ValueOf(items))
+	default:
+		panic("config: unsupported type " + f.value.Type().String())
+	}
+	if err != nil {
+		return fmt.Errorf("%s: invalid %s %q", source, f.key, s)
+	}
+	return nil
+}
+
+// format returns the value of the field as YAML
+func (f field) format() string {
+	v := f.value.Interface()
+	if f.secret && !f.value.IsZero() {
+		v = Redacted
+	}
+	switch x := v.(type) {
+	case time.Duration:
+		v = x.String()
+	case encoding.TextMarshaler:
+		text, _ := x.MarshalText()
+		v = string(text)
+	case []string:
+		if x == nil {
+			v = []string{}
+		}
+	}
+	// JSON scalars and arrays are valid YAML
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// Write writes the configuration to w as a YAML file, with secrets
+// redacted
+func (c *Config) Write(w io.Writer) error {
+	section := ""
+	for _, f := range fieldsOf(c) {
+		indent := ""
+		if i := strings.LastIndexByte(f.key, '.'); i >= 0 {
+			if f.key[:i] != section {
+				section = f.key[:i]
+				if _, err := fmt.Fprintf(w, "%s:\n", section); err != nil {
+					return err
+				}
+			}
+			indent = "  "
+		}
+		name := f.key[strings.LastIndexByte(f.key, '.')+1:]
+		if _, err := fmt.Fprintf(w, "%s%s: %s # %s\n", indent, name, f.format(), f.env); err != nil {
+			return err
+		}
+	}
+	return nil
+}
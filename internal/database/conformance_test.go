@@ -0,0 +1,12 @@
+package mysql_test
+
+import (
+	"testing"
+
+	"golang-backend/internal/database"
+	"golang-backend/internal/database/storetest"
+)
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, mysql.NewTestService)
+}
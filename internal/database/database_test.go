@@ -2,15 +2,26 @@ package mysql
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"log"
+	"log/slog"
 	"testing"
 	"time"
 
+	"golang-backend/internal/auth"
+	"golang-backend/internal/config"
+
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/modules/mysql"
 	"github.com/testcontainers/testcontainers-go/wait"
+	"golang.org/x/crypto/bcrypt"
 )
 
+// testConfig is the configuration of the database in the test container
+var testConfig = config.Default().Database
+
 func mustStartMySQLContainer() (func(context.Context, ...testcontainers.TerminateOption) error, error) {
 	var (
 		dbName = "database"
@@ -29,9 +40,9 @@ func mustStartMySQLContainer() (func(context.Context, ...testcontainers.Terminat
 		return nil, err
 	}
 
-	dbname = dbName
-	password = dbPwd
-	username = dbUser
+	testConfig.Name = dbName
+	testConfig.Password = dbPwd
+	testConfig.Username = dbUser
 
 	dbHost, err := dbContainer.Host(context.Background())
 	if err != nil {
@@ -43,8 +54,8 @@ func mustStartMySQLContainer() (func(context.Context, ...testcontainers.Terminat
 		return dbContainer.Terminate, err
 	}
 
-	host = dbHost
-	port = dbPort.Port()
+	testConfig.Host = dbHost
+	testConfig.Port = dbPort.Int()
 
 	return dbContainer.Terminate, err
 }
@@ -62,33 +73,76 @@ func TestMain(m *testing.M) {
 	}
 }
 
+// newTestService returns a service for the test database, closed at the
+// end of the test
+func newTestService(t *testing.T) Service {
+	t.Helper()
+	srv, err := New(testConfig, slog.Default(), nil)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
 func TestNew(t *testing.T) {
-	srv := New()
+	srv := newTestService(t)
 	if srv == nil {
 		t.Fatal("New() returned nil")
 	}
+
+	// Services are independent
+	other := newTestService(t)
+	if err := other.Close(); err != nil {
+		t.Fatalf("failed to close service: %v", err)
+	}
+	if err := srv.Ping(context.Background()); err != nil {
+		t.Fatalf("expected the first service to be usable, got %v", err)
+	}
 }
 
-func TestHealth(t *testing.T) {
-	srv := New()
+func TestNewInvalidConfig(t *testing.T) {
+	cfg := testConfig
+	cfg.PasswordHashAlgorithm = "md5"
+	if _, err := New(cfg, slog.Default(), nil); err == nil {
+		t.Fatal("expected an unsupported password hash algorithm to be rejected")
+	}
+}
 
-	stats := srv.Health()
+func TestPing(t *testing.T) {
+	srv := newTestService(t)
 
-	if stats["status"] != "up" {
-		t.Fatalf("expected status to be up, got %s", stats["status"])
+	if err := srv.Ping(context.Background()); err != nil {
+		t.Fatalf("expected the database to be reachable, got %v", err)
 	}
+}
+
+func TestPendingMigrations(t *testing.T) {
+	srv := newTestService(t) // Applies all migrations
 
-	if _, ok := stats["error"]; ok {
-		t.Fatalf("expected error not to be present")
+	pending, err := srv.PendingMigrations(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get pending migrations: %v", err)
 	}
+	if len(pending) != 0 {
+		t.Fatalf("expected no pending migrations, got %v", pending)
+	}
+}
 
-	if stats["message"] != "It's healthy" {
-		t.Fatalf("expected message to be 'It's healthy', got %s", stats["message"])
+func TestPoolWarnings(t *testing.T) {
+	if warnings := PoolWarnings(sql.DBStats{MaxOpenConnections: 50, OpenConnections: 10}); len(warnings) != 0 {
+		t.Fatalf("expected no warnings, got %v", warnings)
+	}
+	if warnings := PoolWarnings(sql.DBStats{MaxOpenConnections: 50, OpenConnections: 45, WaitCount: 2000}); len(warnings) != 2 {
+		t.Fatalf("expected warnings about load and waits, got %v", warnings)
 	}
 }
 
 func TestClose(t *testing.T) {
-	srv := New()
+	srv, err := New(testConfig, slog.Default(), nil)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
 
 	if srv.Close() != nil {
 		t.Fatalf("expected Close() to return nil")
@@ -96,9 +150,7 @@ func TestClose(t *testing.T) {
 }
 
 func TestUserCRUD(t *testing.T) {
-	// Create a new database instance for this test
-	dbInstance = nil // Reset the singleton
-	srv := New()
+	srv := newTestService(t)
 	ctx := context.Background()
 
 	// Test CreateUser
@@ -119,6 +171,19 @@ func TestUserCRUD(t *testing.T) {
 		t.Fatalf("expected user ID to be positive, got %d", user.ID)
 	}
 
+	if user.Password == "password123" {
+		t.Fatalf("expected password to be stored hashed")
+	}
+
+	// Test VerifyPassword
+	if err := srv.VerifyPassword(ctx, user, "password123"); err != nil {
+		t.Fatalf("failed to verify password: %v", err)
+	}
+
+	if err := srv.VerifyPassword(ctx, user, "wrongpassword"); err != ErrInvalidPassword {
+		t.Fatalf("expected ErrInvalidPassword, got %v", err)
+	}
+
 	// Test GetUserByID
 	retrievedUser, err := srv.GetUserByID(ctx, user.ID)
 	if err != nil {
@@ -129,6 +194,16 @@ func TestUserCRUD(t *testing.T) {
 		t.Fatalf("expected username to match, got %s", retrievedUser.Username)
 	}
 
+	// Test GetUserRoles
+	roles, err := srv.GetUserRoles(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("failed to get user roles: %v", err)
+	}
+
+	if len(roles) != 0 {
+		t.Fatalf("expected new user to have no roles, got %v", roles)
+	}
+
 	// Test GetUserByEmail
 	userByEmail, err := srv.GetUserByEmail(ctx, "test@example.com")
 	if err != nil {
@@ -160,7 +235,7 @@ func TestUserCRUD(t *testing.T) {
 	}
 
 	// Test UpdateUser
-	updatedUser, err := srv.UpdateUser(ctx, user.ID, "updateduser", "updated@example.com")
+	updatedUser, err := srv.UpdateUser(ctx, user.ID, 0, "updateduser", "updated@example.com")
 	if err != nil {
 		t.Fatalf("failed to update user: %v", err)
 	}
@@ -174,11 +249,20 @@ func TestUserCRUD(t *testing.T) {
 	}
 
 	// Test UpdateUserPassword
-	err = srv.UpdateUserPassword(ctx, user.ID, "newpassword123")
+	err = srv.UpdateUserPassword(ctx, user.ID, 0, "newpassword123")
 	if err != nil {
 		t.Fatalf("failed to update password: %v", err)
 	}
 
+	updatedUser, err = srv.GetUserByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("failed to get user by ID: %v", err)
+	}
+
+	if err := srv.VerifyPassword(ctx, updatedUser, "newpassword123"); err != nil {
+		t.Fatalf("failed to verify updated password: %v", err)
+	}
+
 	// Test DeleteUser
 	err = srv.DeleteUser(ctx, user.ID)
 	if err != nil {
@@ -191,3 +275,686 @@ func TestUserCRUD(t *testing.T) {
 		t.Fatalf("expected user to be deleted")
 	}
 }
+
+func TestRefreshTokenRotation(t *testing.T) {
+	srv := newTestService(t)
+	ctx := context.Background()
+
+	user, err := srv.CreateUser(ctx, "refreshuser", "refresh@example.com", "password123")
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	defer srv.DeleteUser(ctx, user.ID)
+
+	now := time.Now()
+	expiresAt := now.Add(time.Hour)
+
+	token, err := srv.CreateRefreshToken(ctx, user.ID, "family1", "hash1", expiresAt)
+	if err != nil {
+		t.Fatalf("failed to create refresh token: %v", err)
+	}
+
+	if token.UserID != user.ID || token.FamilyID != "family1" {
+		t.Fatalf("unexpected refresh token: %+v", token)
+	}
+
+	// Test RotateRefreshToken
+	rotated, err := srv.RotateRefreshToken(ctx, "hash1", "hash2", now, expiresAt)
+	if err != nil {
+		t.Fatalf("failed to rotate refresh token: %v", err)
+	}
+
+	if rotated.FamilyID != "family1" || rotated.TokenHash != "hash2" {
+		t.Fatalf("expected rotated token to stay in family, got %+v", rotated)
+	}
+
+	// Replaying the used token must revoke the whole family
+	_, err = srv.RotateRefreshToken(ctx, "hash1", "hash3", now, expiresAt)
+	if err != ErrRefreshTokenReused {
+		t.Fatalf("expected ErrRefreshTokenReused, got %v", err)
+	}
+
+	_, err = srv.RotateRefreshToken(ctx, "hash2", "hash4", now, expiresAt)
+	if err != ErrRefreshTokenRevoked {
+		t.Fatalf("expected ErrRefreshTokenRevoked after reuse, got %v", err)
+	}
+
+	// Test RevokeRefreshTokenFamily
+	if _, err := srv.CreateRefreshToken(ctx, user.ID, "family2", "hash5", expiresAt); err != nil {
+		t.Fatalf("failed to create refresh token: %v", err)
+	}
+
+	if err := srv.RevokeRefreshTokenFamily(ctx, "family2", now); err != nil {
+		t.Fatalf("failed to revoke refresh token family: %v", err)
+	}
+
+	revoked, err := srv.GetRefreshToken(ctx, "hash5")
+	if err != nil {
+		t.Fatalf("failed to get refresh token: %v", err)
+	}
+
+	if revoked.RevokedAt == nil {
+		t.Fatalf("expected refresh token to be revoked")
+	}
+}
+
+func TestRoles(t *testing.T) {
+	srv := newTestService(t)
+	ctx := context.Background()
+
+	user, err := srv.CreateUser(ctx, "roleuser", "role@example.com", "password123")
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	defer srv.DeleteUser(ctx, user.ID)
+
+	// Default roles are seeded on startup
+	support, err := srv.GetRole(ctx, RoleSupport)
+	if err != nil {
+		t.Fatalf("failed to get support role: %v", err)
+	}
+
+	if len(support.Permissions) != 2 {
+		t.Fatalf("expected support role to have 2 permissions, got %v", support.Permissions)
+	}
+
+	// Test CreateRole and GrantPermission
+	if _, err := srv.CreateRole(ctx, "auditor", "Reads roles"); err != nil {
+		t.Fatalf("failed to create role: %v", err)
+	}
+
+	if _, err := srv.CreateRole(ctx, "auditor", ""); err != ErrRoleExists {
+		t.Fatalf("expected ErrRoleExists, got %v", err)
+	}
+
+	if err := srv.GrantPermission(ctx, "auditor", PermRolesRead); err != nil {
+		t.Fatalf("failed to grant permission: %v", err)
+	}
+
+	if err := srv.GrantPermission(ctx, "auditor", "nope:nope"); err != ErrPermissionNotFound {
+		t.Fatalf("expected ErrPermissionNotFound, got %v", err)
+	}
+
+	// Test AssignRole and GetUserPermissions
+	if err := srv.AssignRole(ctx, user.ID, "auditor"); err != nil {
+		t.Fatalf("failed to assign role: %v", err)
+	}
+
+	if err := srv.AssignRole(ctx, user.ID, RoleSupport); err != nil {
+		t.Fatalf("failed to assign role: %v", err)
+	}
+
+	permissions, err := srv.GetUserPermissions(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("failed to get user permissions: %v", err)
+	}
+
+	if len(permissions) != 3 {
+		t.Fatalf("expected 3 permissions, got %v", permissions)
+	}
+
+	// Test UnassignRole and DeleteRole
+	if err := srv.UnassignRole(ctx, user.ID, RoleSupport); err != nil {
+		t.Fatalf("failed to unassign role: %v", err)
+	}
+
+	if err := srv.DeleteRole(ctx, "auditor"); err != nil {
+		t.Fatalf("failed to delete role: %v", err)
+	}
+
+	roles, err := srv.GetUserRoles(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("failed to get user roles: %v", err)
+	}
+
+	if len(roles) != 0 {
+		t.Fatalf("expected deleted role to be unassigned, got %v", roles)
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	newTestService(t) // Applies all migrations
+	ctx := context.Background()
+
+	db, err := Open(testConfig)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	migrator, err := NewMigrator(db, slog.Default())
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatalf("failed to get migration status: %v", err)
+	}
+
+	for _, s := range status {
+		if !s.Applied {
+			t.Fatalf("expected migration %d_%s to be applied", s.Version, s.Name)
+		}
+	}
+
+	latest := status[len(status)-1].Version
+
+	// Status does not create the schema_migrations table, as it backs the
+	// readiness probe
+	if _, err := db.Exec(`RENAME TABLE schema_migrations TO schema_migrations_saved`); err != nil {
+		t.Fatalf("failed to rename schema_migrations: %v", err)
+	}
+	status, err = migrator.Status(ctx)
+	if _, renameErr := db.Exec(`RENAME TABLE schema_migrations_saved TO schema_migrations`); renameErr != nil {
+		t.Fatalf("failed to restore schema_migrations: %v", renameErr)
+	}
+	if err != nil {
+		t.Fatalf("failed to get migration status without schema_migrations: %v", err)
+	}
+	if len(status) == 0 || status[0].Applied {
+		t.Fatalf("expected every migration to be pending without schema_migrations, got %+v", status)
+	}
+
+	// Test Down and Up
+	if err := migrator.Down(ctx); err != nil {
+		t.Fatalf("failed to roll back migration: %v", err)
+	}
+
+	status, err = migrator.Status(ctx)
+	if err != nil {
+		t.Fatalf("failed to get migration status: %v", err)
+	}
+
+	if status[len(status)-1].Applied {
+		t.Fatalf("expected latest migration to be rolled back")
+	}
+
+	if err := migrator.Up(ctx); err != nil {
+		t.Fatalf("failed to apply migrations: %v", err)
+	}
+
+	// Test To
+	if err := migrator.To(ctx, latest); err != nil {
+		t.Fatalf("failed to migrate to latest version: %v", err)
+	}
+
+	if err := migrator.To(ctx, latest+1000); !errors.Is(err, ErrMigrationNotFound) {
+		t.Fatalf("expected ErrMigrationNotFound, got %v", err)
+	}
+
+	// Modified migrations must be detected
+	_, err = db.Exec(`UPDATE schema_migrations SET checksum = 'tampered' WHERE version = ?`, latest)
+	if err != nil {
+		t.Fatalf("failed to tamper with checksum: %v", err)
+	}
+
+	if err := migrator.Up(ctx); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+
+	if _, err := db.Exec(`UPDATE schema_migrations SET checksum = ? WHERE version = ?`, migrator.find(latest).Checksum, latest); err != nil {
+		t.Fatalf("failed to restore checksum: %v", err)
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	script := `
+-- comment
+CREATE TABLE a (
+	id INT
+);
+
+INSERT INTO a VALUES (1);
+`
+
+	statements := splitStatements(script)
+	if len(statements) != 2 {
+		t.Fatalf("expected 2 statements, got %d: %q", len(statements), statements)
+	}
+
+	if statements[1] != "INSERT INTO a VALUES (1);" {
+		t.Fatalf("unexpected statement %q", statements[1])
+	}
+}
+
+func TestDuplicateUsers(t *testing.T) {
+	srv := newTestService(t)
+	ctx := context.Background()
+
+	first, err := srv.CreateUser(ctx, "dupuser", "dup@example.com", "password123")
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	defer srv.DeleteUser(ctx, first.ID)
+
+	second, err := srv.CreateUser(ctx, "otheruser", "other@example.com", "password123")
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	defer srv.DeleteUser(ctx, second.ID)
+
+	if _, err := srv.CreateUser(ctx, "dupuser", "new@example.com", "password123"); !errors.Is(err, ErrDuplicateUsername) {
+		t.Fatalf("expected ErrDuplicateUsername, got %v", err)
+	}
+
+	if _, err := srv.CreateUser(ctx, "newuser", "dup@example.com", "password123"); !errors.Is(err, ErrDuplicateEmail) {
+		t.Fatalf("expected ErrDuplicateEmail, got %v", err)
+	}
+
+	if _, err := srv.UpdateUser(ctx, second.ID, 0, "dupuser", "other@example.com"); !errors.Is(err, ErrDuplicateUsername) {
+		t.Fatalf("expected ErrDuplicateUsername, got %v", err)
+	}
+
+	if _, err := srv.UpdateUser(ctx, second.ID, 0, "otheruser", "dup@example.com"); !errors.Is(err, ErrDuplicateEmail) {
+		t.Fatalf("expected ErrDuplicateEmail, got %v", err)
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	srv := newTestService(t)
+	ctx := context.Background()
+
+	var ids []int
+	for i := 0; i < 5; i++ {
+		user, err := srv.CreateUser(ctx, fmt.Sprintf("pageuser%d", i), fmt.Sprintf("page%d@example.com", i), "password123")
+		if err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+		ids = append(ids, user.ID)
+		defer srv.DeleteUser(ctx, user.ID)
+	}
+
+	all, err := srv.GetAllUsers(ctx)
+	if err != nil {
+		t.Fatalf("failed to get all users: %v", err)
+	}
+
+	// Walk forwards two users at a time
+	var seen []int
+	params := ListUsersParams{Limit: 2}
+	for {
+		page, err := srv.ListUsers(ctx, params)
+		if err != nil {
+			t.Fatalf("failed to list users: %v", err)
+		}
+		for _, u := range page.Users {
+			seen = append(seen, u.ID)
+		}
+		if !page.HasNext {
+			break
+		}
+		cur := CursorOf(page.Users[len(page.Users)-1], SortByCreatedAt)
+		params = ListUsersParams{Limit: 2, After: &cur}
+	}
+
+	if len(seen) != len(all) {
+		t.Fatalf("expected to see %d users, saw %d", len(all), len(seen))
+	}
+
+	// Walking back from the last page must return the previous page
+	last := &User{ID: seen[len(seen)-1]}
+	for _, u := range all {
+		if u.ID == last.ID {
+			last = u
+		}
+	}
+	cur := CursorOf(last, SortByCreatedAt)
+	page, err := srv.ListUsers(ctx, ListUsersParams{Limit: 2, Before: &cur})
+	if err != nil {
+		t.Fatalf("failed to list users: %v", err)
+	}
+
+	if len(page.Users) != 2 || page.Users[1].ID != seen[len(seen)-2] {
+		t.Fatalf("expected previous page to end with user %d, got %+v", seen[len(seen)-2], page.Users)
+	}
+
+	if !page.HasNext {
+		t.Fatalf("expected page before the last user to have a next page")
+	}
+}
+
+func TestListUsersFilterAndSort(t *testing.T) {
+	srv := newTestService(t)
+	ctx := context.Background()
+
+	for _, name := range []string{"filter_b", "filter_a", "filter_c", "filterxa"} {
+		user, err := srv.CreateUser(ctx, name, name+"@filter.example.com", "password123")
+		if err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+		defer srv.DeleteUser(ctx, user.ID)
+	}
+
+	usernames := func(users []*User) []string {
+		var names []string
+		for _, u := range users {
+			names = append(names, u.Username)
+		}
+		return names
+	}
+
+	// "_" must match literally rather than as a LIKE wildcard
+	page, err := srv.ListUsers(ctx, ListUsersParams{
+		Limit:  10,
+		Filter: UserFilter{UsernamePrefix: "filter_"},
+		Sort:   UserSort{Field: SortByUsername},
+	})
+	if err != nil {
+		t.Fatalf("failed to list users: %v", err)
+	}
+	if got := usernames(page.Users); fmt.Sprint(got) != "[filter_a filter_b filter_c]" {
+		t.Fatalf("unexpected users %v", got)
+	}
+
+	// Page through in descending username order
+	page, err = srv.ListUsers(ctx, ListUsersParams{
+		Limit:  2,
+		Filter: UserFilter{Search: "filter"},
+		Sort:   UserSort{Field: SortByUsername, Desc: true},
+	})
+	if err != nil {
+		t.Fatalf("failed to list users: %v", err)
+	}
+	if got := usernames(page.Users); fmt.Sprint(got) != "[filterxa filter_c]" || !page.HasNext {
+		t.Fatalf("unexpected first page %v (has next %v)", got, page.HasNext)
+	}
+
+	cur := CursorOf(page.Users[1], SortByUsername)
+	page, err = srv.ListUsers(ctx, ListUsersParams{
+		Limit:  2,
+		After:  &cur,
+		Filter: UserFilter{Search: "filter"},
+		Sort:   UserSort{Field: SortByUsername, Desc: true},
+	})
+	if err != nil {
+		t.Fatalf("failed to list users: %v", err)
+	}
+	if got := usernames(page.Users); fmt.Sprint(got) != "[filter_b filter_a]" || page.HasNext {
+		t.Fatalf("unexpected second page %v (has next %v)", got, page.HasNext)
+	}
+
+	future := time.Now().Add(time.Hour)
+	page, err = srv.ListUsers(ctx, ListUsersParams{Limit: 10, Filter: UserFilter{CreatedAfter: &future}})
+	if err != nil {
+		t.Fatalf("failed to list users: %v", err)
+	}
+	if len(page.Users) != 0 {
+		t.Fatalf("expected no users created in the future, got %v", usernames(page.Users))
+	}
+
+	if _, err := srv.ListUsers(ctx, ListUsersParams{Limit: 10, Sort: UserSort{Field: "password"}}); !errors.Is(err, ErrInvalidSortField) {
+		t.Fatalf("expected ErrInvalidSortField, got %v", err)
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	srv := newTestService(t)
+	ctx := context.Background()
+
+	user, err := srv.CreateUser(ctx, "softdeleted", "softdeleted@example.com", "password123")
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	hash := "softdeletehash"
+	if _, err := srv.CreateRefreshToken(ctx, user.ID, "softdeletefamily", hash, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("failed to store refresh token: %v", err)
+	}
+
+	if err := srv.DeleteUser(ctx, user.ID); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+	if err := srv.DeleteUser(ctx, user.ID); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("expected deleting twice to return ErrUserNotFound, got %v", err)
+	}
+
+	// Deleted users are hidden from every lookup
+	if _, err := srv.GetUserByUsername(ctx, "softdeleted"); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
+	}
+	if _, err := srv.GetUserByEmail(ctx, "softdeleted@example.com"); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
+	}
+	if _, err := srv.UpdateUser(ctx, user.ID, 0, "softdeleted", "changed@example.com"); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
+	}
+
+	token, err := srv.GetRefreshToken(ctx, hash)
+	if err != nil {
+		t.Fatalf("failed to get refresh token: %v", err)
+	}
+	if token.RevokedAt == nil {
+		t.Fatalf("expected refresh tokens of deleted user to be revoked")
+	}
+
+	page, err := srv.ListDeletedUsers(ctx, ListUsersParams{Limit: 10, Filter: UserFilter{UsernamePrefix: "softdeleted"}})
+	if err != nil {
+		t.Fatalf("failed to list deleted users: %v", err)
+	}
+	if len(page.Users) != 1 || page.Users[0].ID != user.ID || page.Users[0].DeletedAt == nil {
+		t.Fatalf("expected deleted user in listing, got %+v", page.Users)
+	}
+
+	restored, err := srv.RestoreUser(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("failed to restore user: %v", err)
+	}
+	if restored.DeletedAt != nil || restored.Email != "softdeleted@example.com" {
+		t.Fatalf("unexpected restored user %+v", restored)
+	}
+	if _, err := srv.RestoreUser(ctx, user.ID); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("expected restoring an active user to return ErrUserNotFound, got %v", err)
+	}
+
+	// Purging only removes users deleted before the cutoff
+	if err := srv.DeleteUser(ctx, user.ID); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+	if _, err := srv.PurgeDeletedUsers(ctx, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("failed to purge users: %v", err)
+	}
+	if _, err := srv.RestoreUser(ctx, user.ID); err != nil {
+		t.Fatalf("expected recently deleted user to survive purge: %v", err)
+	}
+
+	if err := srv.DeleteUser(ctx, user.ID); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+	purged, err := srv.PurgeDeletedUsers(ctx, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("failed to purge users: %v", err)
+	}
+	if purged < 1 {
+		t.Fatalf("expected at least one purged user, got %d", purged)
+	}
+	if _, err := srv.RestoreUser(ctx, user.ID); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("expected purged user to be gone, got %v", err)
+	}
+}
+
+func TestUserVersion(t *testing.T) {
+	srv := newTestService(t)
+	ctx := context.Background()
+
+	user, err := srv.CreateUser(ctx, "versioneduser", "versioned@example.com", "password123")
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	defer srv.DeleteUser(ctx, user.ID)
+
+	if user.Version != 1 {
+		t.Fatalf("expected new user at version 1, got %d", user.Version)
+	}
+
+	updated, err := srv.UpdateUser(ctx, user.ID, user.Version, "versioneduser2", "versioned@example.com")
+	if err != nil {
+		t.Fatalf("failed to update user: %v", err)
+	}
+	if updated.Version != 2 {
+		t.Fatalf("expected version 2 after update, got %d", updated.Version)
+	}
+
+	// A second writer still holding version 1 loses
+	if _, err := srv.UpdateUser(ctx, user.ID, user.Version, "versioneduser3", "versioned@example.com"); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected ErrVersionConflict, got %v", err)
+	}
+	if err := srv.UpdateUserPassword(ctx, user.ID, user.Version, "newpassword123"); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected ErrVersionConflict, got %v", err)
+	}
+
+	if err := srv.UpdateUserPassword(ctx, user.ID, updated.Version, "newpassword123"); err != nil {
+		t.Fatalf("failed to update password: %v", err)
+	}
+
+	if _, err := srv.UpdateUser(ctx, user.ID+1000000, 1, "nobody", "nobody@example.com"); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func TestPatchUser(t *testing.T) {
+	srv := newTestService(t)
+	ctx := context.Background()
+
+	user, err := srv.CreateUser(ctx, "patcheduser", "patched@example.com", "password123")
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	defer srv.DeleteUser(ctx, user.ID)
+
+	email := "patched2@example.com"
+	patched, err := srv.PatchUser(ctx, user.ID, user.Version, UserChanges{Email: &email})
+	if err != nil {
+		t.Fatalf("failed to patch user: %v", err)
+	}
+	if patched.Username != "patcheduser" || patched.Email != email || patched.Version != user.Version+1 {
+		t.Fatalf("unexpected patched user %+v", patched)
+	}
+
+	if _, err := srv.PatchUser(ctx, user.ID, user.Version, UserChanges{}); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected ErrVersionConflict, got %v", err)
+	}
+}
+
+func TestBatchLookups(t *testing.T) {
+	srv := newTestService(t)
+	ctx := context.Background()
+
+	alice, err := srv.CreateUser(ctx, "batch_alice", "batch_alice@example.com", "password123")
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	defer srv.DeleteUser(ctx, alice.ID)
+
+	bob, err := srv.CreateUser(ctx, "batch_bob", "batch_bob@example.com", "password123")
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	defer srv.DeleteUser(ctx, bob.ID)
+
+	if err := srv.AssignRole(ctx, alice.ID, RoleSupport); err != nil {
+		t.Fatalf("failed to assign role: %v", err)
+	}
+
+	users, err := srv.GetUsersByIDs(ctx, []int{bob.ID, alice.ID, 0})
+	if err != nil {
+		t.Fatalf("failed to get users: %v", err)
+	}
+	if len(users) != 2 || users[0].ID != alice.ID || users[1].ID != bob.ID {
+		t.Fatalf("expected alice and bob ordered by id, got %v", users)
+	}
+
+	roles, err := srv.GetRolesByUserIDs(ctx, []int{alice.ID, bob.ID})
+	if err != nil {
+		t.Fatalf("failed to get roles: %v", err)
+	}
+	if len(roles[alice.ID]) != 1 || roles[alice.ID][0] != RoleSupport || len(roles[bob.ID]) != 0 {
+		t.Fatalf("expected alice to have the support role and bob none, got %v", roles)
+	}
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	srv := newTestService(t)
+	ctx := context.Background()
+	now := time.Now()
+	expires := now.Add(time.Hour)
+	stale := now.Add(-time.Minute)
+
+	if _, err := srv.ClaimIdempotencyKey(ctx, 0, "idem-1", "fingerprint-1", now, expires, stale); err != nil {
+		t.Fatalf("failed to claim key: %v", err)
+	}
+
+	// In-flight and completed keys are returned to later claims
+	existing, err := srv.ClaimIdempotencyKey(ctx, 0, "idem-1", "fingerprint-2", now, expires, stale)
+	if !errors.Is(err, ErrIdempotencyKeyExists) || existing.Fingerprint != "fingerprint-1" || existing.StatusCode != 0 {
+		t.Fatalf("expected the in-flight key, got %+v, %v", existing, err)
+	}
+
+	header := map[string][]string{"Content-Type": {"application/json"}}
+	if err := srv.CompleteIdempotencyKey(ctx, 0, "idem-1", 201, header, []byte(`{"id":1}`)); err != nil {
+		t.Fatalf("failed to complete key: %v", err)
+	}
+	existing, err = srv.ClaimIdempotencyKey(ctx, 0, "idem-1", "fingerprint-1", now, expires, stale)
+	if !errors.Is(err, ErrIdempotencyKeyExists) || existing.StatusCode != 201 ||
+		existing.Header["Content-Type"][0] != "application/json" || string(existing.Body) != `{"id":1}` {
+		t.Fatalf("expected the completed key, got %+v, %v", existing, err)
+	}
+
+	// Keys are scoped to users
+	if _, err := srv.ClaimIdempotencyKey(ctx, 1, "idem-1", "fingerprint-1", now, expires, stale); err != nil {
+		t.Fatalf("failed to claim key of another user: %v", err)
+	}
+
+	// Released keys can be claimed again, and so can abandoned ones
+	if err := srv.ReleaseIdempotencyKey(ctx, 1, "idem-1"); err != nil {
+		t.Fatalf("failed to release key: %v", err)
+	}
+	if _, err := srv.ClaimIdempotencyKey(ctx, 1, "idem-1", "fingerprint-2", now, expires, stale); err != nil {
+		t.Fatalf("failed to claim released key: %v", err)
+	}
+	if _, err := srv.ClaimIdempotencyKey(ctx, 1, "idem-1", "fingerprint-3", now, expires, time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("failed to take over abandoned key: %v", err)
+	}
+
+	// Expired keys are purged
+	if _, err := srv.ClaimIdempotencyKey(ctx, 0, "idem-expired", "fingerprint", now, time.Now().Add(-time.Hour), stale); err != nil {
+		t.Fatalf("failed to claim key: %v", err)
+	}
+	purged, err := srv.PurgeExpiredIdempotencyKeys(ctx, time.Now())
+	if err != nil || purged != 1 {
+		t.Fatalf("expected 1 expired key to be purged, got %d, %v", purged, err)
+	}
+	if _, err := srv.GetIdempotencyKey(ctx, 0, "idem-expired"); !errors.Is(err, ErrIdempotencyKeyNotFound) {
+		t.Fatalf("expected ErrIdempotencyKeyNotFound, got %v", err)
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	hasher := &auth.BcryptHasher{Cost: bcrypt.MinCost}
+	hash, err := hasher.Hash("password123")
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		stored   string
+		password string
+		want     error
+	}{
+		{"hash", hash, "password123", nil},
+		{"wrong password", hash, "wrong", ErrInvalidPassword},
+		{"legacy plaintext", "password123", "password123", nil},
+		{"wrong legacy plaintext", "password123", "wrong", ErrInvalidPassword},
+		{"malformed bcrypt hash", "$2a$10$short", "$2a$10$short", auth.ErrMalformedHash},
+		{"hash of an unsupported algorithm", "$argon2id$v=19$m=65536,t=3,p=2$c2FsdA$a2V5", "$argon2id$v=19$m=65536,t=3,p=2$c2FsdA$a2V5", auth.ErrUnsupportedHash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkPassword(hasher, tt.stored, tt.password)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,5 @@
+package mysql
+
+// NewTestService is newTestService for the conformance tests, which live in
+// the external test package to avoid an import cycle with storetest
+var NewTestService = newTestService
@@ -0,0 +1,210 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+var (
+	ErrIdempotencyKeyExists   = errors.New("idempotency key exists")
+	ErrIdempotencyKeyNotFound = errors.New("idempotency key not found")
+)
+
+// IdempotencyKey is a request made with an Idempotency-Key header and,
+// once it completed, its response. Keys are scoped to the user who made
+// the request.
+type IdempotencyKey struct {
+	UserID      int // 0 for unauthenticated requests
+	Key         string
+	Fingerprint string // Hash of the request, to detect keys reused for another request
+	StatusCode  int    // 0 while the request is in flight
+	Header      map[string][]string
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+const selectIdempotencyKey = `
+	SELECT user_id, idempotency_key, fingerprint, status_code, response_header, response_body, created_at, expires_at
+	FROM idempotency_keys
+	WHERE user_id = ? AND idempotency_key = ?
+`
+
+// ClaimIdempotencyKey records a request with key as in flight since now.
+// If the key is taken, it returns the existing record and
+// ErrIdempotencyKeyExists, unless the record expired before now or its
+// request has been in flight since before staleBefore, in which case the
+// record is replaced.
+func (s *service) ClaimIdempotencyKey(ctx context.Context, userID int, key, fingerprint string, now, expiresAt, staleBefore time.Time) (*IdempotencyKey, error) {
+	defer s.observe("ClaimIdempotencyKey", time.Now())
+
+	createdAt := now.UTC().Format("2006-01-02 15:04:05")
+	expires := expiresAt.UTC().Format("2006-01-02 15:04:05")
+
+	query := `
+		INSERT INTO idempotency_keys (user_id, idempotency_key, fingerprint, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	_, err := s.db.ExecContext(ctx, query, userID, key, fingerprint, createdAt, expires)
+	if err == nil {
+		return nil, nil
+	}
+	var mysqlErr *mysqldriver.MySQLError
+	if !errors.As(err, &mysqlErr) || mysqlErr.Number != mysqlDuplicateEntry {
+		return nil, fmt.Errorf("failed to claim idempotency key: %w", err)
+	}
+
+	// Take over records that expired or whose request never completed,
+	// e.g. because the server stopped while handling it
+	query = `
+		UPDATE idempotency_keys
+		SET fingerprint = ?, status_code = NULL, response_header = NULL, response_body = NULL, created_at = ?, expires_at = ?
+		WHERE user_id = ? AND idempotency_key = ?
+			AND (expires_at <= ? OR (status_code IS NULL AND created_at < ?))
+	`
+	result, err := s.db.ExecContext(ctx, query, fingerprint, createdAt, expires, userID, key,
+		createdAt, staleBefore.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim idempotency key: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 1 {
+		return nil, nil
+	}
+
+	existing, err := s.GetIdempotencyKey(ctx, userID, key)
+	if errors.Is(err, ErrIdempotencyKeyNotFound) {
+		// Released since the insert failed
+		return s.ClaimIdempotencyKey(ctx, userID, key, fingerprint, now, expiresAt, staleBefore)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return existing, ErrIdempotencyKeyExists
+}
+
+// GetIdempotencyKey retrieves the record of key
+func (s *service) GetIdempotencyKey(ctx context.Context, userID int, key string) (*IdempotencyKey, error) {
+	defer s.observe("GetIdempotencyKey", time.Now())
+
+	return scanIdempotencyKey(s.db.QueryRowContext(ctx, selectIdempotencyKey, userID, key))
+}
+
+// CompleteIdempotencyKey stores the response to the in-flight request
+// with key
+func (s *service) CompleteIdempotencyKey(ctx context.Context, userID int, key string, statusCode int, header map[string][]string, body []byte) error {
+	defer s.observe("CompleteIdempotencyKey", time.Now())
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return fmt.Errorf("failed to encode response header: %w", err)
+	}
+
+	query := `
+		UPDATE idempotency_keys
+		SET status_code = ?, response_header = ?, response_body = ?
+		WHERE user_id = ? AND idempotency_key = ? AND status_code IS NULL
+	`
+	result, err := s.db.ExecContext(ctx, query, statusCode, headerJSON, body, userID, key)
+	if err != nil {
+		return fmt.Errorf("failed to complete idempotency key: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrIdempotencyKeyNotFound
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey removes the record of an in-flight request with
+// key, so that it can be retried
+func (s *service) ReleaseIdempotencyKey(ctx context.Context, userID int, key string) error {
+	defer s.observe("ReleaseIdempotencyKey", time.Now())
+
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE user_id = ? AND idempotency_key = ? AND status_code IS NULL
+	`
+	if _, err := s.db.ExecContext(ctx, query, userID, key); err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
+
+// PurgeExpiredIdempotencyKeys removes the records that expired before
+// expiredBefore
+func (s *service) PurgeExpiredIdempotencyKeys(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	defer s.observe("PurgeExpiredIdempotencyKeys", time.Now())
+
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE expires_at < ?
+		LIMIT ?
+	`
+
+	var total int64
+	for {
+		result, err := s.db.ExecContext(ctx, query, expiredBefore.UTC().Format("2006-01-02 15:04:05"), purgeBatchSize)
+		if err != nil {
+			return total, fmt.Errorf("failed to purge idempotency keys: %w", err)
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return total, fmt.Errorf("failed to get rows affected: %w", err)
+		}
+
+		total += rowsAffected
+		if rowsAffected < purgeBatchSize {
+			return total, nil
+		}
+	}
+}
+
+func scanIdempotencyKey(row *sql.Row) (*IdempotencyKey, error) {
+	var record IdempotencyKey
+	var statusCode sql.NullInt64
+	var header, createdAt, expiresAt []byte
+	err := row.Scan(
+		&record.UserID, &record.Key, &record.Fingerprint, &statusCode,
+		&header, &record.Body, &createdAt, &expiresAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrIdempotencyKeyNotFound
+		}
+		return nil, fmt.Errorf("failed to get idempotency key: %w", err)
+	}
+
+	record.StatusCode = int(statusCode.Int64)
+	if header != nil {
+		if err := json.Unmarshal(header, &record.Header); err != nil {
+			return nil, fmt.Errorf("failed to decode response header: %w", err)
+		}
+	}
+
+	// Parse timestamps
+	record.CreatedAt, err = time.Parse("2006-01-02 15:04:05", string(createdAt))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse created_at: %w", err)
+	}
+	record.ExpiresAt, err = time.Parse("2006-01-02 15:04:05", string(expiresAt))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse expires_at: %w", err)
+	}
+
+	return &record, nil
+}
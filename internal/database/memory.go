@@ -0,0 +1,892 @@
+package mysql
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang-backend/internal/auth"
+)
+
+// errMemoryClosed is returned by Ping once a memory service is closed
+var errMemoryClosed = errors.New("database is closed")
+
+// memory is a Service keeping its data in memory. It follows the schema
+// of the migrations: strings are unique and compared without regard to
+// case as with the utf8mb4_unicode_ci collation, timestamps have a
+// precision of one second, and deleting users or roles cascades like the
+// foreign keys. Contexts are ignored, as every operation completes at
+// once.
+type memory struct {
+	hasher    auth.PasswordHasher
+	dummyHash func() (string, error) // See newDummyHash
+
+	mu              sync.Mutex
+	closed          bool
+	users           map[int]*User // Including soft-deleted users
+	lastUserID      int
+	refreshTokens   map[string]*RefreshToken // By hash
+	lastTokenID     int64
+	roles           map[string]*Role            // By folded name, with sorted permissions
+	permissions     map[string]*Permission      // By folded name
+	userRoles       map[int]map[string]struct{} // Folded role names by user ID
+	idempotencyKeys map[idempotencyKeyID]*IdempotencyKey
+}
+
+// idempotencyKeyID is the primary key of an idempotency key
+type idempotencyKeyID struct {
+	userID int
+	key    string
+}
+
+// seedPermissions and seedRoles mirror the seed_roles and
+// seed_users_restore_permission migrations
+var (
+	seedPermissions = []Permission{
+		{PermUsersList, "List all users"},
+		{PermUsersRead, "Read any user"},
+		{PermUsersUpdate, "Update any user"},
+		{PermUsersDelete, "Delete any user"},
+		{PermRolesRead, "Read roles and role assignments"},
+		{PermRolesManage, "Manage roles, their permissions and role assignments"},
+		{PermUsersRestore, "List and restore deleted users"},
+	}
+	seedRoles = []Role{
+		{Name: RoleAdmin, Description: "Full access", Permissions: []string{
+			PermUsersList, PermUsersRead, PermUsersUpdate, PermUsersDelete, PermRolesRead, PermRolesManage, PermUsersRestore,
+		}},
+		{Name: RoleSupport, Description: "Read-only access to users", Permissions: []string{
+			PermUsersList, PermUsersRead,
+		}},
+	}
+)
+
+// NewMemory returns a Service keeping its data in memory, as if all
+// migrations were applied to an empty database. New passwords are hashed
+// with hasher. It is safe for concurrent use and meant for tests that do
+// not need MySQL itself.
+func NewMemory(hasher auth.PasswordHasher) Service {
+	m := &memory{
+		hasher:          hasher,
+		dummyHash:       newDummyHash(hasher),
+		users:           map[int]*User{},
+		refreshTokens:   map[string]*RefreshToken{},
+		roles:           map[string]*Role{},
+		permissions:     map[string]*Permission{},
+		userRoles:       map[int]map[string]struct{}{},
+		idempotencyKeys: map[idempotencyKeyID]*IdempotencyKey{},
+	}
+
+	for _, p := range seedPermissions {
+		m.permissions[fold(p.Name)] = &p
+	}
+	now := currentTimestamp()
+	for _, r := range seedRoles {
+		r.Permissions = slices.Clone(r.Permissions)
+		slices.Sort(r.Permissions)
+		r.CreatedAt = now
+		m.roles[fold(r.Name)] = &r
+	}
+	return m
+}
+
+// fold returns the key s is compared by
+func fold(s string) string {
+	return strings.ToLower(s)
+}
+
+// currentTimestamp returns the time stored by CURRENT_TIMESTAMP
+func currentTimestamp() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// dbTime returns t as stored in a DATETIME column, which rounds fractional
+// seconds
+func dbTime(t time.Time) time.Time {
+	return t.UTC().Round(time.Second)
+}
+
+// dbTimeString returns t as compared in queries, formatted to whole
+// seconds
+func dbTimeString(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Second)
+}
+
+func (m *memory) Ping(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return errMemoryClosed
+	}
+	return nil
+}
+
+func (m *memory) Stats() sql.DBStats {
+	return sql.DBStats{}
+}
+
+func (m *memory) PendingMigrations(ctx context.Context) ([]MigrationStatus, error) {
+	return nil, nil
+}
+
+func (m *memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	return nil
+}
+
+// copyUser returns a copy of user that callers may modify
+func copyUser(user *User) *User {
+	c := *user
+	if user.DeletedAt != nil {
+		deletedAt := *user.DeletedAt
+		c.DeletedAt = &deletedAt
+	}
+	return &c
+}
+
+// activeUser returns the user with id unless it is missing or deleted
+func (m *memory) activeUser(id int) (*User, error) {
+	user, ok := m.users[id]
+	if !ok || user.DeletedAt != nil {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// checkUnique returns the error of the unique key violated by giving user
+// id the username and email. The username key is checked first, as by
+// MySQL.
+func (m *memory) checkUnique(id int, username, email string) error {
+	for _, other := range m.users {
+		if other.ID != id && fold(other.Username) == fold(username) {
+			return ErrDuplicateUsername
+		}
+	}
+	for _, other := range m.users {
+		if other.ID != id && fold(other.Email) == fold(email) {
+			return ErrDuplicateEmail
+		}
+	}
+	return nil
+}
+
+func (m *memory) CreateUser(ctx context.Context, username, email, password string) (*User, error) {
+	hash, err := m.hasher.Hash(password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkUnique(0, username, email); err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	m.lastUserID++
+	now := currentTimestamp()
+	user := &User{
+		ID:        m.lastUserID,
+		Username:  username,
+		Email:     email,
+		Password:  hash,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Version:   1,
+	}
+	m.users[user.ID] = user
+	return copyUser(user), nil
+}
+
+func (m *memory) GetUserByID(ctx context.Context, id int) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, err := m.activeUser(id)
+	if err != nil {
+		return nil, err
+	}
+	return copyUser(user), nil
+}
+
+func (m *memory) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	return m.findUser(func(u *User) bool { return fold(u.Email) == fold(email) })
+}
+
+func (m *memory) GetUserByUsername(ctx context.Context, username string) (*User, error) {
+	return m.findUser(func(u *User) bool { return fold(u.Username) == fold(username) })
+}
+
+// findUser returns the active user matching match
+func (m *memory) findUser(match func(*User) bool) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, user := range m.users {
+		if user.DeletedAt == nil && match(user) {
+			return copyUser(user), nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+func (m *memory) GetAllUsers(ctx context.Context) ([]*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	users := m.selectUsers(func(u *User) bool { return u.DeletedAt == nil })
+	slices.SortFunc(users, func(a, b *User) int {
+		return cmp.Or(b.CreatedAt.Compare(a.CreatedAt), cmp.Compare(b.ID, a.ID))
+	})
+	return users, nil
+}
+
+func (m *memory) GetUsersByIDs(ctx context.Context, ids []int) ([]*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	users := m.selectUsers(func(u *User) bool { return u.DeletedAt == nil && slices.Contains(ids, u.ID) })
+	slices.SortFunc(users, func(a, b *User) int { return cmp.Compare(a.ID, b.ID) })
+	return users, nil
+}
+
+// selectUsers returns copies of the users matching match, in no particular
+// order
+func (m *memory) selectUsers(match func(*User) bool) []*User {
+	users := []*User{}
+	for _, user := range m.users {
+		if match(user) {
+			users = append(users, copyUser(user))
+		}
+	}
+	return users
+}
+
+func (m *memory) ListUsers(ctx context.Context, params ListUsersParams) (*UserPage, error) {
+	return m.listUsers(params, false)
+}
+
+func (m *memory) ListDeletedUsers(ctx context.Context, params ListUsersParams) (*UserPage, error) {
+	return m.listUsers(params, true)
+}
+
+func (m *memory) listUsers(params ListUsersParams, deleted bool) (*UserPage, error) {
+	sort, err := params.sort()
+	if err != nil {
+		return nil, err
+	}
+
+	// Walking backwards sorts in the opposite order and reverses the
+	// result afterwards
+	backwards := params.Before != nil
+	desc := sort.Desc != backwards
+	cursor := params.After
+	if backwards {
+		cursor = params.Before
+	}
+
+	// compare orders users by the sort field, then by id
+	compare := func(a, b UserCursor) int {
+		c := 0
+		if sort.Field != SortByID {
+			c = strings.Compare(fold(a.Value), fold(b.Value))
+		}
+		c = cmp.Or(c, cmp.Compare(a.ID, b.ID))
+		if desc {
+			c = -c
+		}
+		return c
+	}
+
+	m.mu.Lock()
+	users := m.selectUsers(func(u *User) bool {
+		if (u.DeletedAt != nil) != deleted || !params.Filter.matches(u) {
+			return false
+		}
+		return cursor == nil || compare(CursorOf(u, sort.Field), *cursor) > 0
+	})
+	m.mu.Unlock()
+
+	slices.SortFunc(users, func(a, b *User) int {
+		return compare(CursorOf(a, sort.Field), CursorOf(b, sort.Field))
+	})
+
+	more := len(users) > params.Limit
+	if more {
+		users = users[:params.Limit]
+	}
+
+	page := &UserPage{Users: users}
+	if backwards {
+		slices.Reverse(page.Users)
+		page.HasPrev = more
+		page.HasNext = true
+	} else {
+		page.HasNext = more
+		page.HasPrev = params.After != nil
+	}
+	return page, nil
+}
+
+// matches reports whether user passes the filter, as conditions does
+func (f UserFilter) matches(user *User) bool {
+	hasPrefix := func(s, prefix string) bool {
+		return strings.HasPrefix(fold(s), fold(prefix))
+	}
+	if f.UsernamePrefix != "" && !hasPrefix(user.Username, f.UsernamePrefix) {
+		return false
+	}
+	if f.EmailPrefix != "" && !hasPrefix(user.Email, f.EmailPrefix) {
+		return false
+	}
+	if f.Search != "" && !hasPrefix(user.Username, f.Search) && !hasPrefix(user.Email, f.Search) {
+		return false
+	}
+
+	ranges := []struct {
+		value *time.Time
+		ok    func(t time.Time) bool
+	}{
+		{f.CreatedAfter, func(t time.Time) bool { return !user.CreatedAt.Before(t) }},
+		{f.CreatedBefore, func(t time.Time) bool { return user.CreatedAt.Before(t) }},
+		{f.UpdatedAfter, func(t time.Time) bool { return !user.UpdatedAt.Before(t) }},
+		{f.UpdatedBefore, func(t time.Time) bool { return user.UpdatedAt.Before(t) }},
+	}
+	for _, r := range ranges {
+		if r.value != nil && !r.ok(dbTimeString(*r.value)) {
+			return false
+		}
+	}
+	return true
+}
+
+// update applies change to the active user id, conditionally on version
+// if not zero, and bumps its version
+func (m *memory) update(id, version int, change func(*User) error) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, err := m.activeUser(id)
+	if err != nil {
+		return nil, err
+	}
+	if version != 0 && user.Version != version {
+		return nil, ErrVersionConflict
+	}
+
+	updated := copyUser(user)
+	if err := change(updated); err != nil {
+		return nil, err
+	}
+	updated.Version++
+	updated.UpdatedAt = currentTimestamp()
+	m.users[id] = updated
+	return copyUser(updated), nil
+}
+
+func (m *memory) UpdateUser(ctx context.Context, id, version int, username, email string) (*User, error) {
+	return m.update(id, version, func(u *User) error {
+		if err := m.checkUnique(id, username, email); err != nil {
+			return fmt.Errorf("failed to update user: %w", err)
+		}
+		u.Username, u.Email = username, email
+		return nil
+	})
+}
+
+func (m *memory) PatchUser(ctx context.Context, id, version int, changes UserChanges) (*User, error) {
+	return m.update(id, version, func(u *User) error {
+		if changes.Username != nil {
+			u.Username = *changes.Username
+		}
+		if changes.Email != nil {
+			u.Email = *changes.Email
+		}
+		if err := m.checkUnique(id, u.Username, u.Email); err != nil {
+			return fmt.Errorf("failed to update user: %w", err)
+		}
+		return nil
+	})
+}
+
+func (m *memory) UpdateUserPassword(ctx context.Context, id, version int, password string) error {
+	hash, err := m.hasher.Hash(password)
+	if err != nil {
+		return fmt.Errorf("failed to update user password: %w", err)
+	}
+
+	_, err = m.update(id, version, func(u *User) error {
+		u.Password = hash
+		return nil
+	})
+	return err
+}
+
+func (m *memory) VerifyPassword(ctx context.Context, user *User, password string) error {
+	if user == nil {
+		return checkNoPassword(m.hasher, m.dummyHash, password)
+	}
+	if err := checkPassword(m.hasher, user.Password, password); err != nil {
+		return err
+	}
+
+	if m.hasher.NeedsRehash(user.Password) {
+		// As with MySQL, failing to rehash does not fail the verification
+		_ = m.UpdateUserPassword(ctx, user.ID, 0, password)
+	}
+	return nil
+}
+
+func (m *memory) DeleteUser(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, err := m.activeUser(id)
+	if err != nil {
+		return err
+	}
+
+	deleted := copyUser(user)
+	now := currentTimestamp()
+	deleted.DeletedAt = &now
+	deleted.UpdatedAt = now
+	deleted.Version++
+	m.users[id] = deleted
+
+	revokedAt := dbTime(time.Now())
+	for hash, token := range m.refreshTokens {
+		if token.UserID == id && token.RevokedAt == nil {
+			m.refreshTokens[hash] = withRevokedAt(token, revokedAt)
+		}
+	}
+	return nil
+}
+
+func (m *memory) RestoreUser(ctx context.Context, id int) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok || user.DeletedAt == nil {
+		return nil, ErrUserNotFound
+	}
+
+	restored := copyUser(user)
+	restored.DeletedAt = nil
+	restored.UpdatedAt = currentTimestamp()
+	restored.Version++
+	m.users[id] = restored
+	return copyUser(restored), nil
+}
+
+func (m *memory) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cutoff := dbTimeString(deletedBefore)
+	var purged int64
+	for id, user := range m.users {
+		if user.DeletedAt == nil || !user.DeletedAt.Before(cutoff) {
+			continue
+		}
+
+		delete(m.users, id)
+		delete(m.userRoles, id)
+		maps.DeleteFunc(m.refreshTokens, func(_ string, token *RefreshToken) bool {
+			return token.UserID == id
+		})
+		purged++
+	}
+	return purged, nil
+}
+
+// copyRefreshToken returns a copy of token that callers may modify
+func copyRefreshToken(token *RefreshToken) *RefreshToken {
+	c := *token
+	if token.UsedAt != nil {
+		usedAt := *token.UsedAt
+		c.UsedAt = &usedAt
+	}
+	if token.RevokedAt != nil {
+		revokedAt := *token.RevokedAt
+		c.RevokedAt = &revokedAt
+	}
+	return &c
+}
+
+// withRevokedAt returns a copy of token revoked at revokedAt
+func withRevokedAt(token *RefreshToken, revokedAt time.Time) *RefreshToken {
+	c := copyRefreshToken(token)
+	c.RevokedAt = &revokedAt
+	return c
+}
+
+// insertRefreshToken stores a new refresh token, enforcing the foreign key
+// on users and the unique key on token hashes
+func (m *memory) insertRefreshToken(userID int, familyID, tokenHash string, expiresAt time.Time) (*RefreshToken, error) {
+	if _, ok := m.users[userID]; !ok {
+		return nil, fmt.Errorf("failed to create refresh token: no user %d", userID)
+	}
+	if _, ok := m.refreshTokens[tokenHash]; ok {
+		return nil, errors.New("failed to create refresh token: duplicate token hash")
+	}
+
+	m.lastTokenID++
+	token := &RefreshToken{
+		ID:        m.lastTokenID,
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: tokenHash,
+		ExpiresAt: dbTime(expiresAt),
+		CreatedAt: currentTimestamp(),
+	}
+	m.refreshTokens[tokenHash] = token
+	return copyRefreshToken(token), nil
+}
+
+func (m *memory) CreateRefreshToken(ctx context.Context, userID int, familyID, tokenHash string, expiresAt time.Time) (*RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.insertRefreshToken(userID, familyID, tokenHash, expiresAt)
+}
+
+func (m *memory) GetRefreshToken(ctx context.Context, tokenHash string) (*RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token, ok := m.refreshTokens[tokenHash]
+	if !ok {
+		return nil, ErrRefreshTokenNotFound
+	}
+	return copyRefreshToken(token), nil
+}
+
+func (m *memory) RotateRefreshToken(ctx context.Context, tokenHash, newTokenHash string, now, expiresAt time.Time) (*RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token, ok := m.refreshTokens[tokenHash]
+	if !ok {
+		return nil, ErrRefreshTokenNotFound
+	}
+	if token.RevokedAt != nil {
+		return nil, ErrRefreshTokenRevoked
+	}
+
+	now = now.UTC()
+
+	if token.UsedAt != nil {
+		m.revokeRefreshTokenFamily(token.FamilyID, dbTime(now))
+		return nil, ErrRefreshTokenReused
+	}
+
+	if now.After(token.ExpiresAt) {
+		return nil, ErrRefreshTokenExpired
+	}
+
+	rotated, err := m.insertRefreshToken(token.UserID, token.FamilyID, newTokenHash, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	used := copyRefreshToken(token)
+	usedAt := dbTime(now)
+	used.UsedAt = &usedAt
+	m.refreshTokens[tokenHash] = used
+	return rotated, nil
+}
+
+func (m *memory) RevokeRefreshTokenFamily(ctx context.Context, familyID string, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.revokeRefreshTokenFamily(familyID, dbTime(now))
+	return nil
+}
+
+func (m *memory) revokeRefreshTokenFamily(familyID string, now time.Time) {
+	for hash, token := range m.refreshTokens {
+		if token.FamilyID == familyID && token.RevokedAt == nil {
+			m.refreshTokens[hash] = withRevokedAt(token, now)
+		}
+	}
+}
+
+// copyRole returns a copy of role that callers may modify
+func copyRole(role *Role) *Role {
+	c := *role
+	c.Permissions = slices.Clone(role.Permissions)
+	return &c
+}
+
+func (m *memory) ListRoles(ctx context.Context) ([]*Role, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	roles := []*Role{}
+	for _, key := range slices.Sorted(maps.Keys(m.roles)) {
+		roles = append(roles, copyRole(m.roles[key]))
+	}
+	return roles, nil
+}
+
+func (m *memory) GetRole(ctx context.Context, name string) (*Role, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	role, ok := m.roles[fold(name)]
+	if !ok {
+		return nil, ErrRoleNotFound
+	}
+	return copyRole(role), nil
+}
+
+func (m *memory) CreateRole(ctx context.Context, name, description string) (*Role, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.roles[fold(name)]; ok {
+		return nil, ErrRoleExists
+	}
+
+	role := &Role{Name: name, Description: description, Permissions: []string{}, CreatedAt: currentTimestamp()}
+	m.roles[fold(name)] = role
+	return copyRole(role), nil
+}
+
+func (m *memory) DeleteRole(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.roles[fold(name)]; !ok {
+		return ErrRoleNotFound
+	}
+
+	delete(m.roles, fold(name))
+	for _, roles := range m.userRoles {
+		delete(roles, fold(name))
+	}
+	return nil
+}
+
+func (m *memory) ListPermissions(ctx context.Context) ([]*Permission, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	permissions := []*Permission{}
+	for _, key := range slices.Sorted(maps.Keys(m.permissions)) {
+		p := *m.permissions[key]
+		permissions = append(permissions, &p)
+	}
+	return permissions, nil
+}
+
+func (m *memory) GrantPermission(ctx context.Context, role, permission string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.roles[fold(role)]
+	if !ok {
+		return ErrRoleNotFound
+	}
+	p, ok := m.permissions[fold(permission)]
+	if !ok {
+		return ErrPermissionNotFound
+	}
+
+	if !slices.ContainsFunc(r.Permissions, func(name string) bool { return fold(name) == fold(p.Name) }) {
+		r.Permissions = append(slices.Clone(r.Permissions), p.Name)
+		slices.Sort(r.Permissions)
+	}
+	return nil
+}
+
+func (m *memory) RevokePermission(ctx context.Context, role, permission string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.roles[fold(role)]
+	if !ok {
+		return ErrRoleNotFound
+	}
+
+	r.Permissions = slices.DeleteFunc(slices.Clone(r.Permissions), func(name string) bool {
+		return fold(name) == fold(permission)
+	})
+	return nil
+}
+
+// rolesOf returns the sorted names of the roles assigned to user id
+func (m *memory) rolesOf(id int) []string {
+	roles := []string{}
+	for _, key := range slices.Sorted(maps.Keys(m.userRoles[id])) {
+		roles = append(roles, m.roles[key].Name)
+	}
+	return roles
+}
+
+func (m *memory) GetUserRoles(ctx context.Context, userID int) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.rolesOf(userID), nil
+}
+
+func (m *memory) GetRolesByUserIDs(ctx context.Context, userIDs []int) (map[int][]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	roles := make(map[int][]string, len(userIDs))
+	for _, id := range userIDs {
+		roles[id] = m.rolesOf(id)
+	}
+	return roles, nil
+}
+
+func (m *memory) AssignRole(ctx context.Context, userID int, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.roles[fold(role)]; !ok {
+		return ErrRoleNotFound
+	}
+	if _, err := m.activeUser(userID); err != nil {
+		return err
+	}
+
+	if m.userRoles[userID] == nil {
+		m.userRoles[userID] = map[string]struct{}{}
+	}
+	m.userRoles[userID][fold(role)] = struct{}{}
+	return nil
+}
+
+func (m *memory) UnassignRole(ctx context.Context, userID int, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.userRoles[userID], fold(role))
+	return nil
+}
+
+func (m *memory) GetUserPermissions(ctx context.Context, userID int) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	permissions := []string{}
+	if user, ok := m.users[userID]; !ok || user.DeletedAt != nil {
+		return permissions, nil
+	}
+	for key := range m.userRoles[userID] {
+		for _, p := range m.roles[key].Permissions {
+			if !slices.Contains(permissions, p) {
+				permissions = append(permissions, p)
+			}
+		}
+	}
+	slices.Sort(permissions)
+	return permissions, nil
+}
+
+// copyIdempotencyKey returns a copy of record that callers may modify
+func copyIdempotencyKey(record *IdempotencyKey) *IdempotencyKey {
+	c := *record
+	c.Header = maps.Clone(record.Header)
+	for name, values := range c.Header {
+		c.Header[name] = slices.Clone(values)
+	}
+	c.Body = bytes.Clone(record.Body)
+	return &c
+}
+
+func (m *memory) ClaimIdempotencyKey(ctx context.Context, userID int, key, fingerprint string, now, expiresAt, staleBefore time.Time) (*IdempotencyKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now = dbTimeString(now)
+	id := idempotencyKeyID{userID, fold(key)}
+
+	// Take over records that expired or whose request never completed
+	if existing, ok := m.idempotencyKeys[id]; ok {
+		stale := existing.StatusCode == 0 && existing.CreatedAt.Before(dbTimeString(staleBefore))
+		if existing.ExpiresAt.After(now) && !stale {
+			return copyIdempotencyKey(existing), ErrIdempotencyKeyExists
+		}
+	}
+
+	m.idempotencyKeys[id] = &IdempotencyKey{
+		UserID:      userID,
+		Key:         key,
+		Fingerprint: fingerprint,
+		CreatedAt:   now,
+		ExpiresAt:   dbTimeString(expiresAt),
+	}
+	return nil, nil
+}
+
+func (m *memory) GetIdempotencyKey(ctx context.Context, userID int, key string) (*IdempotencyKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	record, ok := m.idempotencyKeys[idempotencyKeyID{userID, fold(key)}]
+	if !ok {
+		return nil, ErrIdempotencyKeyNotFound
+	}
+	return copyIdempotencyKey(record), nil
+}
+
+func (m *memory) CompleteIdempotencyKey(ctx context.Context, userID int, key string, statusCode int, header map[string][]string, body []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := idempotencyKeyID{userID, fold(key)}
+	record, ok := m.idempotencyKeys[id]
+	if !ok || record.StatusCode != 0 {
+		return ErrIdempotencyKeyNotFound
+	}
+
+	completed := copyIdempotencyKey(record)
+	completed.StatusCode = statusCode
+	completed.Header = header
+	completed.Body = body
+	m.idempotencyKeys[id] = copyIdempotencyKey(completed)
+	return nil
+}
+
+func (m *memory) ReleaseIdempotencyKey(ctx context.Context, userID int, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := idempotencyKeyID{userID, fold(key)}
+	if record, ok := m.idempotencyKeys[id]; ok && record.StatusCode == 0 {
+		delete(m.idempotencyKeys, id)
+	}
+	return nil
+}
+
+func (m *memory) PurgeExpiredIdempotencyKeys(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cutoff := dbTimeString(expiredBefore)
+	var purged int64
+	maps.DeleteFunc(m.idempotencyKeys, func(_ idempotencyKeyID, record *IdempotencyKey) bool {
+		if record.ExpiresAt.Before(cutoff) {
+			purged++
+			return true
+		}
+		return false
+	})
+	return purged, nil
+}
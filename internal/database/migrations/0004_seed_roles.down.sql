@@ -0,0 +1,2 @@
+DELETE FROM roles WHERE name IN ('admin', 'support');
+DELETE FROM permissions WHERE name IN ('users:list', 'users:read', 'users:update', 'users:delete', 'roles:read', 'roles:manage');
@@ -0,0 +1,21 @@
+INSERT IGNORE INTO permissions (name, description) VALUES
+	('users:list', 'List all users'),
+	('users:read', 'Read any user'),
+	('users:update', 'Update any user'),
+	('users:delete', 'Delete any user'),
+	('roles:read', 'Read roles and role assignments'),
+	('roles:manage', 'Manage roles, their permissions and role assignments');
+
+INSERT IGNORE INTO roles (name, description) VALUES
+	('admin', 'Full access'),
+	('support', 'Read-only access to users');
+
+INSERT IGNORE INTO role_permissions (role, permission) VALUES
+	('admin', 'users:list'),
+	('admin', 'users:read'),
+	('admin', 'users:update'),
+	('admin', 'users:delete'),
+	('admin', 'roles:read'),
+	('admin', 'roles:manage'),
+	('support', 'users:list'),
+	('support', 'users:read');
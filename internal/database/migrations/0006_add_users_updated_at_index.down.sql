@@ -0,0 +1 @@
+DROP INDEX idx_updated_at_id ON users;
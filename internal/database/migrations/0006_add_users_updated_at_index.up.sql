@@ -0,0 +1 @@
+CREATE INDEX idx_updated_at_id ON users (updated_at, id);
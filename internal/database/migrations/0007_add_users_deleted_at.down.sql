@@ -0,0 +1,2 @@
+DROP INDEX idx_deleted_at ON users;
+ALTER TABLE users DROP COLUMN deleted_at;
@@ -0,0 +1,5 @@
+INSERT IGNORE INTO permissions (name, description) VALUES
+	('users:restore', 'List and restore deleted users');
+
+INSERT IGNORE INTO role_permissions (role, permission) VALUES
+	('admin', 'users:restore');
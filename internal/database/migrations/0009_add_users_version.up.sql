@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN version INT UNSIGNED NOT NULL DEFAULT 1;
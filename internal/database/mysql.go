@@ -2,37 +2,60 @@ package mysql
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/subtle"
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
+	"net"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
-	_ "github.com/joho/godotenv/autoload"
+	"github.com/XSAM/otelsql"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+
+	"golang-backend/internal/auth"
+	"golang-backend/internal/config"
 )
 
 var (
-	ErrUserNotFound = errors.New("user not found")
+	ErrUserNotFound      = errors.New("user not found")
+	ErrInvalidPassword   = errors.New("invalid password")
+	ErrDuplicateUsername = errors.New("username already exists")
+	ErrDuplicateEmail    = errors.New("email already exists")
+	ErrVersionConflict   = errors.New("user was modified concurrently")
 )
 
+// mysqlDuplicateEntry is the MySQL error number for unique key violations
+const mysqlDuplicateEntry = 1062
+
 // User represents a user in the system
 type User struct {
-	ID        int       `json:"id"`
-	Username  string    `json:"username"`
-	Email     string    `json:"email"`
-	Password  string    `json:"-"` // Don't include password in JSON responses
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID        int        `json:"id"`
+	Username  string     `json:"username"`
+	Email     string     `json:"email"`
+	Password  string     `json:"-"` // Don't include password in JSON responses
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"` // Set while the user is soft-deleted
+	Version   int        `json:"-"`                    // Incremented on every change, exposed as the ETag
 }
 
 // Service represents a service that interacts with a database.
 type Service interface {
-	// Health returns a map of health status information.
-	// The keys and values in the map are service-specific.
-	Health() map[string]string
+	// Ping checks that the database is reachable
+	Ping(ctx context.Context) error
+
+	// Stats returns the statistics of the connection pool
+	Stats() sql.DBStats
+
+	// PendingMigrations returns the embedded migrations that have not
+	// been applied yet. It fails if applied migrations were modified.
+	PendingMigrations(ctx context.Context) ([]MigrationStatus, error)
 
 	// Close terminates the database connection.
 	// It returns an error if the connection cannot be closed.
@@ -44,93 +67,171 @@ type Service interface {
 	GetUserByEmail(ctx context.Context, email string) (*User, error)
 	GetUserByUsername(ctx context.Context, username string) (*User, error)
 	GetAllUsers(ctx context.Context) ([]*User, error)
-	UpdateUser(ctx context.Context, id int, username, email string) (*User, error)
-	UpdateUserPassword(ctx context.Context, id int, password string) error
+	// GetUsersByIDs retrieves the users with the given IDs in one query.
+	// Unknown and deleted IDs are left out.
+	GetUsersByIDs(ctx context.Context, ids []int) ([]*User, error)
+	ListUsers(ctx context.Context, params ListUsersParams) (*UserPage, error)
+
+	// UpdateUser, PatchUser and UpdateUserPassword increment the user's version. A
+	// non-zero version makes the update conditional: it fails with
+	// ErrVersionConflict unless the user is still at that version.
+	UpdateUser(ctx context.Context, id, version int, username, email string) (*User, error)
+	PatchUser(ctx context.Context, id, version int, changes UserChanges) (*User, error)
+	UpdateUserPassword(ctx context.Context, id, version int, password string) error
+
+	// DeleteUser soft-deletes a user and revokes their refresh tokens.
+	// Soft-deleted users are invisible to every other user operation until
+	// they are restored or purged.
 	DeleteUser(ctx context.Context, id int) error
+	RestoreUser(ctx context.Context, id int) (*User, error)
+	ListDeletedUsers(ctx context.Context, params ListUsersParams) (*UserPage, error)
+
+	// PurgeDeletedUsers permanently removes users soft-deleted before
+	// deletedBefore and returns how many were removed.
+	PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int64, error)
+
+	// VerifyPassword checks password against the stored hash of user.
+	// It returns ErrInvalidPassword on mismatch. When the stored hash uses
+	// outdated parameters it is transparently replaced with a fresh one.
+	// A nil user, for a login that does not exist, is checked against a
+	// dummy hash so that it takes as long, and always fails.
+	VerifyPassword(ctx context.Context, user *User, password string) error
+
+	// Refresh token operations
+	CreateRefreshToken(ctx context.Context, userID int, familyID, tokenHash string, expiresAt time.Time) (*RefreshToken, error)
+	GetRefreshToken(ctx context.Context, tokenHash string) (*RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, tokenHash, newTokenHash string, now, expiresAt time.Time) (*RefreshToken, error)
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string, now time.Time) error
+
+	// Role and permission operations
+	ListRoles(ctx context.Context) ([]*Role, error)
+	GetRole(ctx context.Context, name string) (*Role, error)
+	CreateRole(ctx context.Context, name, description string) (*Role, error)
+	DeleteRole(ctx context.Context, name string) error
+	ListPermissions(ctx context.Context) ([]*Permission, error)
+	GrantPermission(ctx context.Context, role, permission string) error
+	RevokePermission(ctx context.Context, role, permission string) error
+	GetUserRoles(ctx context.Context, userID int) ([]string, error)
+	GetRolesByUserIDs(ctx context.Context, userIDs []int) (map[int][]string, error)
+	AssignRole(ctx context.Context, userID int, role string) error
+	UnassignRole(ctx context.Context, userID int, role string) error
+	GetUserPermissions(ctx context.Context, userID int) ([]string, error)
+
+	// Idempotency key operations
+	ClaimIdempotencyKey(ctx context.Context, userID int, key, fingerprint string, now, expiresAt, staleBefore time.Time) (*IdempotencyKey, error)
+	GetIdempotencyKey(ctx context.Context, userID int, key string) (*IdempotencyKey, error)
+	CompleteIdempotencyKey(ctx context.Context, userID int, key string, statusCode int, header map[string][]string, body []byte) error
+	ReleaseIdempotencyKey(ctx context.Context, userID int, key string) error
+	PurgeExpiredIdempotencyKeys(ctx context.Context, expiredBefore time.Time) (int64, error)
 }
 
-type service struct {
-	db *sql.DB
+// QueryObserver is told how long each database operation took, named
+// after the Service method that made it
+type QueryObserver interface {
+	ObserveQuery(query string, d time.Duration)
 }
 
-var (
-	dbname     = os.Getenv("MYSQL_DB_DATABASE")
-	password   = os.Getenv("MYSQL_DB_PASSWORD")
-	username   = os.Getenv("MYSQL_DB_USERNAME")
-	port       = os.Getenv("MYSQL_DB_PORT")
-	host       = os.Getenv("MYSQL_DB_HOST")
-	dbInstance *service
-)
-
-func New() Service {
-	// Reuse Connection
-	if dbInstance != nil {
-		return dbInstance
-	}
+type service struct {
+	db        *sql.DB
+	name      string // Name of the database
+	hasher    auth.PasswordHasher
+	dummyHash func() (string, error) // See newDummyHash
+	logger    *slog.Logger
+	observer  QueryObserver // Optional
+}
 
-	// Opening a driver typically will not attempt to connect to the database.
-	db, err := sql.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", username, password, host, port, dbname))
+// New returns a service for the database configured by cfg, logging to
+// logger and reporting the duration of its operations to observer, if not
+// nil. Pending migrations are applied first if cfg.AutoMigrate is set.
+// Every call opens its own connection pool, released by Close.
+func New(cfg config.Database, logger *slog.Logger, observer QueryObserver) (Service, error) {
+	hasher, err := auth.NewPasswordHasher(cfg.PasswordHashAlgorithm)
 	if err != nil {
-		// This will not be a connection error, but a DSN parse error or
-		// another initialization error.
-		log.Fatal(err)
+		return nil, fmt.Errorf("failed to create password hasher: %w", err)
 	}
-	db.SetConnMaxLifetime(0)
-	db.SetMaxIdleConns(50)
-	db.SetMaxOpenConns(50)
 
-	dbInstance = &service{
-		db: db,
+	db, err := Open(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	// Create tables
-	if err := dbInstance.createTables(); err != nil {
-		log.Fatal(err)
+	// Apply pending migrations
+	if cfg.AutoMigrate {
+		migrator, err := NewMigrator(db, logger)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to load migrations: %w", err)
+		}
+		if err := migrator.Up(context.Background()); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to apply migrations: %w", err)
+		}
 	}
 
-	return dbInstance
+	return &service{
+		db:        db,
+		name:      cfg.Name,
+		hasher:    hasher,
+		dummyHash: newDummyHash(hasher),
+		logger:    logger,
+		observer:  observer,
+	}, nil
 }
 
-// createTables creates all necessary tables
-func (s *service) createTables() error {
-	// Create users table
-	createUsersTable := `
-	CREATE TABLE IF NOT EXISTS users (
-		id INT AUTO_INCREMENT PRIMARY KEY,
-		username VARCHAR(50) UNIQUE NOT NULL,
-		email VARCHAR(100) UNIQUE NOT NULL,
-		password VARCHAR(255) NOT NULL,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
-		INDEX idx_email (email),
-		INDEX idx_username (username)
-	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
-	`
+// Open opens a connection pool to the database configured by cfg. Every
+// statement is traced as a child span of the span in its context,
+// recording the statement but not its arguments.
+func Open(cfg config.Database) (*sql.DB, error) {
+	dsn := mysqldriver.NewConfig()
+	dsn.User = cfg.Username
+	dsn.Passwd = cfg.Password
+	dsn.Net = "tcp"
+	dsn.Addr = net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	dsn.DBName = cfg.Name
 
-	_, err := s.db.Exec(createUsersTable)
+	// Opening a driver typically will not attempt to connect to the database.
+	db, err := otelsql.Open("mysql", dsn.FormatDSN(),
+		otelsql.WithAttributes(semconv.DBSystemNameMySQL, semconv.DBNamespace(cfg.Name)),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitConnectorConnect: true,
+			OmitRows:             true,
+		}),
+	)
 	if err != nil {
-		return fmt.Errorf("failed to create users table: %v", err)
+		// This will not be a connection error, but a DSN parse error or
+		// another initialization error.
+		return nil, err
 	}
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
 
-	log.Println("Database tables created successfully")
-	return nil
+	return db, nil
 }
 
 // CreateUser creates a new user
 func (s *service) CreateUser(ctx context.Context, username, email, password string) (*User, error) {
+	defer s.observe("CreateUser", time.Now())
+
 	query := `
 		INSERT INTO users (username, email, password) 
 		VALUES (?, ?, ?)
 	`
-	
-	result, err := s.db.ExecContext(ctx, query, username, email, password)
+
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	result, err := s.db.ExecContext(ctx, query, username, email, hash)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create user: %v", err)
+		return nil, fmt.Errorf("failed to create user: %w", translateUserError(err))
 	}
 
 	id, err := result.LastInsertId()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get last insert id: %v", err)
+		return nil, fmt.Errorf("failed to get last insert id: %w", err)
 	}
 
 	return s.GetUserByID(ctx, int(id))
@@ -138,252 +239,482 @@ func (s *service) CreateUser(ctx context.Context, username, email, password stri
 
 // GetUserByID retrieves a user by ID
 func (s *service) GetUserByID(ctx context.Context, id int) (*User, error) {
+	defer s.observe("GetUserByID", time.Now())
+
 	query := `
-		SELECT id, username, email, password, created_at, updated_at 
-		FROM users 
-		WHERE id = ?
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE id = ? AND deleted_at IS NULL
 	`
-	
-	var user User
-	var createdAt, updatedAt []byte
-	err := s.db.QueryRowContext(ctx, query, id).Scan(
-		&user.ID, &user.Username, &user.Email, &user.Password,
-		&createdAt, &updatedAt,
-	)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, ErrUserNotFound
-		}
-		return nil, fmt.Errorf("failed to get user: %v", err)
-	}
 
-	// Parse timestamps
-	user.CreatedAt, err = time.Parse("2006-01-02 15:04:05", string(createdAt))
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse created_at: %v", err)
+	user, err := scanUser(s.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
 	}
-	user.UpdatedAt, err = time.Parse("2006-01-02 15:04:05", string(updatedAt))
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse updated_at: %v", err)
-	}
-
-	return &user, nil
+	return user, err
 }
 
 // GetUserByEmail retrieves a user by email
 func (s *service) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	defer s.observe("GetUserByEmail", time.Now())
+
 	query := `
-		SELECT id, username, email, password, created_at, updated_at 
-		FROM users 
-		WHERE email = ?
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE email = ? AND deleted_at IS NULL
 	`
-	
-	var user User
-	var createdAt, updatedAt []byte
-	err := s.db.QueryRowContext(ctx, query, email).Scan(
-		&user.ID, &user.Username, &user.Email, &user.Password,
-		&createdAt, &updatedAt,
-	)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, ErrUserNotFound
-		}
-		return nil, fmt.Errorf("failed to get user: %v", err)
-	}
 
-	// Parse timestamps
-	user.CreatedAt, err = time.Parse("2006-01-02 15:04:05", string(createdAt))
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse created_at: %v", err)
-	}
-	user.UpdatedAt, err = time.Parse("2006-01-02 15:04:05", string(updatedAt))
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse updated_at: %v", err)
+	user, err := scanUser(s.db.QueryRowContext(ctx, query, email))
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
 	}
-
-	return &user, nil
+	return user, err
 }
 
 // GetUserByUsername retrieves a user by username
 func (s *service) GetUserByUsername(ctx context.Context, username string) (*User, error) {
+	defer s.observe("GetUserByUsername", time.Now())
+
 	query := `
-		SELECT id, username, email, password, created_at, updated_at 
-		FROM users 
-		WHERE username = ?
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE username = ? AND deleted_at IS NULL
 	`
-	
-	var user User
-	var createdAt, updatedAt []byte
-	err := s.db.QueryRowContext(ctx, query, username).Scan(
-		&user.ID, &user.Username, &user.Email, &user.Password,
-		&createdAt, &updatedAt,
-	)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, ErrUserNotFound
-		}
-		return nil, fmt.Errorf("failed to get user: %v", err)
-	}
 
-	// Parse timestamps
-	user.CreatedAt, err = time.Parse("2006-01-02 15:04:05", string(createdAt))
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse created_at: %v", err)
+	user, err := scanUser(s.db.QueryRowContext(ctx, query, username))
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
 	}
-	user.UpdatedAt, err = time.Parse("2006-01-02 15:04:05", string(updatedAt))
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse updated_at: %v", err)
-	}
-
-	return &user, nil
+	return user, err
 }
 
 // GetAllUsers retrieves all users
 func (s *service) GetAllUsers(ctx context.Context) ([]*User, error) {
+	defer s.observe("GetAllUsers", time.Now())
+
 	query := `
-		SELECT id, username, email, password, created_at, updated_at 
-		FROM users 
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE deleted_at IS NULL
 		ORDER BY created_at DESC
 	`
-	
-	rows, err := s.db.QueryContext(ctx, query)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get users: %v", err)
-	}
-	defer rows.Close()
-
-	var users []*User
-	for rows.Next() {
-		var user User
-		var createdAt, updatedAt []byte
-		err := rows.Scan(
-			&user.ID, &user.Username, &user.Email, &user.Password,
-			&createdAt, &updatedAt,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan user: %v", err)
-		}
 
-		// Parse timestamps
-		user.CreatedAt, err = time.Parse("2006-01-02 15:04:05", string(createdAt))
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse created_at for user %d: %v", user.ID, err)
-		}
-		user.UpdatedAt, err = time.Parse("2006-01-02 15:04:05", string(updatedAt))
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse updated_at for user %d: %v", user.ID, err)
-		}
+	return s.queryUsers(ctx, query)
+}
 
-		users = append(users, &user)
-	}
+// GetUsersByIDs retrieves the users with the given IDs
+func (s *service) GetUsersByIDs(ctx context.Context, ids []int) ([]*User, error) {
+	defer s.observe("GetUsersByIDs", time.Now())
 
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating users: %v", err)
+	if len(ids) == 0 {
+		return []*User{}, nil
 	}
 
-	return users, nil
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE id IN (` + placeholders(len(ids)) + `) AND deleted_at IS NULL
+		ORDER BY id
+	`
+
+	return s.queryUsers(ctx, query, intArgs(ids)...)
 }
 
 // UpdateUser updates user information
-func (s *service) UpdateUser(ctx context.Context, id int, username, email string) (*User, error) {
+func (s *service) UpdateUser(ctx context.Context, id, version int, username, email string) (*User, error) {
+	defer s.observe("UpdateUser", time.Now())
+
 	query := `
 		UPDATE users 
-		SET username = ?, email = ? 
-		WHERE id = ?
+		SET username = ?, email = ?, version = version + 1
+		WHERE id = ? AND (? = 0 OR version = ?) AND deleted_at IS NULL
 	`
-	
-	_, err := s.db.ExecContext(ctx, query, username, email, id)
+
+	result, err := s.db.ExecContext(ctx, query, username, email, id, version, version)
 	if err != nil {
-		return nil, fmt.Errorf("failed to update user: %v", err)
+		return nil, fmt.Errorf("failed to update user: %w", translateUserError(err))
+	}
+
+	if err := s.checkUpdated(ctx, result, id); err != nil {
+		return nil, err
+	}
+
+	return s.GetUserByID(ctx, id)
+}
+
+// UserChanges holds the fields to change in a partial update. Nil fields
+// are left untouched.
+type UserChanges struct {
+	Username *string
+	Email    *string
+}
+
+// PatchUser updates only the columns set in changes
+func (s *service) PatchUser(ctx context.Context, id, version int, changes UserChanges) (*User, error) {
+	defer s.observe("PatchUser", time.Now())
+
+	// The version is always bumped, so an empty patch still checks it
+	set := []string{"version = version + 1"}
+	var args []any
+	if changes.Username != nil {
+		set = append(set, "username = ?")
+		args = append(args, *changes.Username)
+	}
+	if changes.Email != nil {
+		set = append(set, "email = ?")
+		args = append(args, *changes.Email)
+	}
+
+	query := `
+		UPDATE users
+		SET ` + strings.Join(set, ", ") + `
+		WHERE id = ? AND (? = 0 OR version = ?) AND deleted_at IS NULL
+	`
+	args = append(args, id, version, version)
+
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update user: %w", translateUserError(err))
+	}
+
+	if err := s.checkUpdated(ctx, result, id); err != nil {
+		return nil, err
 	}
 
 	return s.GetUserByID(ctx, id)
 }
 
 // UpdateUserPassword updates user password
-func (s *service) UpdateUserPassword(ctx context.Context, id int, password string) error {
+func (s *service) UpdateUserPassword(ctx context.Context, id, version int, password string) error {
+	defer s.observe("UpdateUserPassword", time.Now())
+
 	query := `
 		UPDATE users 
-		SET password = ? 
-		WHERE id = ?
+		SET password = ?, version = version + 1
+		WHERE id = ? AND (? = 0 OR version = ?) AND deleted_at IS NULL
 	`
-	
-	_, err := s.db.ExecContext(ctx, query, password, id)
+
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		return fmt.Errorf("failed to update user password: %w", err)
+	}
+
+	result, err := s.db.ExecContext(ctx, query, hash, id, version, version)
 	if err != nil {
-		return fmt.Errorf("failed to update user password: %v", err)
+		return fmt.Errorf("failed to update user password: %w", err)
+	}
+
+	if err := s.checkUpdated(ctx, result, id); err != nil {
+		return err
 	}
 
 	return nil
 }
 
-// DeleteUser deletes a user
+// VerifyPassword checks a password against the user's stored hash and
+// upgrades the hash if it was created with outdated parameters
+func (s *service) VerifyPassword(ctx context.Context, user *User, password string) error {
+	if user == nil {
+		return checkNoPassword(s.hasher, s.dummyHash, password)
+	}
+	if err := checkPassword(s.hasher, user.Password, password); err != nil {
+		return err
+	}
+
+	if s.hasher.NeedsRehash(user.Password) {
+		if err := s.UpdateUserPassword(ctx, user.ID, 0, password); err != nil {
+			s.logger.WarnContext(ctx, "failed to rehash password", "user_id", user.ID, "error", err)
+		}
+	}
+
+	return nil
+}
+
+// checkPassword checks password against the stored hash, returning
+// ErrInvalidPassword on mismatch
+func checkPassword(hasher auth.PasswordHasher, hash, password string) error {
+	err := hasher.Verify(hash, password)
+	if errors.Is(err, auth.ErrUnsupportedHash) && !auth.IsHash(hash) {
+		// Rows written before passwords were hashed hold the plaintext.
+		// Accept them once so that they get rehashed. Values that look
+		// like hashes never are, so that a corrupted hash does not become
+		// a password equal to itself.
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(password)) == 1 {
+			err = nil
+		} else {
+			err = auth.ErrPasswordMismatch
+		}
+	}
+	if errors.Is(err, auth.ErrPasswordMismatch) {
+		return ErrInvalidPassword
+	}
+	if err != nil {
+		return fmt.Errorf("failed to verify password: %w", err)
+	}
+	return nil
+}
+
+// newDummyHash returns a function hashing a random password with hasher on
+// its first call and returning that hash from then on
+func newDummyHash(hasher auth.PasswordHasher) func() (string, error) {
+	return sync.OnceValues(func() (string, error) {
+		return hasher.Hash(rand.Text())
+	})
+}
+
+// checkNoPassword checks password against the hash returned by dummyHash,
+// so that logins of users that do not exist take as long as those of users
+// that do. It always returns ErrInvalidPassword.
+func checkNoPassword(hasher auth.PasswordHasher, dummyHash func() (string, error), password string) error {
+	hash, err := dummyHash()
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+	_ = hasher.Verify(hash, password)
+	return ErrInvalidPassword
+}
+
+// DeleteUser soft-deletes a user and revokes all of their refresh tokens
 func (s *service) DeleteUser(ctx context.Context, id int) error {
-	query := `DELETE FROM users WHERE id = ?`
-	
-	result, err := s.db.ExecContext(ctx, query, id)
+	defer s.observe("DeleteUser", time.Now())
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE users
+		SET deleted_at = CURRENT_TIMESTAMP, version = version + 1
+		WHERE id = ? AND deleted_at IS NULL
+	`
+
+	result, err := tx.ExecContext(ctx, query, id)
 	if err != nil {
-		return fmt.Errorf("failed to delete user: %v", err)
+		return fmt.Errorf("failed to delete user: %w", err)
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %v", err)
+		return fmt.Errorf("failed to get rows affected: %w", err)
 	}
 
 	if rowsAffected == 0 {
 		return ErrUserNotFound
 	}
 
+	_, err = tx.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL`,
+		time.Now().UTC(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
 	return nil
 }
 
-// Health checks the health of the database connection by pinging the database.
-// It returns a map with keys indicating various health statistics.
-func (s *service) Health() map[string]string {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-	defer cancel()
+// RestoreUser undoes the soft deletion of a user
+func (s *service) RestoreUser(ctx context.Context, id int) (*User, error) {
+	defer s.observe("RestoreUser", time.Now())
+
+	query := `
+		UPDATE users
+		SET deleted_at = NULL, version = version + 1
+		WHERE id = ? AND deleted_at IS NOT NULL
+	`
+
+	result, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore user: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return nil, ErrUserNotFound
+	}
+
+	return s.GetUserByID(ctx, id)
+}
+
+// purgeBatchSize limits how many rows a single purge statement removes,
+// keeping row locks short on large backlogs
+const purgeBatchSize = 1000
+
+// PurgeDeletedUsers permanently removes users soft-deleted before
+// deletedBefore. Their refresh tokens and role assignments are removed by
+// the foreign keys.
+func (s *service) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	defer s.observe("PurgeDeletedUsers", time.Now())
+
+	query := `
+		DELETE FROM users
+		WHERE deleted_at IS NOT NULL AND deleted_at < ?
+		LIMIT ?
+	`
+
+	var total int64
+	for {
+		result, err := s.db.ExecContext(ctx, query, deletedBefore.UTC().Format("2006-01-02 15:04:05"), purgeBatchSize)
+		if err != nil {
+			return total, fmt.Errorf("failed to purge users: %w", err)
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return total, fmt.Errorf("failed to get rows affected: %w", err)
+		}
+
+		total += rowsAffected
+		if rowsAffected < purgeBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// userColumns are the columns scanned by scanUser
+const userColumns = "id, username, email, password, created_at, updated_at, deleted_at, version"
+
+func scanUser(row rowScanner) (*User, error) {
+	var user User
+	var createdAt, updatedAt, deletedAt []byte
+	err := row.Scan(
+		&user.ID, &user.Username, &user.Email, &user.Password,
+		&createdAt, &updatedAt, &deletedAt, &user.Version,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan user: %w", err)
+	}
+
+	// Parse timestamps
+	user.CreatedAt, err = time.Parse("2006-01-02 15:04:05", string(createdAt))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse created_at for user %d: %w", user.ID, err)
+	}
+	user.UpdatedAt, err = time.Parse("2006-01-02 15:04:05", string(updatedAt))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse updated_at for user %d: %w", user.ID, err)
+	}
+	if deletedAt != nil {
+		t, err := time.Parse("2006-01-02 15:04:05", string(deletedAt))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse deleted_at for user %d: %w", user.ID, err)
+		}
+		user.DeletedAt = &t
+	}
 
-	stats := make(map[string]string)
+	return &user, nil
+}
 
-	// Ping the database
-	err := s.db.PingContext(ctx)
+// checkUpdated returns ErrUserNotFound or ErrVersionConflict when a
+// conditional update of user id changed no rows
+func (s *service) checkUpdated(ctx context.Context, result sql.Result, id int) error {
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		stats["status"] = "down"
-		stats["error"] = fmt.Sprintf("db down: %v", err)
-		return stats
+		return fmt.Errorf("failed to get rows affected: %w", err)
 	}
 
-	// Database is up, add more statistics
-	stats["status"] = "up"
-	stats["message"] = "It's healthy"
+	if rowsAffected > 0 {
+		return nil
+	}
 
-	// Get database stats (like open connections, in use, idle, etc.)
-	dbStats := s.db.Stats()
-	stats["open_connections"] = strconv.Itoa(dbStats.OpenConnections)
-	stats["in_use"] = strconv.Itoa(dbStats.InUse)
-	stats["idle"] = strconv.Itoa(dbStats.Idle)
-	stats["wait_count"] = strconv.FormatInt(dbStats.WaitCount, 10)
-	stats["wait_duration"] = dbStats.WaitDuration.String()
-	stats["max_idle_closed"] = strconv.FormatInt(dbStats.MaxIdleClosed, 10)
-	stats["max_lifetime_closed"] = strconv.FormatInt(dbStats.MaxLifetimeClosed, 10)
+	// Every update bumps the version, so no change means the user is gone
+	// or the version did not match
+	if _, err := s.GetUserByID(ctx, id); err != nil {
+		return err
+	}
+	return ErrVersionConflict
+}
 
-	// Evaluate stats to provide a health message
-	if dbStats.OpenConnections > 40 { // Assuming 50 is the max for this example
-		stats["message"] = "The database is experiencing heavy load."
+// translateUserError maps unique key violations on the users table to
+// ErrDuplicateUsername and ErrDuplicateEmail. Other errors are returned as is.
+func translateUserError(err error) error {
+	var mysqlErr *mysqldriver.MySQLError
+	if !errors.As(err, &mysqlErr) || mysqlErr.Number != mysqlDuplicateEntry {
+		return err
 	}
-	if dbStats.WaitCount > 1000 {
-		stats["message"] = "The database has a high number of wait events, indicating potential bottlenecks."
+
+	// The message names the violated key, e.g.
+	// "Duplicate entry 'x' for key 'users.username'" (MySQL 8) or
+	// "Duplicate entry 'x' for key 'username'" (older versions).
+	switch {
+	case strings.HasSuffix(mysqlErr.Message, "'users.username'"), strings.HasSuffix(mysqlErr.Message, "'username'"):
+		return ErrDuplicateUsername
+	case strings.HasSuffix(mysqlErr.Message, "'users.email'"), strings.HasSuffix(mysqlErr.Message, "'email'"):
+		return ErrDuplicateEmail
 	}
+	return err
+}
+
+// Ping checks that the database is reachable
+func (s *service) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// PendingMigrations returns the embedded migrations that have not been
+// applied yet
+func (s *service) PendingMigrations(ctx context.Context) ([]MigrationStatus, error) {
+	defer s.observe("PendingMigrations", time.Now())
 
-	if dbStats.MaxIdleClosed > int64(dbStats.OpenConnections)/2 {
-		stats["message"] = "Many idle connections are being closed, consider revising the connection pool settings."
+	migrator, err := NewMigrator(s.db, s.logger)
+	if err != nil {
+		return nil, err
+	}
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	if dbStats.MaxLifetimeClosed > int64(dbStats.OpenConnections)/2 {
-		stats["message"] = "Many connections are being closed due to max lifetime, consider increasing max lifetime or revising the connection usage pattern."
+	var pending []MigrationStatus
+	for _, m := range status {
+		if !m.Applied {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// PoolWarnings evaluates the statistics of a connection pool, returning
+// warnings about signs of trouble such as heavy load or connection churn
+func PoolWarnings(stats sql.DBStats) []string {
+	var warnings []string
+	if stats.MaxOpenConnections > 0 && stats.OpenConnections > stats.MaxOpenConnections*4/5 {
+		warnings = append(warnings, fmt.Sprintf("The database is experiencing heavy load: %d of %d connections open.", stats.OpenConnections, stats.MaxOpenConnections))
 	}
+	if stats.WaitCount > 1000 {
+		warnings = append(warnings, fmt.Sprintf("The database has a high number of wait events (%d), indicating potential bottlenecks.", stats.WaitCount))
+	}
+	if stats.MaxIdleClosed > int64(stats.OpenConnections)/2 {
+		warnings = append(warnings, "Many idle connections are being closed, consider revising the connection pool settings.")
+	}
+	if stats.MaxLifetimeClosed > int64(stats.OpenConnections)/2 {
+		warnings = append(warnings, "Many connections are being closed due to max lifetime, consider increasing max lifetime or revising the connection usage pattern.")
+	}
+	return warnings
+}
 
-	return stats
+// Stats returns the statistics of the connection pool
+func (s *service) Stats() sql.DBStats {
+	return s.db.Stats()
+}
+
+// observe reports the time since start taken by the operation query
+func (s *service) observe(query string, start time.Time) {
+	if s.observer != nil {
+		s.observer.ObserveQuery(query, time.Since(start))
+	}
 }
 
 // Close closes the database connection.
@@ -391,6 +722,6 @@ func (s *service) Health() map[string]string {
 // If the connection is successfully closed, it returns nil.
 // If an error occurs while closing the connection, it returns the error.
 func (s *service) Close() error {
-	log.Printf("Disconnected from database: %s", dbname)
+	s.logger.Info("disconnected from database", "database", s.name)
 	return s.db.Close()
 }
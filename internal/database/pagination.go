@@ -0,0 +1,294 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrInvalidSortField = errors.New("invalid sort field")
+
+// Fields users can be sorted by
+const (
+	SortByID        = "id"
+	SortByUsername  = "username"
+	SortByEmail     = "email"
+	SortByCreatedAt = "created_at"
+	SortByUpdatedAt = "updated_at"
+)
+
+// userSortColumns maps sort fields to the columns they sort by. Only these
+// fixed identifiers are ever placed in ORDER BY.
+var userSortColumns = map[string]string{
+	SortByID:        "id",
+	SortByUsername:  "username",
+	SortByEmail:     "email",
+	SortByCreatedAt: "created_at",
+	SortByUpdatedAt: "updated_at",
+}
+
+// IsUserSortField reports whether users can be sorted by field
+func IsUserSortField(field string) bool {
+	_, ok := userSortColumns[field]
+	return ok
+}
+
+// UserSort orders the user listing. Ties are broken by id in the same
+// direction. The zero value sorts by created_at, newest first.
+type UserSort struct {
+	Field string
+	Desc  bool
+}
+
+// DefaultUserSort is the order used when none is requested
+var DefaultUserSort = UserSort{Field: SortByCreatedAt, Desc: true}
+
+// UserFilter restricts the user listing. Zero fields do not filter.
+type UserFilter struct {
+	UsernamePrefix string
+	EmailPrefix    string
+	Search         string // Prefix of either username or email
+	CreatedAfter   *time.Time
+	CreatedBefore  *time.Time
+	UpdatedAfter   *time.Time
+	UpdatedBefore  *time.Time
+}
+
+// UserCursor is a position in a user listing: the value of the sort field
+// and the id of the user at that position
+type UserCursor struct {
+	Value string
+	ID    int
+}
+
+// ListUsersParams selects a page of users. At most one of After and Before
+// may be set: After returns the users following the cursor, Before the
+// users preceding it. Without a cursor the first page is returned.
+type ListUsersParams struct {
+	Limit  int
+	After  *UserCursor
+	Before *UserCursor
+	Filter UserFilter
+	Sort   UserSort
+}
+
+// UserPage is a page of users
+type UserPage struct {
+	Users   []*User
+	HasNext bool // More users follow the last one
+	HasPrev bool // More users precede the first one
+}
+
+// CursorOf returns the cursor pointing at user in a listing sorted by field
+func CursorOf(user *User, field string) UserCursor {
+	cur := UserCursor{ID: user.ID}
+	switch field {
+	case SortByUsername:
+		cur.Value = user.Username
+	case SortByEmail:
+		cur.Value = user.Email
+	case SortByCreatedAt:
+		cur.Value = user.CreatedAt.Format("2006-01-02 15:04:05")
+	case SortByUpdatedAt:
+		cur.Value = user.UpdatedAt.Format("2006-01-02 15:04:05")
+	}
+	return cur
+}
+
+// ListUsers retrieves a page of filtered and sorted users using keyset
+// pagination
+func (s *service) ListUsers(ctx context.Context, params ListUsersParams) (*UserPage, error) {
+	defer s.observe("ListUsers", time.Now())
+
+	return s.listUsers(ctx, params, false)
+}
+
+// ListDeletedUsers is ListUsers for soft-deleted users
+func (s *service) ListDeletedUsers(ctx context.Context, params ListUsersParams) (*UserPage, error) {
+	defer s.observe("ListDeletedUsers", time.Now())
+
+	return s.listUsers(ctx, params, true)
+}
+
+// sort checks params and returns the order they select
+func (params ListUsersParams) sort() (UserSort, error) {
+	if params.Limit <= 0 {
+		return UserSort{}, fmt.Errorf("invalid limit %d", params.Limit)
+	}
+	if params.After != nil && params.Before != nil {
+		return UserSort{}, fmt.Errorf("only one of After and Before may be set")
+	}
+
+	sort := params.Sort
+	if sort.Field == "" {
+		sort = DefaultUserSort
+	}
+	if !IsUserSortField(sort.Field) {
+		return UserSort{}, fmt.Errorf("%w: %q", ErrInvalidSortField, sort.Field)
+	}
+	return sort, nil
+}
+
+func (s *service) listUsers(ctx context.Context, params ListUsersParams, deleted bool) (*UserPage, error) {
+	sort, err := params.sort()
+	if err != nil {
+		return nil, err
+	}
+	column := userSortColumns[sort.Field]
+
+	where, args := params.Filter.conditions()
+	if deleted {
+		where = append(where, "deleted_at IS NOT NULL")
+	} else {
+		where = append(where, "deleted_at IS NULL")
+	}
+
+	// Walking backwards runs the query in the opposite order and reverses
+	// the result afterwards
+	backwards := params.Before != nil
+	desc := sort.Desc != backwards
+
+	cursor := params.After
+	if backwards {
+		cursor = params.Before
+	}
+	if cursor != nil {
+		cmp := ">"
+		if desc {
+			cmp = "<"
+		}
+		if column == "id" {
+			where = append(where, "id "+cmp+" ?")
+			args = append(args, cursor.ID)
+		} else {
+			where = append(where, "("+column+" "+cmp+" ? OR ("+column+" = ? AND id "+cmp+" ?))")
+			args = append(args, cursor.Value, cursor.Value, cursor.ID)
+		}
+	}
+
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE ` + strings.Join(where, " AND ") + `
+	`
+
+	dir := "ASC"
+	if desc {
+		dir = "DESC"
+	}
+	if column == "id" {
+		query += "ORDER BY id " + dir + " LIMIT ?"
+	} else {
+		query += "ORDER BY " + column + " " + dir + ", id " + dir + " LIMIT ?"
+	}
+	// Fetch one extra row to find out whether another page exists
+	args = append(args, params.Limit+1)
+
+	users, err := s.queryUsers(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	more := len(users) > params.Limit
+	if more {
+		users = users[:params.Limit]
+	}
+
+	page := &UserPage{Users: users}
+	if backwards {
+		slices.Reverse(page.Users)
+		page.HasPrev = more
+		page.HasNext = true
+	} else {
+		page.HasNext = more
+		page.HasPrev = params.After != nil
+	}
+
+	return page, nil
+}
+
+// conditions returns the WHERE conditions and their arguments for f
+func (f UserFilter) conditions() ([]string, []any) {
+	var where []string
+	var args []any
+
+	if f.UsernamePrefix != "" {
+		where = append(where, `username LIKE ? ESCAPE '\\'`)
+		args = append(args, likePrefix(f.UsernamePrefix))
+	}
+	if f.EmailPrefix != "" {
+		where = append(where, `email LIKE ? ESCAPE '\\'`)
+		args = append(args, likePrefix(f.EmailPrefix))
+	}
+	if f.Search != "" {
+		where = append(where, `(username LIKE ? ESCAPE '\\' OR email LIKE ? ESCAPE '\\')`)
+		args = append(args, likePrefix(f.Search), likePrefix(f.Search))
+	}
+
+	ranges := []struct {
+		condition string
+		value     *time.Time
+	}{
+		{"created_at >= ?", f.CreatedAfter},
+		{"created_at < ?", f.CreatedBefore},
+		{"updated_at >= ?", f.UpdatedAfter},
+		{"updated_at < ?", f.UpdatedBefore},
+	}
+	for _, r := range ranges {
+		if r.value != nil {
+			where = append(where, r.condition)
+			args = append(args, r.value.UTC().Format("2006-01-02 15:04:05"))
+		}
+	}
+
+	return where, args
+}
+
+// likeEscaper escapes the LIKE wildcards and the escape character itself
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// likePrefix returns a LIKE pattern matching values starting with prefix
+func likePrefix(prefix string) string {
+	return likeEscaper.Replace(prefix) + "%"
+}
+
+// queryUsers runs a query selecting the columns of User
+func (s *service) queryUsers(ctx context.Context, query string, args ...any) ([]*User, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating users: %w", err)
+	}
+
+	return users, nil
+}
+
+// placeholders returns n comma-separated query placeholders for an IN list
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// intArgs converts ids into query arguments
+func intArgs(ids []int) []any {
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return args
+}
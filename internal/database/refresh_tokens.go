@@ -0,0 +1,194 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	ErrRefreshTokenExpired  = errors.New("refresh token expired")
+	ErrRefreshTokenRevoked  = errors.New("refresh token revoked")
+	ErrRefreshTokenReused   = errors.New("refresh token reused")
+)
+
+// RefreshToken represents a persisted refresh token.
+// Tokens rotated from one another share a FamilyID.
+type RefreshToken struct {
+	ID        int64
+	UserID    int
+	FamilyID  string
+	TokenHash string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
+
+const selectRefreshToken = `
+	SELECT id, user_id, family_id, token_hash, expires_at, used_at, revoked_at, created_at
+	FROM refresh_tokens
+	WHERE token_hash = ?
+`
+
+// CreateRefreshToken stores a new refresh token
+func (s *service) CreateRefreshToken(ctx context.Context, userID int, familyID, tokenHash string, expiresAt time.Time) (*RefreshToken, error) {
+	defer s.observe("CreateRefreshToken", time.Now())
+
+	query := `
+		INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at)
+		VALUES (?, ?, ?, ?)
+	`
+
+	_, err := s.db.ExecContext(ctx, query, userID, familyID, tokenHash, expiresAt.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create refresh token: %w", err)
+	}
+
+	return s.GetRefreshToken(ctx, tokenHash)
+}
+
+// GetRefreshToken retrieves a refresh token by its hash
+func (s *service) GetRefreshToken(ctx context.Context, tokenHash string) (*RefreshToken, error) {
+	defer s.observe("GetRefreshToken", time.Now())
+
+	return scanRefreshToken(s.db.QueryRowContext(ctx, selectRefreshToken, tokenHash))
+}
+
+// RotateRefreshToken marks the token identified by tokenHash as used at now
+// and replaces it with a new token in the same family. Presenting a token
+// that was already used revokes its whole family and returns
+// ErrRefreshTokenReused, and one that expired before now
+// ErrRefreshTokenExpired.
+func (s *service) RotateRefreshToken(ctx context.Context, tokenHash, newTokenHash string, now, expiresAt time.Time) (*RefreshToken, error) {
+	defer s.observe("RotateRefreshToken", time.Now())
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	token, err := scanRefreshToken(tx.QueryRowContext(ctx, selectRefreshToken+" FOR UPDATE", tokenHash))
+	if err != nil {
+		return nil, err
+	}
+
+	if token.RevokedAt != nil {
+		return nil, ErrRefreshTokenRevoked
+	}
+
+	now = now.UTC()
+
+	if token.UsedAt != nil {
+		// The token was already exchanged, so either the client or an
+		// attacker holds a stolen copy. Revoke everything derived from it.
+		if err := revokeRefreshTokenFamily(ctx, tx, token.FamilyID, now); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	if now.After(token.ExpiresAt) {
+		return nil, ErrRefreshTokenExpired
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE refresh_tokens SET used_at = ? WHERE id = ?`, now, token.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark refresh token used: %w", err)
+	}
+
+	query := `
+		INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at)
+		VALUES (?, ?, ?, ?)
+	`
+	_, err = tx.ExecContext(ctx, query, token.UserID, token.FamilyID, newTokenHash, expiresAt.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create refresh token: %w", err)
+	}
+
+	rotated, err := scanRefreshToken(tx.QueryRowContext(ctx, selectRefreshToken, newTokenHash))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return rotated, nil
+}
+
+// RevokeRefreshTokenFamily revokes every token in a family at now
+func (s *service) RevokeRefreshTokenFamily(ctx context.Context, familyID string, now time.Time) error {
+	defer s.observe("RevokeRefreshTokenFamily", time.Now())
+
+	return revokeRefreshTokenFamily(ctx, s.db, familyID, now.UTC())
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func revokeRefreshTokenFamily(ctx context.Context, db execer, familyID string, now time.Time) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = ?
+		WHERE family_id = ? AND revoked_at IS NULL
+	`
+
+	_, err := db.ExecContext(ctx, query, now, familyID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh token family: %w", err)
+	}
+
+	return nil
+}
+
+func scanRefreshToken(row *sql.Row) (*RefreshToken, error) {
+	var token RefreshToken
+	var expiresAt, usedAt, revokedAt, createdAt []byte
+	err := row.Scan(
+		&token.ID, &token.UserID, &token.FamilyID, &token.TokenHash,
+		&expiresAt, &usedAt, &revokedAt, &createdAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRefreshTokenNotFound
+		}
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+
+	// Parse timestamps
+	token.ExpiresAt, err = time.Parse("2006-01-02 15:04:05", string(expiresAt))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse expires_at: %w", err)
+	}
+	token.CreatedAt, err = time.Parse("2006-01-02 15:04:05", string(createdAt))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse created_at: %w", err)
+	}
+	if usedAt != nil {
+		t, err := time.Parse("2006-01-02 15:04:05", string(usedAt))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse used_at: %w", err)
+		}
+		token.UsedAt = &t
+	}
+	if revokedAt != nil {
+		t, err := time.Parse("2006-01-02 15:04:05", string(revokedAt))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse revoked_at: %w", err)
+		}
+		token.RevokedAt = &t
+	}
+
+	return &token, nil
+}
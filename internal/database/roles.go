@@ -0,0 +1,368 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+var (
+	ErrRoleNotFound       = errors.New("role not found")
+	ErrRoleExists         = errors.New("role already exists")
+	ErrPermissionNotFound = errors.New("permission not found")
+)
+
+// Built-in roles, created by the seed_roles migration
+const (
+	RoleAdmin   = "admin"   // Full access
+	RoleSupport = "support" // Read-only access to users
+)
+
+// Permissions checked by the API
+const (
+	PermUsersList    = "users:list"
+	PermUsersRead    = "users:read"
+	PermUsersUpdate  = "users:update"
+	PermUsersDelete  = "users:delete"
+	PermUsersRestore = "users:restore" // List and restore deleted users
+	PermRolesRead    = "roles:read"
+	PermRolesManage  = "roles:manage"
+)
+
+// Permission represents a permission that can be granted to roles
+type Permission struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Role represents a named set of permissions
+type Role struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Permissions []string  `json:"permissions"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ListRoles retrieves all roles with their permissions
+func (s *service) ListRoles(ctx context.Context) ([]*Role, error) {
+	defer s.observe("ListRoles", time.Now())
+
+	query := `
+		SELECT r.name, r.description, r.created_at, COALESCE(GROUP_CONCAT(rp.permission ORDER BY rp.permission), '')
+		FROM roles r
+		LEFT JOIN role_permissions rp ON rp.role = r.name
+		GROUP BY r.name, r.description, r.created_at
+		ORDER BY r.name
+	`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get roles: %w", err)
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating roles: %w", err)
+	}
+
+	return roles, nil
+}
+
+// GetRole retrieves a role with its permissions
+func (s *service) GetRole(ctx context.Context, name string) (*Role, error) {
+	defer s.observe("GetRole", time.Now())
+
+	query := `
+		SELECT r.name, r.description, r.created_at, COALESCE(GROUP_CONCAT(rp.permission ORDER BY rp.permission), '')
+		FROM roles r
+		LEFT JOIN role_permissions rp ON rp.role = r.name
+		WHERE r.name = ?
+		GROUP BY r.name, r.description, r.created_at
+	`
+
+	role, err := scanRole(s.db.QueryRowContext(ctx, query, name))
+	if err == sql.ErrNoRows {
+		return nil, ErrRoleNotFound
+	}
+	return role, err
+}
+
+// CreateRole creates a role without permissions
+func (s *service) CreateRole(ctx context.Context, name, description string) (*Role, error) {
+	defer s.observe("CreateRole", time.Now())
+
+	_, err := s.db.ExecContext(ctx, `INSERT INTO roles (name, description) VALUES (?, ?)`, name, description)
+	if err != nil {
+		var mysqlErr *mysqldriver.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry {
+			return nil, ErrRoleExists
+		}
+		return nil, fmt.Errorf("failed to create role: %w", err)
+	}
+
+	return s.GetRole(ctx, name)
+}
+
+// DeleteRole deletes a role together with its grants and assignments
+func (s *service) DeleteRole(ctx context.Context, name string) error {
+	defer s.observe("DeleteRole", time.Now())
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM roles WHERE name = ?`, name)
+	if err != nil {
+		return fmt.Errorf("failed to delete role: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrRoleNotFound
+	}
+
+	return nil
+}
+
+// ListPermissions retrieves all permissions
+func (s *service) ListPermissions(ctx context.Context) ([]*Permission, error) {
+	defer s.observe("ListPermissions", time.Now())
+
+	rows, err := s.db.QueryContext(ctx, `SELECT name, description FROM permissions ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get permissions: %w", err)
+	}
+	defer rows.Close()
+
+	permissions := []*Permission{}
+	for rows.Next() {
+		var p Permission
+		if err := rows.Scan(&p.Name, &p.Description); err != nil {
+			return nil, fmt.Errorf("failed to scan permission: %w", err)
+		}
+		permissions = append(permissions, &p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating permissions: %w", err)
+	}
+
+	return permissions, nil
+}
+
+// GrantPermission grants a permission to a role. Granting a permission the
+// role already has is a no-op.
+func (s *service) GrantPermission(ctx context.Context, role, permission string) error {
+	defer s.observe("GrantPermission", time.Now())
+
+	if _, err := s.GetRole(ctx, role); err != nil {
+		return err
+	}
+
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM permissions WHERE name = ?)`, permission).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to get permission: %w", err)
+	}
+	if !exists {
+		return ErrPermissionNotFound
+	}
+
+	_, err = s.db.ExecContext(ctx, `INSERT IGNORE INTO role_permissions (role, permission) VALUES (?, ?)`, role, permission)
+	if err != nil {
+		return fmt.Errorf("failed to grant permission: %w", err)
+	}
+
+	return nil
+}
+
+// RevokePermission revokes a permission from a role
+func (s *service) RevokePermission(ctx context.Context, role, permission string) error {
+	defer s.observe("RevokePermission", time.Now())
+
+	if _, err := s.GetRole(ctx, role); err != nil {
+		return err
+	}
+
+	_, err := s.db.ExecContext(ctx, `DELETE FROM role_permissions WHERE role = ? AND permission = ?`, role, permission)
+	if err != nil {
+		return fmt.Errorf("failed to revoke permission: %w", err)
+	}
+
+	return nil
+}
+
+// GetUserRoles retrieves the names of the roles assigned to a user
+func (s *service) GetUserRoles(ctx context.Context, userID int) ([]string, error) {
+	defer s.observe("GetUserRoles", time.Now())
+
+	query := `
+		SELECT role
+		FROM user_roles
+		WHERE user_id = ?
+		ORDER BY role
+	`
+
+	return s.queryStrings(ctx, query, userID)
+}
+
+// GetRolesByUserIDs retrieves the names of the roles assigned to each of
+// the given users in one query. Users without roles map to an empty slice.
+func (s *service) GetRolesByUserIDs(ctx context.Context, userIDs []int) (map[int][]string, error) {
+	defer s.observe("GetRolesByUserIDs", time.Now())
+
+	roles := make(map[int][]string, len(userIDs))
+	for _, id := range userIDs {
+		roles[id] = []string{}
+	}
+	if len(userIDs) == 0 {
+		return roles, nil
+	}
+
+	query := `
+		SELECT user_id, role
+		FROM user_roles
+		WHERE user_id IN (` + placeholders(len(userIDs)) + `)
+		ORDER BY user_id, role
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, intArgs(userIDs)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user roles: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var role string
+		if err := rows.Scan(&id, &role); err != nil {
+			return nil, fmt.Errorf("failed to scan user role: %w", err)
+		}
+		roles[id] = append(roles[id], role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating user roles: %w", err)
+	}
+
+	return roles, nil
+}
+
+// AssignRole assigns a role to a user. Assigning a role the user already
+// has is a no-op.
+func (s *service) AssignRole(ctx context.Context, userID int, role string) error {
+	defer s.observe("AssignRole", time.Now())
+
+	if _, err := s.GetRole(ctx, role); err != nil {
+		return err
+	}
+
+	if _, err := s.GetUserByID(ctx, userID); err != nil {
+		return err
+	}
+
+	_, err := s.db.ExecContext(ctx, `INSERT IGNORE INTO user_roles (user_id, role) VALUES (?, ?)`, userID, role)
+	if err != nil {
+		return fmt.Errorf("failed to assign role: %w", err)
+	}
+
+	return nil
+}
+
+// UnassignRole removes a role from a user
+func (s *service) UnassignRole(ctx context.Context, userID int, role string) error {
+	defer s.observe("UnassignRole", time.Now())
+
+	_, err := s.db.ExecContext(ctx, `DELETE FROM user_roles WHERE user_id = ? AND role = ?`, userID, role)
+	if err != nil {
+		return fmt.Errorf("failed to unassign role: %w", err)
+	}
+
+	return nil
+}
+
+// GetUserPermissions retrieves the permissions granted to a user through
+// all of their roles. Deleted users keep their role assignments so that
+// they can be restored, but hold no permissions.
+func (s *service) GetUserPermissions(ctx context.Context, userID int) ([]string, error) {
+	defer s.observe("GetUserPermissions", time.Now())
+
+	query := `
+		SELECT DISTINCT rp.permission
+		FROM user_roles ur
+		JOIN users u ON u.id = ur.user_id
+		JOIN role_permissions rp ON rp.role = ur.role
+		WHERE ur.user_id = ? AND u.deleted_at IS NULL
+		ORDER BY rp.permission
+	`
+
+	return s.queryStrings(ctx, query, userID)
+}
+
+// queryStrings runs a query returning a single string column
+func (s *service) queryStrings(ctx context.Context, query string, args ...any) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query: %w", err)
+	}
+	defer rows.Close()
+
+	values := []string{}
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		values = append(values, v)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return values, nil
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRole(row rowScanner) (*Role, error) {
+	var role Role
+	var createdAt []byte
+	var permissions string
+	err := row.Scan(&role.Name, &role.Description, &createdAt, &permissions)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan role: %w", err)
+	}
+
+	role.CreatedAt, err = time.Parse("2006-01-02 15:04:05", string(createdAt))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse created_at: %w", err)
+	}
+
+	role.Permissions = []string{}
+	if permissions != "" {
+		role.Permissions = strings.Split(permissions, ",")
+	}
+
+	return &role, nil
+}
@@ -0,0 +1,887 @@
+// Package storetest checks that implementations of mysql.Service behave
+// alike. The same suite runs against MySQL and the in-memory service, so
+// that tests using the latter can trust its semantics.
+package storetest
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang-backend/internal/database"
+)
+
+// Run runs the conformance suite against the services returned by
+// newService, which is called once per subtest. Services may share their
+// data, as with a MySQL database: every subtest creates its own users,
+// roles and keys under unique names and only looks at those.
+func Run(t *testing.T, newService func(t *testing.T) mysql.Service) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, srv mysql.Service)
+	}{
+		{"CreateUser", testCreateUser},
+		{"UniqueUsers", testUniqueUsers},
+		{"UpdateUser", testUpdateUser},
+		{"Password", testPassword},
+		{"SoftDelete", testSoftDelete},
+		{"BatchLookups", testBatchLookups},
+		{"ListUsers", testListUsers},
+		{"ListUsersFilter", testListUsersFilter},
+		{"RefreshTokens", testRefreshTokens},
+		{"Roles", testRoles},
+		{"IdempotencyKeys", testIdempotencyKeys},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newService(t))
+		})
+	}
+}
+
+// unique returns a name no other call returns, starting with prefix
+func unique(prefix string) string {
+	return prefix + strings.ToLower(rand.Text()[:12])
+}
+
+// createUser creates a user with unique username and email starting with
+// prefix
+func createUser(t *testing.T, srv mysql.Service, prefix string) *mysql.User {
+	t.Helper()
+	name := unique(prefix)
+	user, err := srv.CreateUser(context.Background(), name, name+"@example.com", "password123")
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	return user
+}
+
+// checkTimestamp fails unless ts is a recent UTC timestamp with a
+// precision of one second
+func checkTimestamp(t *testing.T, name string, ts time.Time) {
+	t.Helper()
+	if ts.Location() != time.UTC || !ts.Equal(ts.Truncate(time.Second)) {
+		t.Errorf("expected %s to be in UTC with whole seconds, got %v", name, ts)
+	}
+	if age := time.Since(ts); age < -time.Minute || age > time.Minute {
+		t.Errorf("expected %s to be now, got %v", name, ts)
+	}
+}
+
+func testCreateUser(t *testing.T, srv mysql.Service) {
+	ctx := context.Background()
+	name := unique("create")
+
+	user, err := srv.CreateUser(ctx, name, name+"@example.com", "password123")
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	if user.ID <= 0 || user.Username != name || user.Email != name+"@example.com" || user.Version != 1 {
+		t.Errorf("unexpected user %+v", user)
+	}
+	if user.Password == "" || user.Password == "password123" {
+		t.Errorf("expected the password to be stored hashed, got %q", user.Password)
+	}
+	if user.DeletedAt != nil {
+		t.Errorf("expected a new user not to be deleted, got %v", user.DeletedAt)
+	}
+	checkTimestamp(t, "created_at", user.CreatedAt)
+	if !user.UpdatedAt.Equal(user.CreatedAt) {
+		t.Errorf("expected updated_at %v to equal created_at %v", user.UpdatedAt, user.CreatedAt)
+	}
+
+	other := createUser(t, srv, "create")
+	if other.ID <= user.ID {
+		t.Errorf("expected increasing IDs, got %d after %d", other.ID, user.ID)
+	}
+
+	// Lookups ignore case, like the collation of the columns
+	lookups := map[string]func() (*mysql.User, error){
+		"ID":       func() (*mysql.User, error) { return srv.GetUserByID(ctx, user.ID) },
+		"email":    func() (*mysql.User, error) { return srv.GetUserByEmail(ctx, strings.ToUpper(user.Email)) },
+		"username": func() (*mysql.User, error) { return srv.GetUserByUsername(ctx, strings.ToUpper(name)) },
+	}
+	for by, lookup := range lookups {
+		got, err := lookup()
+		if err != nil {
+			t.Fatalf("failed to get user by %s: %v", by, err)
+		}
+		if *got != *user {
+			t.Errorf("expected user by %s to be %+v, got %+v", by, user, got)
+		}
+	}
+
+	if _, err := srv.GetUserByID(ctx, 1<<30); !errors.Is(err, mysql.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound for an unknown ID, got %v", err)
+	}
+	if _, err := srv.GetUserByEmail(ctx, unique("missing")+"@example.com"); !errors.Is(err, mysql.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound for an unknown email, got %v", err)
+	}
+	if _, err := srv.GetUserByUsername(ctx, unique("missing")); !errors.Is(err, mysql.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound for an unknown username, got %v", err)
+	}
+
+	all, err := srv.GetAllUsers(ctx)
+	if err != nil {
+		t.Fatalf("failed to get all users: %v", err)
+	}
+	if !slices.ContainsFunc(all, func(u *mysql.User) bool { return u.ID == user.ID }) {
+		t.Errorf("expected all users to include %d", user.ID)
+	}
+	if !slices.IsSortedFunc(all, func(a, b *mysql.User) int { return b.CreatedAt.Compare(a.CreatedAt) }) {
+		t.Error("expected all users to be sorted newest first")
+	}
+}
+
+func testUniqueUsers(t *testing.T, srv mysql.Service) {
+	ctx := context.Background()
+	user := createUser(t, srv, "unique")
+
+	tests := []struct {
+		name            string
+		username, email string
+		want            error
+	}{
+		{"username", user.Username, unique("unique") + "@example.com", mysql.ErrDuplicateUsername},
+		{"username in another case", strings.ToUpper(user.Username), unique("unique") + "@example.com", mysql.ErrDuplicateUsername},
+		{"email", unique("unique"), user.Email, mysql.ErrDuplicateEmail},
+		{"email in another case", unique("unique"), strings.ToUpper(user.Email), mysql.ErrDuplicateEmail},
+		{"both", user.Username, user.Email, mysql.ErrDuplicateUsername},
+	}
+	for _, tt := range tests {
+		if _, err := srv.CreateUser(ctx, tt.username, tt.email, "password123"); !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v on create, got %v", tt.name, tt.want, err)
+		}
+
+		other := createUser(t, srv, "unique")
+		if _, err := srv.UpdateUser(ctx, other.ID, 0, tt.username, tt.email); !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v on update, got %v", tt.name, tt.want, err)
+		}
+	}
+
+	// Deleted users keep their username and email
+	if err := srv.DeleteUser(ctx, user.ID); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+	if _, err := srv.CreateUser(ctx, user.Username, unique("unique")+"@example.com", "password123"); !errors.Is(err, mysql.ErrDuplicateUsername) {
+		t.Errorf("expected the username of a deleted user to stay taken, got %v", err)
+	}
+}
+
+func testUpdateUser(t *testing.T, srv mysql.Service) {
+	ctx := context.Background()
+	user := createUser(t, srv, "update")
+	name := unique("update")
+
+	updated, err := srv.UpdateUser(ctx, user.ID, user.Version, name, name+"@example.com")
+	if err != nil {
+		t.Fatalf("failed to update user: %v", err)
+	}
+	if updated.Username != name || updated.Email != name+"@example.com" || updated.Version != user.Version+1 {
+		t.Errorf("unexpected updated user %+v", updated)
+	}
+	if !updated.CreatedAt.Equal(user.CreatedAt) || updated.UpdatedAt.Before(user.UpdatedAt) {
+		t.Errorf("expected only updated_at to move forward, got %+v", updated)
+	}
+	checkTimestamp(t, "updated_at", updated.UpdatedAt)
+
+	// A stale version is rejected, a zero version always matches
+	if _, err := srv.UpdateUser(ctx, user.ID, user.Version, name, name+"@example.com"); !errors.Is(err, mysql.ErrVersionConflict) {
+		t.Errorf("expected ErrVersionConflict, got %v", err)
+	}
+	if _, err := srv.UpdateUser(ctx, user.ID, 0, name, name+"@example.com"); err != nil {
+		t.Errorf("expected an unconditional update to succeed, got %v", err)
+	}
+	if _, err := srv.UpdateUser(ctx, 1<<30, 0, unique("update"), unique("update")+"@example.com"); !errors.Is(err, mysql.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+
+	current, err := srv.GetUserByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+	if current.Version != user.Version+2 {
+		t.Errorf("expected version %d, got %d", user.Version+2, current.Version)
+	}
+
+	// Patches only write the given fields, and empty ones still bump the
+	// version
+	email := unique("patch") + "@example.com"
+	patched, err := srv.PatchUser(ctx, user.ID, current.Version, mysql.UserChanges{Email: &email})
+	if err != nil {
+		t.Fatalf("failed to patch user: %v", err)
+	}
+	if patched.Username != name || patched.Email != email || patched.Version != current.Version+1 {
+		t.Errorf("unexpected patched user %+v", patched)
+	}
+	patched, err = srv.PatchUser(ctx, user.ID, patched.Version, mysql.UserChanges{})
+	if err != nil {
+		t.Fatalf("failed to apply an empty patch: %v", err)
+	}
+	if patched.Version != current.Version+2 {
+		t.Errorf("expected an empty patch to bump the version, got %d", patched.Version)
+	}
+	if _, err := srv.PatchUser(ctx, user.ID, current.Version, mysql.UserChanges{}); !errors.Is(err, mysql.ErrVersionConflict) {
+		t.Errorf("expected ErrVersionConflict, got %v", err)
+	}
+
+	// Users returned are copies
+	patched.Username = "modified"
+	if got, _ := srv.GetUserByID(ctx, user.ID); got.Username != name {
+		t.Errorf("expected the stored user to be unaffected, got %s", got.Username)
+	}
+}
+
+func testPassword(t *testing.T, srv mysql.Service) {
+	ctx := context.Background()
+	user := createUser(t, srv, "password")
+
+	if err := srv.VerifyPassword(ctx, user, "password123"); err != nil {
+		t.Fatalf("failed to verify password: %v", err)
+	}
+	if err := srv.VerifyPassword(ctx, user, "wrong"); !errors.Is(err, mysql.ErrInvalidPassword) {
+		t.Errorf("expected ErrInvalidPassword, got %v", err)
+	}
+	if err := srv.VerifyPassword(ctx, nil, "password123"); !errors.Is(err, mysql.ErrInvalidPassword) {
+		t.Errorf("expected ErrInvalidPassword without a user, got %v", err)
+	}
+
+	if err := srv.UpdateUserPassword(ctx, user.ID, user.Version+1, "newpassword"); !errors.Is(err, mysql.ErrVersionConflict) {
+		t.Errorf("expected ErrVersionConflict, got %v", err)
+	}
+	if err := srv.UpdateUserPassword(ctx, user.ID, user.Version, "newpassword"); err != nil {
+		t.Fatalf("failed to update password: %v", err)
+	}
+	if err := srv.UpdateUserPassword(ctx, 1<<30, 0, "newpassword"); !errors.Is(err, mysql.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+
+	updated, err := srv.GetUserByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+	if updated.Version != user.Version+1 {
+		t.Errorf("expected the password change to bump the version, got %d", updated.Version)
+	}
+	if err := srv.VerifyPassword(ctx, updated, "newpassword"); err != nil {
+		t.Errorf("expected the new password to be accepted, got %v", err)
+	}
+	if err := srv.VerifyPassword(ctx, updated, "password123"); !errors.Is(err, mysql.ErrInvalidPassword) {
+		t.Errorf("expected the old password to be rejected, got %v", err)
+	}
+}
+
+func testSoftDelete(t *testing.T, srv mysql.Service) {
+	ctx := context.Background()
+	user := createUser(t, srv, "delete")
+	if err := srv.AssignRole(ctx, user.ID, mysql.RoleSupport); err != nil {
+		t.Fatalf("failed to assign role: %v", err)
+	}
+
+	family := unique("family")
+	token, err := srv.CreateRefreshToken(ctx, user.ID, family, unique("hash"), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("failed to create refresh token: %v", err)
+	}
+
+	if err := srv.DeleteUser(ctx, user.ID); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+	if err := srv.DeleteUser(ctx, user.ID); !errors.Is(err, mysql.ErrUserNotFound) {
+		t.Errorf("expected deleting twice to fail with ErrUserNotFound, got %v", err)
+	}
+
+	// Deleted users are invisible to the other operations
+	if _, err := srv.GetUserByID(ctx, user.ID); !errors.Is(err, mysql.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound by ID, got %v", err)
+	}
+	if _, err := srv.GetUserByEmail(ctx, user.Email); !errors.Is(err, mysql.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound by email, got %v", err)
+	}
+	if _, err := srv.UpdateUser(ctx, user.ID, 0, unique("delete"), unique("delete")+"@example.com"); !errors.Is(err, mysql.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound on update, got %v", err)
+	}
+	if err := srv.AssignRole(ctx, user.ID, mysql.RoleSupport); !errors.Is(err, mysql.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound on role assignment, got %v", err)
+	}
+	if perms, err := srv.GetUserPermissions(ctx, user.ID); err != nil || len(perms) != 0 {
+		t.Errorf("expected a deleted user to hold no permissions, got %v, %v", perms, err)
+	}
+
+	revoked, err := srv.GetRefreshToken(ctx, token.TokenHash)
+	if err != nil {
+		t.Fatalf("failed to get refresh token: %v", err)
+	}
+	if revoked.RevokedAt == nil {
+		t.Error("expected the refresh tokens of a deleted user to be revoked")
+	}
+
+	page, err := srv.ListDeletedUsers(ctx, mysql.ListUsersParams{Limit: 10, Filter: mysql.UserFilter{UsernamePrefix: user.Username}})
+	if err != nil {
+		t.Fatalf("failed to list deleted users: %v", err)
+	}
+	if len(page.Users) != 1 || page.Users[0].DeletedAt == nil || page.Users[0].Version != user.Version+1 {
+		t.Fatalf("expected the deleted user with its deletion time, got %+v", page.Users)
+	}
+	checkTimestamp(t, "deleted_at", *page.Users[0].DeletedAt)
+
+	restored, err := srv.RestoreUser(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("failed to restore user: %v", err)
+	}
+	if restored.DeletedAt != nil || restored.Version != user.Version+2 {
+		t.Errorf("unexpected restored user %+v", restored)
+	}
+	if perms, _ := srv.GetUserPermissions(ctx, user.ID); len(perms) == 0 {
+		t.Error("expected a restored user to get the permissions of its roles back")
+	}
+	if _, err := srv.RestoreUser(ctx, user.ID); !errors.Is(err, mysql.ErrUserNotFound) {
+		t.Errorf("expected restoring an active user to fail with ErrUserNotFound, got %v", err)
+	}
+
+	// Purging removes users deleted before the cutoff, with their roles
+	if err := srv.AssignRole(ctx, user.ID, mysql.RoleSupport); err != nil {
+		t.Fatalf("failed to assign role: %v", err)
+	}
+	if err := srv.DeleteUser(ctx, user.ID); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+	if _, err := srv.PurgeDeletedUsers(ctx, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("failed to purge users: %v", err)
+	}
+	if _, err := srv.RestoreUser(ctx, user.ID); err != nil {
+		t.Fatalf("expected a recently deleted user to be kept, got %v", err)
+	}
+	if err := srv.DeleteUser(ctx, user.ID); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+	purged, err := srv.PurgeDeletedUsers(ctx, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("failed to purge users: %v", err)
+	}
+	if purged < 1 {
+		t.Errorf("expected the deleted user to be purged, got %d", purged)
+	}
+	if _, err := srv.RestoreUser(ctx, user.ID); !errors.Is(err, mysql.ErrUserNotFound) {
+		t.Errorf("expected a purged user to be gone, got %v", err)
+	}
+	if roles, err := srv.GetUserRoles(ctx, user.ID); err != nil || len(roles) != 0 {
+		t.Errorf("expected the roles of a purged user to be gone, got %v, %v", roles, err)
+	}
+	if _, err := srv.GetRefreshToken(ctx, token.TokenHash); !errors.Is(err, mysql.ErrRefreshTokenNotFound) {
+		t.Errorf("expected the refresh tokens of a purged user to be gone, got %v", err)
+	}
+
+	// Their username is free again
+	if _, err := srv.CreateUser(ctx, user.Username, user.Email, "password123"); err != nil {
+		t.Errorf("expected the username of a purged user to be free, got %v", err)
+	}
+}
+
+func testBatchLookups(t *testing.T, srv mysql.Service) {
+	ctx := context.Background()
+	a := createUser(t, srv, "batch")
+	b := createUser(t, srv, "batch")
+	deleted := createUser(t, srv, "batch")
+	if err := srv.DeleteUser(ctx, deleted.ID); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+
+	users, err := srv.GetUsersByIDs(ctx, []int{b.ID, deleted.ID, 1 << 30, a.ID})
+	if err != nil {
+		t.Fatalf("failed to get users: %v", err)
+	}
+	if len(users) != 2 || users[0].ID != a.ID || users[1].ID != b.ID {
+		t.Errorf("expected users %d and %d by ID, got %+v", a.ID, b.ID, users)
+	}
+
+	users, err = srv.GetUsersByIDs(ctx, nil)
+	if err != nil || users == nil || len(users) != 0 {
+		t.Errorf("expected no users for no IDs, got %v, %v", users, err)
+	}
+
+	if err := srv.AssignRole(ctx, a.ID, mysql.RoleSupport); err != nil {
+		t.Fatalf("failed to assign role: %v", err)
+	}
+	if err := srv.AssignRole(ctx, a.ID, mysql.RoleAdmin); err != nil {
+		t.Fatalf("failed to assign role: %v", err)
+	}
+	roles, err := srv.GetRolesByUserIDs(ctx, []int{a.ID, b.ID})
+	if err != nil {
+		t.Fatalf("failed to get roles: %v", err)
+	}
+	if len(roles) != 2 || !slices.Equal(roles[a.ID], []string{mysql.RoleAdmin, mysql.RoleSupport}) || roles[b.ID] == nil || len(roles[b.ID]) != 0 {
+		t.Errorf("expected sorted roles by user, with empty slices for users without roles, got %v", roles)
+	}
+}
+
+// usernames returns the usernames of users
+func usernames(users []*mysql.User) []string {
+	names := make([]string, len(users))
+	for i, u := range users {
+		names[i] = u.Username
+	}
+	return names
+}
+
+func testListUsers(t *testing.T, srv mysql.Service) {
+	ctx := context.Background()
+	prefix := unique("list")
+
+	// Usernames in mixed case sort without regard to case
+	names := []string{prefix + "_c", prefix + "_A", prefix + "_e", prefix + "_B", prefix + "_d"}
+	for _, name := range names {
+		if _, err := srv.CreateUser(ctx, name, name+"@example.com", "password123"); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+	filter := mysql.UserFilter{UsernamePrefix: prefix}
+	sort := mysql.UserSort{Field: mysql.SortByUsername}
+
+	first, err := srv.ListUsers(ctx, mysql.ListUsersParams{Limit: 2, Filter: filter, Sort: sort})
+	if err != nil {
+		t.Fatalf("failed to list users: %v", err)
+	}
+	if got := usernames(first.Users); !slices.Equal(got, []string{prefix + "_A", prefix + "_B"}) || !first.HasNext || first.HasPrev {
+		t.Fatalf("unexpected first page %v (next %v, prev %v)", got, first.HasNext, first.HasPrev)
+	}
+
+	after := mysql.CursorOf(first.Users[1], sort.Field)
+	second, err := srv.ListUsers(ctx, mysql.ListUsersParams{Limit: 2, After: &after, Filter: filter, Sort: sort})
+	if err != nil {
+		t.Fatalf("failed to list users: %v", err)
+	}
+	if got := usernames(second.Users); !slices.Equal(got, []string{prefix + "_c", prefix + "_d"}) || !second.HasNext || !second.HasPrev {
+		t.Fatalf("unexpected second page %v (next %v, prev %v)", got, second.HasNext, second.HasPrev)
+	}
+
+	after = mysql.CursorOf(second.Users[1], sort.Field)
+	last, err := srv.ListUsers(ctx, mysql.ListUsersParams{Limit: 2, After: &after, Filter: filter, Sort: sort})
+	if err != nil {
+		t.Fatalf("failed to list users: %v", err)
+	}
+	if got := usernames(last.Users); !slices.Equal(got, []string{prefix + "_e"}) || last.HasNext || !last.HasPrev {
+		t.Fatalf("unexpected last page %v (next %v, prev %v)", got, last.HasNext, last.HasPrev)
+	}
+
+	before := mysql.CursorOf(last.Users[0], sort.Field)
+	back, err := srv.ListUsers(ctx, mysql.ListUsersParams{Limit: 3, Before: &before, Filter: filter, Sort: sort})
+	if err != nil {
+		t.Fatalf("failed to list users: %v", err)
+	}
+	if got := usernames(back.Users); !slices.Equal(got, []string{prefix + "_B", prefix + "_c", prefix + "_d"}) || !back.HasNext || !back.HasPrev {
+		t.Fatalf("unexpected previous page %v (next %v, prev %v)", got, back.HasNext, back.HasPrev)
+	}
+
+	// Descending by ID is creation order reversed
+	desc, err := srv.ListUsers(ctx, mysql.ListUsersParams{Limit: 10, Filter: filter, Sort: mysql.UserSort{Field: mysql.SortByID, Desc: true}})
+	if err != nil {
+		t.Fatalf("failed to list users: %v", err)
+	}
+	want := slices.Clone(names)
+	slices.Reverse(want)
+	if got := usernames(desc.Users); !slices.Equal(got, want) {
+		t.Errorf("expected %v by descending ID, got %v", want, got)
+	}
+
+	// The default order is newest first, ties broken by descending ID
+	byDefault, err := srv.ListUsers(ctx, mysql.ListUsersParams{Limit: 10, Filter: filter})
+	if err != nil {
+		t.Fatalf("failed to list users: %v", err)
+	}
+	if !slices.IsSortedFunc(byDefault.Users, func(a, b *mysql.User) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return b.ID - a.ID
+	}) {
+		t.Errorf("expected users newest first, got %v", usernames(byDefault.Users))
+	}
+
+	invalid := []mysql.ListUsersParams{
+		{Limit: 0},
+		{Limit: 10, After: &after, Before: &before},
+		{Limit: 10, Sort: mysql.UserSort{Field: "password"}},
+	}
+	for _, params := range invalid {
+		if _, err := srv.ListUsers(ctx, params); err == nil {
+			t.Errorf("expected %+v to be rejected", params)
+		}
+	}
+	if _, err := srv.ListUsers(ctx, invalid[2]); !errors.Is(err, mysql.ErrInvalidSortField) {
+		t.Errorf("expected ErrInvalidSortField, got %v", err)
+	}
+}
+
+func testListUsersFilter(t *testing.T, srv mysql.Service) {
+	ctx := context.Background()
+	prefix := unique("filter")
+
+	alice, err := srv.CreateUser(ctx, prefix+"_alice", prefix+"_a@example.com", "password123")
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	if _, err := srv.CreateUser(ctx, prefix+"_bob", prefix+"_alice@example.com", "password123"); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	// Wildcards in prefixes match literally
+	if _, err := srv.CreateUser(ctx, prefix+"%carol", prefix+"_carol@example.com", "password123"); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	list := func(filter mysql.UserFilter) []string {
+		t.Helper()
+		page, err := srv.ListUsers(ctx, mysql.ListUsersParams{
+			Limit:  10,
+			Filter: filter,
+			Sort:   mysql.UserSort{Field: mysql.SortByID},
+		})
+		if err != nil {
+			t.Fatalf("failed to list users: %v", err)
+		}
+		return usernames(page.Users)
+	}
+
+	past := alice.CreatedAt.Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	tests := []struct {
+		name   string
+		filter mysql.UserFilter
+		want   []string
+	}{
+		{"username prefix in another case", mysql.UserFilter{UsernamePrefix: strings.ToUpper(prefix + "_A")}, []string{prefix + "_alice"}},
+		{"literal wildcard", mysql.UserFilter{UsernamePrefix: prefix + "%"}, []string{prefix + "%carol"}},
+		{"email prefix", mysql.UserFilter{UsernamePrefix: prefix, EmailPrefix: prefix + "_c"}, []string{prefix + "%carol"}},
+		{"search", mysql.UserFilter{Search: prefix + "_alice"}, []string{prefix + "_alice", prefix + "_bob"}},
+		{"created range", mysql.UserFilter{UsernamePrefix: prefix, CreatedAfter: &past, CreatedBefore: &future}, []string{prefix + "_alice", prefix + "_bob", prefix + "%carol"}},
+		{"created after", mysql.UserFilter{UsernamePrefix: prefix, CreatedAfter: &future}, []string{}},
+		{"updated before", mysql.UserFilter{UsernamePrefix: prefix, UpdatedBefore: &past}, []string{}},
+	}
+	for _, tt := range tests {
+		if got := list(tt.filter); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func testRefreshTokens(t *testing.T, srv mysql.Service) {
+	ctx := context.Background()
+	user := createUser(t, srv, "token")
+	family := unique("family")
+	hash := unique("hash")
+	now := time.Now()
+	expiresAt := now.Add(time.Hour)
+
+	token, err := srv.CreateRefreshToken(ctx, user.ID, family, hash, expiresAt)
+	if err != nil {
+		t.Fatalf("failed to create refresh token: %v", err)
+	}
+	if token.UserID != user.ID || token.FamilyID != family || token.TokenHash != hash || token.UsedAt != nil || token.RevokedAt != nil {
+		t.Errorf("unexpected refresh token %+v", token)
+	}
+	if d := token.ExpiresAt.Sub(expiresAt); d < -time.Second || d > time.Second {
+		t.Errorf("expected expiry %v, got %v", expiresAt, token.ExpiresAt)
+	}
+	checkTimestamp(t, "created_at", token.CreatedAt)
+
+	if _, err := srv.CreateRefreshToken(ctx, user.ID, family, hash, expiresAt); err == nil {
+		t.Error("expected a duplicate token hash to be rejected")
+	}
+	if _, err := srv.CreateRefreshToken(ctx, 1<<30, family, unique("hash"), expiresAt); err == nil {
+		t.Error("expected a token of an unknown user to be rejected")
+	}
+	if _, err := srv.GetRefreshToken(ctx, unique("hash")); !errors.Is(err, mysql.ErrRefreshTokenNotFound) {
+		t.Errorf("expected ErrRefreshTokenNotFound, got %v", err)
+	}
+
+	newHash := unique("hash")
+	rotated, err := srv.RotateRefreshToken(ctx, hash, newHash, now, expiresAt)
+	if err != nil {
+		t.Fatalf("failed to rotate refresh token: %v", err)
+	}
+	if rotated.FamilyID != family || rotated.UserID != user.ID || rotated.TokenHash != newHash || rotated.ID == token.ID {
+		t.Errorf("unexpected rotated token %+v", rotated)
+	}
+	used, err := srv.GetRefreshToken(ctx, hash)
+	if err != nil {
+		t.Fatalf("failed to get refresh token: %v", err)
+	}
+	if used.UsedAt == nil || used.RevokedAt != nil {
+		t.Errorf("expected the rotated token to be used but not revoked, got %+v", used)
+	}
+
+	// Reusing a token revokes its family
+	if _, err := srv.RotateRefreshToken(ctx, hash, unique("hash"), now, expiresAt); !errors.Is(err, mysql.ErrRefreshTokenReused) {
+		t.Fatalf("expected ErrRefreshTokenReused, got %v", err)
+	}
+	if _, err := srv.RotateRefreshToken(ctx, newHash, unique("hash"), now, expiresAt); !errors.Is(err, mysql.ErrRefreshTokenRevoked) {
+		t.Errorf("expected ErrRefreshTokenRevoked, got %v", err)
+	}
+	if _, err := srv.RotateRefreshToken(ctx, unique("hash"), unique("hash"), now, expiresAt); !errors.Is(err, mysql.ErrRefreshTokenNotFound) {
+		t.Errorf("expected ErrRefreshTokenNotFound, got %v", err)
+	}
+
+	expired := unique("hash")
+	if _, err := srv.CreateRefreshToken(ctx, user.ID, unique("family"), expired, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("failed to create refresh token: %v", err)
+	}
+	if _, err := srv.RotateRefreshToken(ctx, expired, unique("hash"), now, expiresAt); !errors.Is(err, mysql.ErrRefreshTokenExpired) {
+		t.Errorf("expected ErrRefreshTokenExpired, got %v", err)
+	}
+
+	// Expiry is checked against the given time rather than the wall clock
+	later := unique("hash")
+	if _, err := srv.CreateRefreshToken(ctx, user.ID, unique("family"), later, expiresAt); err != nil {
+		t.Fatalf("failed to create refresh token: %v", err)
+	}
+	if _, err := srv.RotateRefreshToken(ctx, later, unique("hash"), now.Add(2*time.Hour), now.Add(3*time.Hour)); !errors.Is(err, mysql.ErrRefreshTokenExpired) {
+		t.Errorf("expected ErrRefreshTokenExpired by the given time, got %v", err)
+	}
+
+	other := unique("family")
+	otherHash := unique("hash")
+	if _, err := srv.CreateRefreshToken(ctx, user.ID, other, otherHash, expiresAt); err != nil {
+		t.Fatalf("failed to create refresh token: %v", err)
+	}
+	if err := srv.RevokeRefreshTokenFamily(ctx, other, now); err != nil {
+		t.Fatalf("failed to revoke refresh token family: %v", err)
+	}
+	if revoked, err := srv.GetRefreshToken(ctx, otherHash); err != nil || revoked.RevokedAt == nil {
+		t.Errorf("expected the token to be revoked, got %+v, %v", revoked, err)
+	}
+}
+
+func testRoles(t *testing.T, srv mysql.Service) {
+	ctx := context.Background()
+
+	// The seeded roles and permissions
+	admin, err := srv.GetRole(ctx, mysql.RoleAdmin)
+	if err != nil {
+		t.Fatalf("failed to get admin role: %v", err)
+	}
+	all := []string{
+		mysql.PermRolesManage, mysql.PermRolesRead, mysql.PermUsersDelete, mysql.PermUsersList,
+		mysql.PermUsersRead, mysql.PermUsersRestore, mysql.PermUsersUpdate,
+	}
+	if !slices.Equal(admin.Permissions, all) {
+		t.Errorf("expected admin to have %v, got %v", all, admin.Permissions)
+	}
+	permissions, err := srv.ListPermissions(ctx)
+	if err != nil {
+		t.Fatalf("failed to list permissions: %v", err)
+	}
+	var names []string
+	for _, p := range permissions {
+		if p.Description == "" {
+			t.Errorf("expected permission %s to have a description", p.Name)
+		}
+		names = append(names, p.Name)
+	}
+	if !slices.Equal(names, all) {
+		t.Errorf("expected permissions %v, got %v", all, names)
+	}
+
+	name := unique("role")
+	role, err := srv.CreateRole(ctx, name, "Test role")
+	if err != nil {
+		t.Fatalf("failed to create role: %v", err)
+	}
+	if role.Name != name || role.Description != "Test role" || role.Permissions == nil || len(role.Permissions) != 0 {
+		t.Errorf("unexpected role %+v", role)
+	}
+	checkTimestamp(t, "created_at", role.CreatedAt)
+	if _, err := srv.CreateRole(ctx, name, ""); !errors.Is(err, mysql.ErrRoleExists) {
+		t.Errorf("expected ErrRoleExists, got %v", err)
+	}
+	if _, err := srv.GetRole(ctx, unique("role")); !errors.Is(err, mysql.ErrRoleNotFound) {
+		t.Errorf("expected ErrRoleNotFound, got %v", err)
+	}
+
+	for _, p := range []string{mysql.PermUsersRead, mysql.PermUsersList, mysql.PermUsersRead} {
+		if err := srv.GrantPermission(ctx, name, p); err != nil {
+			t.Fatalf("failed to grant %s: %v", p, err)
+		}
+	}
+	if err := srv.GrantPermission(ctx, name, "users:fly"); !errors.Is(err, mysql.ErrPermissionNotFound) {
+		t.Errorf("expected ErrPermissionNotFound, got %v", err)
+	}
+	if err := srv.GrantPermission(ctx, unique("role"), mysql.PermUsersRead); !errors.Is(err, mysql.ErrRoleNotFound) {
+		t.Errorf("expected ErrRoleNotFound, got %v", err)
+	}
+	role, err = srv.GetRole(ctx, name)
+	if err != nil {
+		t.Fatalf("failed to get role: %v", err)
+	}
+	if want := []string{mysql.PermUsersList, mysql.PermUsersRead}; !slices.Equal(role.Permissions, want) {
+		t.Errorf("expected permissions %v, got %v", want, role.Permissions)
+	}
+
+	roles, err := srv.ListRoles(ctx)
+	if err != nil {
+		t.Fatalf("failed to list roles: %v", err)
+	}
+	// Other roles may sort differently under the collation of the database
+	index := func(name string) int {
+		return slices.IndexFunc(roles, func(r *mysql.Role) bool { return r.Name == name })
+	}
+	if index(mysql.RoleAdmin) > index(mysql.RoleSupport) {
+		t.Error("expected roles to be sorted by name")
+	}
+	if !slices.ContainsFunc(roles, func(r *mysql.Role) bool { return r.Name == name && len(r.Permissions) == 2 }) {
+		t.Errorf("expected roles to include %s with its permissions", name)
+	}
+
+	user := createUser(t, srv, "roles")
+	for _, r := range []string{name, mysql.RoleSupport, name} {
+		if err := srv.AssignRole(ctx, user.ID, r); err != nil {
+			t.Fatalf("failed to assign %s: %v", r, err)
+		}
+	}
+	if err := srv.AssignRole(ctx, user.ID, unique("role")); !errors.Is(err, mysql.ErrRoleNotFound) {
+		t.Errorf("expected ErrRoleNotFound, got %v", err)
+	}
+	if err := srv.AssignRole(ctx, 1<<30, name); !errors.Is(err, mysql.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+
+	userRoles, err := srv.GetUserRoles(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("failed to get user roles: %v", err)
+	}
+	if want := []string{name, mysql.RoleSupport}; !slices.Equal(userRoles, want) {
+		t.Errorf("expected roles %v, got %v", want, userRoles)
+	}
+	perms, err := srv.GetUserPermissions(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("failed to get user permissions: %v", err)
+	}
+	if want := []string{mysql.PermUsersList, mysql.PermUsersRead}; !slices.Equal(perms, want) {
+		t.Errorf("expected distinct permissions %v, got %v", want, perms)
+	}
+
+	if err := srv.RevokePermission(ctx, name, mysql.PermUsersList); err != nil {
+		t.Fatalf("failed to revoke permission: %v", err)
+	}
+	if err := srv.RevokePermission(ctx, name, mysql.PermRolesManage); err != nil {
+		t.Errorf("expected revoking a permission the role lacks to succeed, got %v", err)
+	}
+	if err := srv.UnassignRole(ctx, user.ID, mysql.RoleSupport); err != nil {
+		t.Fatalf("failed to unassign role: %v", err)
+	}
+	if perms, _ := srv.GetUserPermissions(ctx, user.ID); !slices.Equal(perms, []string{mysql.PermUsersRead}) {
+		t.Errorf("expected only %s to be left, got %v", mysql.PermUsersRead, perms)
+	}
+
+	// Deleting a role removes its assignments
+	if err := srv.DeleteRole(ctx, name); err != nil {
+		t.Fatalf("failed to delete role: %v", err)
+	}
+	if err := srv.DeleteRole(ctx, name); !errors.Is(err, mysql.ErrRoleNotFound) {
+		t.Errorf("expected ErrRoleNotFound, got %v", err)
+	}
+	if userRoles, _ := srv.GetUserRoles(ctx, user.ID); len(userRoles) != 0 {
+		t.Errorf("expected the assignments of a deleted role to be removed, got %v", userRoles)
+	}
+}
+
+func testIdempotencyKeys(t *testing.T, srv mysql.Service) {
+	ctx := context.Background()
+	user := createUser(t, srv, "idempotency")
+	key := unique("key")
+	now := time.Now()
+	expiresAt := now.Add(time.Hour)
+	staleBefore := now.Add(-time.Minute)
+
+	existing, err := srv.ClaimIdempotencyKey(ctx, user.ID, key, "fingerprint", now, expiresAt, staleBefore)
+	if err != nil || existing != nil {
+		t.Fatalf("expected to claim a new key, got %+v, %v", existing, err)
+	}
+
+	existing, err = srv.ClaimIdempotencyKey(ctx, user.ID, key, "other", now, expiresAt, staleBefore)
+	if !errors.Is(err, mysql.ErrIdempotencyKeyExists) {
+		t.Fatalf("expected ErrIdempotencyKeyExists, got %v", err)
+	}
+	if existing.Fingerprint != "fingerprint" || existing.StatusCode != 0 || existing.Key != key || existing.UserID != user.ID {
+		t.Errorf("expected the in-flight record, got %+v", existing)
+	}
+	checkTimestamp(t, "created_at", existing.CreatedAt)
+
+	// Keys are scoped to users
+	if _, err := srv.ClaimIdempotencyKey(ctx, 0, key, "fingerprint", now, expiresAt, staleBefore); err != nil {
+		t.Errorf("expected another user to claim the same key, got %v", err)
+	}
+
+	header := map[string][]string{"Content-Type": {"application/json"}}
+	if err := srv.CompleteIdempotencyKey(ctx, user.ID, key, 201, header, []byte(`{"id":1}`)); err != nil {
+		t.Fatalf("failed to complete key: %v", err)
+	}
+	if err := srv.CompleteIdempotencyKey(ctx, user.ID, key, 201, header, nil); !errors.Is(err, mysql.ErrIdempotencyKeyNotFound) {
+		t.Errorf("expected completing twice to fail with ErrIdempotencyKeyNotFound, got %v", err)
+	}
+
+	// Completed records are kept until they expire, even when stale
+	if err := srv.ReleaseIdempotencyKey(ctx, user.ID, key); err != nil {
+		t.Fatalf("failed to release key: %v", err)
+	}
+	record, err := srv.GetIdempotencyKey(ctx, user.ID, key)
+	if err != nil {
+		t.Fatalf("failed to get key: %v", err)
+	}
+	if record.StatusCode != 201 || string(record.Body) != `{"id":1}` || record.Header["Content-Type"][0] != "application/json" {
+		t.Errorf("unexpected completed record %+v", record)
+	}
+	if _, err := srv.ClaimIdempotencyKey(ctx, user.ID, key, "fingerprint", now, expiresAt, time.Now().Add(time.Hour)); !errors.Is(err, mysql.ErrIdempotencyKeyExists) {
+		t.Errorf("expected a completed key to stay claimed, got %v", err)
+	}
+
+	// Released and stale in-flight records can be claimed again
+	released := unique("key")
+	if _, err := srv.ClaimIdempotencyKey(ctx, user.ID, released, "fingerprint", now, expiresAt, staleBefore); err != nil {
+		t.Fatalf("failed to claim key: %v", err)
+	}
+	if err := srv.ReleaseIdempotencyKey(ctx, user.ID, released); err != nil {
+		t.Fatalf("failed to release key: %v", err)
+	}
+	if _, err := srv.GetIdempotencyKey(ctx, user.ID, released); !errors.Is(err, mysql.ErrIdempotencyKeyNotFound) {
+		t.Errorf("expected ErrIdempotencyKeyNotFound, got %v", err)
+	}
+	stale := unique("key")
+	if _, err := srv.ClaimIdempotencyKey(ctx, user.ID, stale, "fingerprint", now, expiresAt, staleBefore); err != nil {
+		t.Fatalf("failed to claim key: %v", err)
+	}
+	if _, err := srv.ClaimIdempotencyKey(ctx, user.ID, stale, "other", now, expiresAt, time.Now().Add(time.Hour)); err != nil {
+		t.Errorf("expected a stale key to be taken over, got %v", err)
+	}
+	if record, _ := srv.GetIdempotencyKey(ctx, user.ID, stale); record == nil || record.Fingerprint != "other" {
+		t.Errorf("expected the record to be replaced, got %+v", record)
+	}
+
+	// Expired records can be claimed again and are purged
+	expired := unique("key")
+	if _, err := srv.ClaimIdempotencyKey(ctx, user.ID, expired, "fingerprint", now, time.Now().Add(-time.Hour), staleBefore); err != nil {
+		t.Fatalf("failed to claim key: %v", err)
+	}
+	if _, err := srv.ClaimIdempotencyKey(ctx, user.ID, expired, "fingerprint", now, time.Now().Add(-time.Hour), staleBefore); err != nil {
+		t.Errorf("expected an expired key to be taken over, got %v", err)
+	}
+	if _, err := srv.ClaimIdempotencyKey(ctx, user.ID, key, "other", now.Add(2*time.Hour), now.Add(3*time.Hour), staleBefore); err != nil {
+		t.Errorf("expected a key to be taken over once expired by the given time, got %v", err)
+	}
+	purged, err := srv.PurgeExpiredIdempotencyKeys(ctx, time.Now())
+	if err != nil {
+		t.Fatalf("failed to purge keys: %v", err)
+	}
+	if purged < 1 {
+		t.Errorf("expected the expired key to be purged, got %d", purged)
+	}
+	if _, err := srv.GetIdempotencyKey(ctx, user.ID, expired); !errors.Is(err, mysql.ErrIdempotencyKeyNotFound) {
+		t.Errorf("expected the expired key to be gone, got %v", err)
+	}
+	if _, err := srv.GetIdempotencyKey(ctx, user.ID, key); err != nil {
+		t.Errorf("expected the unexpired key to be kept, got %v", err)
+	}
+}
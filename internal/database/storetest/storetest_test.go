@@ -0,0 +1,16 @@
+package storetest
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"golang-backend/internal/auth"
+	"golang-backend/internal/database"
+)
+
+func TestMemory(t *testing.T) {
+	Run(t, func(t *testing.T) mysql.Service {
+		return mysql.NewMemory(&auth.BcryptHasher{Cost: bcrypt.MinCost})
+	})
+}
@@ -0,0 +1,213 @@
+// Package health runs the checks behind the liveness, readiness and startup
+// probes of the application.
+package health
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Probe is a kind of health probe
+type Probe string
+
+const (
+	// Liveness fails when the process is broken beyond recovery and must
+	// be restarted
+	Liveness Probe = "livez"
+	// Readiness fails while the process cannot serve requests, so that no
+	// traffic is sent its way
+	Readiness Probe = "readyz"
+	// Startup fails until the process has finished starting. Once all its
+	// checks have passed it keeps passing.
+	Startup Probe = "startupz"
+)
+
+// Statuses of checks and reports
+const (
+	StatusOK   = "ok"
+	StatusWarn = "warn" // Healthy, with warnings
+	StatusFail = "fail"
+)
+
+// DefaultTimeout bounds checks registered without a timeout
+const DefaultTimeout = time.Second
+
+// CheckFunc checks one dependency or component. It returns an error if it is
+// unhealthy, and otherwise warnings about its state, if any.
+type CheckFunc func(ctx context.Context) (warnings []string, err error)
+
+// Check is a named check run by probes
+type Check struct {
+	Name string
+	Func CheckFunc
+
+	// Timeout bounds each run of the check, DefaultTimeout if zero
+	Timeout time.Duration
+	// CacheTTL is how long a result is reused before the check is run
+	// again, so that frequent probes do not hammer dependencies. Results
+	// are not cached if zero.
+	CacheTTL time.Duration
+}
+
+// Result is the outcome of a check
+type Result struct {
+	Name     string   `json:"name"`
+	Status   string   `json:"status"`
+	Error    string   `json:"error,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+	Duration string   `json:"duration,omitempty"` // How long the check took, e.g. "1.2ms"
+	Cached   bool     `json:"cached,omitempty"`
+}
+
+// Report is the outcome of a probe
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks,omitempty"`
+}
+
+// Healthy reports whether every check passed, possibly with warnings
+func (r *Report) Healthy() bool {
+	return r.Status != StatusFail
+}
+
+// Registry holds the checks of each probe
+type Registry struct {
+	mu      sync.Mutex
+	checks  map[Probe][]*check
+	started bool // Whether the startup probe has passed
+}
+
+// check is a registered Check with its cached result
+type check struct {
+	Check
+
+	mu     sync.Mutex // Held while running, so that concurrent probes share a run
+	result Result
+	at     time.Time // When result was obtained, zero if never
+}
+
+// NewRegistry returns a registry without checks. Probes without checks
+// pass.
+func NewRegistry() *Registry {
+	return &Registry{checks: map[Probe][]*check{}}
+}
+
+// Register adds c to the given probes. Probes sharing a check share its
+// cached result.
+func (r *Registry) Register(c Check, probes ...Probe) {
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultTimeout
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	registered := &check{Check: c}
+	for _, p := range probes {
+		r.checks[p] = append(r.checks[p], registered)
+	}
+}
+
+// Run runs the checks of probe in parallel and reports their results in
+// the order they were registered
+func (r *Registry) Run(ctx context.Context, probe Probe) *Report {
+	r.mu.Lock()
+	checks := r.checks[probe]
+	started := r.started
+	r.mu.Unlock()
+
+	report := &Report{Status: StatusOK, Checks: make([]Result, len(checks))}
+	if probe == Startup && started {
+		for i, c := range checks {
+			report.Checks[i] = Result{Name: c.Name, Status: StatusOK, Cached: true}
+		}
+		return report
+	}
+
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report.Checks[i] = c.run(ctx)
+		}()
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		switch result.Status {
+		case StatusFail:
+			report.Status = StatusFail
+		case StatusWarn:
+			if report.Status == StatusOK {
+				report.Status = StatusWarn
+			}
+		}
+	}
+
+	if probe == Startup && report.Healthy() {
+		r.mu.Lock()
+		r.started = true
+		r.mu.Unlock()
+	}
+	return report
+}
+
+// run returns the cached result of c if still fresh, and runs it otherwise
+func (c *check) run(ctx context.Context) Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.at.IsZero() && time.Since(c.at) < c.CacheTTL {
+		result := c.result
+		result.Cached = true
+		return result
+	}
+
+	// Results are shared, so they must not depend on whether the client
+	// that triggered the run went away
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	warnings, err := c.call(ctx)
+	result := Result{Name: c.Name, Status: StatusOK, Duration: time.Since(start).String()}
+	switch {
+	case err != nil:
+		result.Status = StatusFail
+		result.Error = err.Error()
+	case len(warnings) > 0:
+		result.Status = StatusWarn
+		result.Warnings = warnings
+	}
+
+	c.result, c.at = result, time.Now()
+	return result
+}
+
+// call runs the check, turning panics into failures and giving up when ctx
+// is done even if the check does not honor it
+func (c *check) call(ctx context.Context) (warnings []string, err error) {
+	type outcome struct {
+		warnings []string
+		err      error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				done <- outcome{err: fmt.Errorf("panic: %v", recovered)}
+			}
+		}()
+		warnings, err := c.Func(ctx)
+		done <- outcome{warnings, err}
+	}()
+
+	select {
+	case o := <-done:
+		return o.warnings, o.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("timed out after %v", c.Timeout)
+	}
+}
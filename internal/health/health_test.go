@@ -0,0 +1,97 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	var calls atomic.Int32
+	fail := atomic.Bool{}
+	r.Register(Check{
+		Name: "database",
+		Func: func(ctx context.Context) ([]string, error) {
+			calls.Add(1)
+			if fail.Load() {
+				return nil, errors.New("down")
+			}
+			return []string{"busy"}, nil
+		},
+		CacheTTL: time.Hour,
+	}, Readiness, Startup)
+	r.Register(Check{
+		Name: "slow",
+		Func: func(ctx context.Context) ([]string, error) {
+			time.Sleep(time.Second) // Ignores ctx
+			return nil, nil
+		},
+		Timeout: 10 * time.Millisecond,
+	}, Liveness)
+	r.Register(Check{
+		Name: "broken",
+		Func: func(ctx context.Context) ([]string, error) { panic("oops") },
+	}, Liveness)
+
+	// Warnings do not fail probes
+	report := r.Run(context.Background(), Readiness)
+	if !report.Healthy() || report.Status != StatusWarn || len(report.Checks) != 1 || report.Checks[0].Warnings[0] != "busy" {
+		t.Fatalf("expected a warning, got %+v", report)
+	}
+
+	// Results are cached, and shared between probes
+	fail.Store(true)
+	report = r.Run(context.Background(), Startup)
+	if !report.Healthy() || !report.Checks[0].Cached || calls.Load() != 1 {
+		t.Fatalf("expected the cached result, got %+v after %d calls", report, calls.Load())
+	}
+
+	// Checks time out and recover from panics
+	start := time.Now()
+	report = r.Run(context.Background(), Liveness)
+	if report.Healthy() || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("expected the probe to fail quickly, got %+v after %v", report, time.Since(start))
+	}
+	if report.Checks[0].Error != "timed out after 10ms" || report.Checks[1].Error != "panic: oops" {
+		t.Fatalf("unexpected check results %+v", report.Checks)
+	}
+
+	// Probes without checks pass
+	if report := NewRegistry().Run(context.Background(), Readiness); !report.Healthy() {
+		t.Fatalf("expected an empty probe to pass, got %+v", report)
+	}
+}
+
+func TestStartupLatches(t *testing.T) {
+	r := NewRegistry()
+	var up atomic.Bool
+	r.Register(Check{
+		Name: "database",
+		Func: func(ctx context.Context) ([]string, error) {
+			if !up.Load() {
+				return nil, errors.New("down")
+			}
+			return nil, nil
+		},
+	}, Readiness, Startup)
+
+	if r.Run(context.Background(), Startup).Healthy() {
+		t.Fatal("expected startup to fail while the database is down")
+	}
+	up.Store(true)
+	if !r.Run(context.Background(), Startup).Healthy() {
+		t.Fatal("expected startup to pass once the database is up")
+	}
+
+	// Once started, only readiness follows the database
+	up.Store(false)
+	if !r.Run(context.Background(), Startup).Healthy() {
+		t.Error("expected startup to keep passing")
+	}
+	if r.Run(context.Background(), Readiness).Healthy() {
+		t.Error("expected readiness to fail while the database is down")
+	}
+}
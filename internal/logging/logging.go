@@ -0,0 +1,156 @@
+// Package logging builds the structured loggers of the application. Log
+// records carry the ID of the request they were logged for, and values of
+// sensitive attributes such as passwords and Authorization headers are
+// redacted.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Redacted replaces the values of sensitive attributes
+const Redacted = "[REDACTED]"
+
+// Options configure a logger
+type Options struct {
+	Format string // "text" (default) or "json"
+	Level  slog.Level
+}
+
+// New returns a logger writing records in the given format to w
+func New(w io.Writer, opts Options) (*slog.Logger, error) {
+	handlerOpts := &slog.HandlerOptions{Level: opts.Level, ReplaceAttr: redact}
+
+	var h slog.Handler
+	switch strings.ToLower(opts.Format) {
+	case "", "text":
+		h = slog.NewTextHandler(w, handlerOpts)
+	case "json":
+		h = slog.NewJSONHandler(w, handlerOpts)
+	default:
+		return nil, fmt.Errorf("unsupported log format %q", opts.Format)
+	}
+
+	return slog.New(&contextHandler{Handler: h}), nil
+}
+
+// Fatal logs msg with err at error level and exits, standing in for
+// log.Fatal during startup
+func Fatal(logger *slog.Logger, msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+type (
+	loggerContextKey    struct{}
+	requestIDContextKey struct{}
+)
+
+// NewContext returns a copy of ctx carrying logger
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or the default logger
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerContextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// WithRequestID returns a copy of ctx carrying the ID of the request it
+// belongs to. Records logged with the context are tagged with it.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, or ""
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// contextHandler adds the request ID of the context to records
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// sensitiveKeys are the attribute keys, HTTP headers and JSON members
+// whose values are redacted, compared case-insensitively. Keys containing
+// "password", "secret" or "token" are redacted too.
+var sensitiveKeys = map[string]bool{
+	"authorization": true,
+	"cookie":        true,
+	"set-cookie":    true,
+}
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+	return sensitiveKeys[key] ||
+		strings.Contains(key, "password") ||
+		strings.Contains(key, "secret") ||
+		strings.Contains(key, "token")
+}
+
+// redact is the ReplaceAttr function of the handlers. Besides sensitive
+// attributes, it redacts the sensitive entries of headers and JSON objects
+// logged as a whole.
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if isSensitive(a.Key) {
+		return slog.String(a.Key, Redacted)
+	}
+
+	switch v := a.Value.Any().(type) {
+	case http.Header:
+		return slog.Any(a.Key, redactHeader(v))
+	case map[string]any:
+		return slog.Any(a.Key, redactMap(v))
+	}
+	return a
+}
+
+func redactHeader(h http.Header) http.Header {
+	redacted := make(http.Header, len(h))
+	for name, values := range h {
+		if isSensitive(name) {
+			values = []string{Redacted}
+		}
+		redacted[name] = values
+	}
+	return redacted
+}
+
+func redactMap(m map[string]any) map[string]any {
+	redacted := make(map[string]any, len(m))
+	for key, value := range m {
+		if isSensitive(key) {
+			value = Redacted
+		} else if nested, ok := value.(map[string]any); ok {
+			value = redactMap(nested)
+		}
+		redacted[key] = value
+	}
+	return redacted
+}
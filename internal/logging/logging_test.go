@@ -0,0 +1,63 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRedaction(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, Options{Format: "json"})
+	if err != nil {
+		t.Fatalf("failed to create logger: %v", err)
+	}
+
+	header := http.Header{"Authorization": {"Bearer abc"}, "Accept": {"application/json"}}
+	body := map[string]any{"username": "alice", "password": "hunter2", "nested": map[string]any{"refresh_token": "xyz"}}
+	logger.Info("request", "header", header, "body", body, "client_secret", "s3cr3t", "Authorization", "Bearer def")
+
+	out := buf.String()
+	for _, secret := range []string{"abc", "hunter2", "xyz", "s3cr3t", "def"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("expected %q to be redacted, got %s", secret, out)
+		}
+	}
+	for _, kept := range []string{"alice", "application/json"} {
+		if !strings.Contains(out, kept) {
+			t.Errorf("expected %q to be logged, got %s", kept, out)
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, Options{Format: "text", Level: slog.LevelWarn})
+	if err != nil {
+		t.Fatalf("failed to create logger: %v", err)
+	}
+	ctx := WithRequestID(NewContext(context.Background(), logger), "req-1")
+
+	FromContext(ctx).InfoContext(ctx, "dropped")
+	FromContext(ctx).With("component", "test").WarnContext(ctx, "kept")
+
+	out := buf.String()
+	if strings.Contains(out, "dropped") {
+		t.Errorf("expected records below the level to be dropped, got %s", out)
+	}
+	if !strings.Contains(out, "request_id=req-1") || !strings.Contains(out, "component=test") {
+		t.Errorf("expected the record to carry the request ID, got %s", out)
+	}
+	if FromContext(context.Background()) != slog.Default() {
+		t.Error("expected the default logger without a logger in the context")
+	}
+}
+
+func TestNewRejectsUnknownFormat(t *testing.T) {
+	if _, err := New(&bytes.Buffer{}, Options{Format: "xml"}); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
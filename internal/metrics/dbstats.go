@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func dbDesc(name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "db_pool", name), help, nil, nil)
+}
+
+var (
+	dbMaxOpenDesc      = dbDesc("max_open_connections", "Maximum number of open connections to the database.")
+	dbOpenDesc         = dbDesc("open_connections", "Established connections, both in use and idle.")
+	dbInUseDesc        = dbDesc("in_use_connections", "Connections currently in use.")
+	dbIdleDesc         = dbDesc("idle_connections", "Idle connections.")
+	dbWaitCountDesc    = dbDesc("wait_count_total", "Connections waited for.")
+	dbWaitDurationDesc = dbDesc("wait_duration_seconds_total", "Time blocked waiting for a new connection.")
+	dbMaxIdleDesc      = dbDesc("max_idle_closed_total", "Connections closed due to the maximum of idle connections.")
+	dbMaxIdleTimeDesc  = dbDesc("max_idle_time_closed_total", "Connections closed due to the maximum idle time.")
+	dbMaxLifetimeDesc  = dbDesc("max_lifetime_closed_total", "Connections closed due to the maximum connection lifetime.")
+)
+
+// dbStatsCollector exports the sql.DBStats of a connection pool, read at
+// every scrape
+type dbStatsCollector struct {
+	stats func() sql.DBStats
+}
+
+func (c *dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dbMaxOpenDesc
+	ch <- dbOpenDesc
+	ch <- dbInUseDesc
+	ch <- dbIdleDesc
+	ch <- dbWaitCountDesc
+	ch <- dbWaitDurationDesc
+	ch <- dbMaxIdleDesc
+	ch <- dbMaxIdleTimeDesc
+	ch <- dbMaxLifetimeDesc
+}
+
+func (c *dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.stats()
+	ch <- prometheus.MustNewConstMetric(dbMaxOpenDesc, prometheus.GaugeValue, float64(s.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(dbOpenDesc, prometheus.GaugeValue, float64(s.OpenConnections))
+	ch <- prometheus.MustNewConstMetric(dbInUseDesc, prometheus.GaugeValue, float64(s.InUse))
+	ch <- prometheus.MustNewConstMetric(dbIdleDesc, prometheus.GaugeValue, float64(s.Idle))
+	ch <- prometheus.MustNewConstMetric(dbWaitCountDesc, prometheus.CounterValue, float64(s.WaitCount))
+	ch <- prometheus.MustNewConstMetric(dbWaitDurationDesc, prometheus.CounterValue, s.WaitDuration.Seconds())
+	ch <- prometheus.MustNewConstMetric(dbMaxIdleDesc, prometheus.CounterValue, float64(s.MaxIdleClosed))
+	ch <- prometheus.MustNewConstMetric(dbMaxIdleTimeDesc, prometheus.CounterValue, float64(s.MaxIdleTimeClosed))
+	ch <- prometheus.MustNewConstMetric(dbMaxLifetimeDesc, prometheus.CounterValue, float64(s.MaxLifetimeClosed))
+}
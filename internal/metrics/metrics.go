@@ -0,0 +1,120 @@
+// Package metrics collects the Prometheus metrics of the application: HTTP
+// requests, database queries and connection pool, and open websockets.
+//
+// The methods of a nil *Metrics do nothing, so that metrics are optional
+// wherever they are recorded.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes the names of the metrics of the application
+const namespace = "app"
+
+// Metrics holds the collectors of the application in their own registry
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests         *prometheus.CounterVec
+	httpRequestDuration  *prometheus.HistogramVec
+	dbQueryDuration      *prometheus.HistogramVec
+	websocketConnections prometheus.Gauge
+}
+
+// New returns metrics registered with a new registry, along with the
+// metrics of the Go runtime and the process
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests handled, by method, route template and status code.",
+		}, []string{"method", "route", "status"}),
+		httpRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time taken to handle HTTP requests, by method, route template and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		dbQueryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "db_query_duration_seconds",
+			Help:      "Time taken by database operations, by store method.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"query"}),
+		websocketConnections: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "websocket_connections",
+			Help:      "Open websocket connections.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests,
+		m.httpRequestDuration,
+		m.dbQueryDuration,
+		m.websocketConnections,
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// ObserveRequest records an HTTP request to the route template route
+func (m *Metrics) ObserveRequest(method, route string, status int, d time.Duration) {
+	if m == nil {
+		return
+	}
+	code := strconv.Itoa(status)
+	m.httpRequests.WithLabelValues(method, route, code).Inc()
+	m.httpRequestDuration.WithLabelValues(method, route, code).Observe(d.Seconds())
+}
+
+// ObserveQuery records a database operation, named after the store method
+// making it
+func (m *Metrics) ObserveQuery(query string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.dbQueryDuration.WithLabelValues(query).Observe(d.Seconds())
+}
+
+// WebsocketOpened counts a websocket connection as open until
+// WebsocketClosed is called
+func (m *Metrics) WebsocketOpened() {
+	if m == nil {
+		return
+	}
+	m.websocketConnections.Inc()
+}
+
+// WebsocketClosed counts a websocket connection as closed
+func (m *Metrics) WebsocketClosed() {
+	if m == nil {
+		return
+	}
+	m.websocketConnections.Dec()
+}
+
+// RegisterDBStats exports the connection pool statistics returned by stats
+// as gauges and counters
+func (m *Metrics) RegisterDBStats(stats func() sql.DBStats) {
+	if m == nil {
+		return
+	}
+	m.registry.MustRegister(&dbStatsCollector{stats: stats})
+}
@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"database/sql"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(w.Body)
+	return string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	m := New()
+	m.ObserveRequest("GET", "/api/v1/users/:id", 200, 30*time.Millisecond)
+	m.ObserveRequest("GET", "/api/v1/users/:id", 200, 10*time.Millisecond)
+	m.ObserveQuery("GetUserByID", 2*time.Millisecond)
+	m.WebsocketOpened()
+	m.WebsocketOpened()
+	m.WebsocketClosed()
+	m.RegisterDBStats(func() sql.DBStats {
+		return sql.DBStats{MaxOpenConnections: 50, OpenConnections: 3, InUse: 1, Idle: 2, WaitCount: 7}
+	})
+
+	body := scrape(t, m)
+	for _, want := range []string{
+		`app_http_requests_total{method="GET",route="/api/v1/users/:id",status="200"} 2`,
+		`app_http_request_duration_seconds_count{method="GET",route="/api/v1/users/:id",status="200"} 2`,
+		`app_db_query_duration_seconds_count{query="GetUserByID"} 1`,
+		`app_websocket_connections 1`,
+		`app_db_pool_max_open_connections 50`,
+		`app_db_pool_open_connections 3`,
+		`app_db_pool_in_use_connections 1`,
+		`app_db_pool_idle_connections 2`,
+		`app_db_pool_wait_count_total 7`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in:\n%s", want, body)
+		}
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	m.ObserveRequest("GET", "/", 200, time.Millisecond)
+	m.ObserveQuery("GetUserByID", time.Millisecond)
+	m.WebsocketOpened()
+	m.WebsocketClosed()
+	m.RegisterDBStats(func() sql.DBStats { return sql.DBStats{} })
+}
@@ -0,0 +1,205 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// componentPrefix is the prefix of references to component schemas
+const componentPrefix = "#/components/schemas/"
+
+// Schema is the subset of JSON Schema (draft 2020-12) used to describe
+// request and response bodies
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 Type               `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+}
+
+// Type lists the JSON types a value may have. A single type is written
+// as a string.
+type Type []string
+
+func (t Type) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+// Ref returns a schema referring to the named component schema
+func Ref(name string) *Schema {
+	return &Schema{Ref: componentPrefix + name}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Generator derives schemas from Go types using their json tags and the
+// binding rules gin validates them with. Named struct types become
+// component schemas and are referred to by $ref.
+//
+// A field is required if its binding rules include "required". Structs
+// without any binding rules are taken to be responses, in which every
+// field without omitempty is required.
+type Generator struct {
+	schemas map[string]*Schema
+}
+
+// NewGenerator returns a generator without any component schemas
+func NewGenerator() *Generator {
+	return &Generator{schemas: map[string]*Schema{}}
+}
+
+// Schemas returns the component schemas generated so far by name
+func (g *Generator) Schemas() map[string]*Schema {
+	return g.schemas
+}
+
+// Schema returns the schema of the type of v
+func (g *Generator) Schema(v any) *Schema {
+	return g.schema(reflect.TypeOf(v))
+}
+
+func (g *Generator) schema(t reflect.Type) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t == timeType {
+		return &Schema{Type: Type{"string"}, Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: Type{"boolean"}}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: Type{"integer"}}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: Type{"number"}}
+	case reflect.String:
+		return &Schema{Type: Type{"string"}}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: Type{"string"}, Format: "byte"}
+		}
+		return &Schema{Type: Type{"array"}, Items: g.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: Type{"object"}, AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		name := componentName(t)
+		if _, ok := g.schemas[name]; !ok {
+			g.schemas[name] = nil // Placeholder for recursive types
+			g.schemas[name] = g.structSchema(t)
+		}
+		return Ref(name)
+	}
+
+	// Interfaces and anything else accept any value
+	return &Schema{}
+}
+
+// structSchema returns the object schema of struct type t
+func (g *Generator) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: Type{"object"}, Properties: map[string]*Schema{}}
+
+	request := false
+	for i := range t.NumField() {
+		if _, ok := t.Field(i).Tag.Lookup("binding"); ok {
+			request = true
+			break
+		}
+	}
+
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		prop := g.schema(f.Type)
+		rules := strings.Split(f.Tag.Get("binding"), ",")
+		if prop.Ref == "" {
+			applyRules(prop, rules)
+		}
+		s.Properties[name] = prop
+
+		omitempty := strings.Contains(","+opts+",", ",omitempty,")
+		if slices.Contains(rules, "required") || (!request && !omitempty) {
+			s.Required = append(s.Required, name)
+		}
+	}
+	return s
+}
+
+// applyRules adds the constraints of gin binding rules to s
+func applyRules(s *Schema, rules []string) {
+	for _, rule := range rules {
+		name, param, _ := strings.Cut(rule, "=")
+		switch name {
+		case "email":
+			s.Format = "email"
+		case "oneof":
+			for _, v := range strings.Fields(param) {
+				s.Enum = append(s.Enum, v)
+			}
+		case "min", "max":
+			n, err := strconv.Atoi(param)
+			if err != nil {
+				continue
+			}
+			switch {
+			case s.Type.has("string") && name == "min":
+				s.MinLength = &n
+			case s.Type.has("string"):
+				s.MaxLength = &n
+			case (s.Type.has("integer") || s.Type.has("number")) && name == "min":
+				f := float64(n)
+				s.Minimum = &f
+			case s.Type.has("integer") || s.Type.has("number"):
+				f := float64(n)
+				s.Maximum = &f
+			}
+		}
+	}
+}
+
+// componentName returns the name of the component schema for named type
+// t, which is its Go name starting with an upper-case letter
+func componentName(t reflect.Type) string {
+	r, size := utf8.DecodeRuneInString(t.Name())
+	return string(unicode.ToUpper(r)) + t.Name()[size:]
+}
+
+func (t Type) has(name string) bool {
+	return slices.Contains(t, name)
+}
@@ -0,0 +1,214 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ValidationError describes why a value does not match a schema
+type ValidationError struct {
+	Path    string // Location of the value, e.g. "user.email"; empty for the value itself
+	Rule    string // Keyword that failed, e.g. "required" or "type"
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + " " + e.Message
+}
+
+// DecodeJSON decodes data into a value Validate accepts. Numbers are kept
+// as json.Number so integers can be told apart from other numbers.
+func DecodeJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected data after the JSON value")
+	}
+	return v, nil
+}
+
+// Validate reports every way in which v, as returned by DecodeJSON, does
+// not match s. References are resolved against the document's components.
+func (d *Document) Validate(s *Schema, v any) []ValidationError {
+	var errs []ValidationError
+	d.validate(s, v, "", &errs)
+	return errs
+}
+
+// ValidateParameter validates the raw value of parameter p, converting it
+// to a number first if the parameter's schema expects one
+func (d *Document) ValidateParameter(p *Parameter, raw string) []ValidationError {
+	var v any = raw
+	if s := d.Resolve(p.Schema); s != nil && (s.Type.has("integer") || s.Type.has("number")) {
+		if _, err := strconv.ParseFloat(raw, 64); err == nil {
+			v = json.Number(raw)
+		}
+	}
+
+	var errs []ValidationError
+	d.validate(p.Schema, v, p.Name, &errs)
+	return errs
+}
+
+func (d *Document) validate(s *Schema, v any, path string, errs *[]ValidationError) {
+	fail := func(rule, format string, args ...any) {
+		*errs = append(*errs, ValidationError{Path: path, Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.Ref != "" {
+		resolved := d.Resolve(s)
+		if resolved == nil {
+			fail("$ref", "refers to unknown schema %s", s.Ref)
+			return
+		}
+		s = resolved
+	}
+
+	if len(s.Type) > 0 && !slices.ContainsFunc(s.Type, func(t string) bool { return hasType(v, t) }) {
+		fail("type", "must be %s", typeNames(s.Type))
+		return
+	}
+
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(e any) bool { return jsonEqual(e, v) }) {
+		fail("enum", "must be one of %s", enumNames(s.Enum))
+	}
+
+	switch v := v.(type) {
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.MinLength != nil && n < *s.MinLength {
+			fail("min", "must be at least %d characters long", *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			fail("max", "must be at most %d characters long", *s.MaxLength)
+		}
+		switch s.Format {
+		case "email":
+			if addr, err := mail.ParseAddress(v); err != nil || addr.Address != v {
+				fail("email", "must be a valid email address")
+			}
+		case "date-time":
+			if _, err := time.Parse(time.RFC3339, v); err != nil {
+				fail("date-time", "must be an RFC 3339 timestamp")
+			}
+		}
+
+	case json.Number:
+		f, _ := v.Float64()
+		if s.Minimum != nil && f < *s.Minimum {
+			fail("min", "must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			fail("max", "must be at most %v", *s.Maximum)
+		}
+
+	case []any:
+		if s.Items != nil {
+			for i, item := range v {
+				d.validate(s.Items, item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				*errs = append(*errs, ValidationError{Path: join(path, name), Rule: "required", Message: "is required"})
+			}
+		}
+
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if prop, ok := s.Properties[name]; ok {
+				d.validate(prop, v[name], join(path, name), errs)
+			} else if s.AdditionalProperties != nil {
+				d.validate(s.AdditionalProperties, v[name], join(path, name), errs)
+			}
+		}
+	}
+}
+
+// hasType reports whether v is of the JSON type t
+func hasType(v any, t string) bool {
+	switch v := v.(type) {
+	case nil:
+		return t == "null"
+	case bool:
+		return t == "boolean"
+	case string:
+		return t == "string"
+	case json.Number:
+		if t == "integer" {
+			_, err := strconv.ParseInt(v.String(), 10, 64)
+			return err == nil
+		}
+		return t == "number"
+	case []any:
+		return t == "array"
+	case map[string]any:
+		return t == "object"
+	}
+	return false
+}
+
+// typeNames describes types for a validation message, e.g. "a string or null"
+func typeNames(types Type) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		switch t {
+		case "null":
+			names[i] = "null"
+		case "array", "integer", "object":
+			names[i] = "an " + t
+		default:
+			names[i] = "a " + t
+		}
+	}
+	return strings.Join(names, " or ")
+}
+
+// enumNames lists the allowed values for a validation message
+func enumNames(values []any) string {
+	names := make([]string, len(values))
+	for i, v := range values {
+		b, _ := json.Marshal(v)
+		names[i] = string(b)
+	}
+	return strings.Join(names, ", ")
+}
+
+// jsonEqual reports whether a and b encode to the same JSON
+func jsonEqual(a, b any) bool {
+	x, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	y, err := json.Marshal(b)
+	return err == nil && bytes.Equal(x, y)
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
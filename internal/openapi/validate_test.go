@@ -0,0 +1,83 @@
+package openapi
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+type address struct {
+	City string `json:"city"`
+}
+
+type person struct {
+	Name    string    `json:"name" binding:"required,max=5"`
+	Email   string    `json:"email" binding:"omitempty,email"`
+	Age     int       `json:"age" binding:"min=18"`
+	Role    string    `json:"role" binding:"oneof=admin user"`
+	Born    time.Time `json:"born"`
+	Address *address  `json:"address,omitempty"`
+	Tags    []string  `json:"tags"`
+	Secret  string    `json:"-"`
+}
+
+func TestValidate(t *testing.T) {
+	doc := New("test", "1")
+	gen := NewGenerator()
+	schema := gen.Schema(person{})
+	doc.Components.Schemas = gen.Schemas()
+
+	if got := doc.Resolve(schema).Required; !slices.Equal(got, []string{"name"}) {
+		t.Fatalf("expected only name to be required, got %v", got)
+	}
+	if got := doc.Components.Schemas["Address"].Required; !slices.Equal(got, []string{"city"}) {
+		t.Fatalf("expected response fields without omitempty to be required, got %v", got)
+	}
+
+	tests := []struct {
+		name  string
+		json  string
+		paths []string
+	}{
+		{"valid", `{"name":"ann","email":"ann@example.com","age":20,"role":"admin","born":"2000-01-01T00:00:00Z","address":{"city":"x"},"tags":["a"]}`, nil},
+		{"missing required", `{}`, []string{"name"}},
+		{"too long", `{"name":"annabel"}`, []string{"name"}},
+		{"bad formats", `{"name":"ann","email":"nope","born":"yesterday"}`, []string{"born", "email"}},
+		{"below minimum", `{"name":"ann","age":17}`, []string{"age"}},
+		{"not an integer", `{"name":"ann","age":18.5}`, []string{"age"}},
+		{"not in enum", `{"name":"ann","role":"root"}`, []string{"role"}},
+		{"nested", `{"name":"ann","address":{},"tags":["a",1]}`, []string{"address.city", "tags[1]"}},
+		{"wrong type", `[]`, []string{""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := DecodeJSON([]byte(tt.json))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var paths []string
+			for _, e := range doc.Validate(schema, v) {
+				paths = append(paths, e.Path)
+			}
+			if !slices.Equal(paths, tt.paths) {
+				t.Fatalf("expected errors at %v, got %v", tt.paths, paths)
+			}
+		})
+	}
+}
+
+func TestValidateParameter(t *testing.T) {
+	doc := New("test", "1")
+	p := &Parameter{Name: "id", In: "path", Schema: &Schema{Type: Type{"integer"}}}
+
+	if errs := doc.ValidateParameter(p, "42"); len(errs) != 0 {
+		t.Fatalf("expected 42 to be valid, got %v", errs)
+	}
+	for _, raw := range []string{"abc", "4.2"} {
+		if errs := doc.ValidateParameter(p, raw); len(errs) != 1 || errs[0].Rule != "type" {
+			t.Fatalf("expected %q to fail the type check, got %v", raw, errs)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"golang-backend/internal/database"
+)
+
+// RoleRequest represents the request body for creating a role
+type RoleRequest struct {
+	Name        string   `json:"name" binding:"required,max=50"`
+	Description string   `json:"description" binding:"max=255"`
+	Permissions []string `json:"permissions"`
+}
+
+// ListRolesHandler handles listing all roles
+func (s *Server) ListRolesHandler(c *gin.Context) {
+	roles, err := s.db.ListRoles(c.Request.Context())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"roles": roles,
+	})
+}
+
+// GetRoleHandler handles getting a role by name
+func (s *Server) GetRoleHandler(c *gin.Context) {
+	role, err := s.db.GetRole(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"role": role,
+	})
+}
+
+// CreateRoleHandler handles creating a role with an initial set of permissions
+func (s *Server) CreateRoleHandler(c *gin.Context) {
+	var req RoleRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	// Check the permissions first, so that an unknown one does not leave
+	// the role behind without the others
+	known, err := s.db.ListPermissions(c.Request.Context())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	for _, p := range req.Permissions {
+		if !slices.ContainsFunc(known, func(k *mysql.Permission) bool { return strings.EqualFold(k.Name, p) }) {
+			respondError(c, mysql.ErrPermissionNotFound)
+			return
+		}
+	}
+
+	_, err = s.db.CreateRole(c.Request.Context(), req.Name, req.Description)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	for _, p := range req.Permissions {
+		if err := s.db.GrantPermission(c.Request.Context(), req.Name, p); err != nil {
+			respondError(c, err)
+			return
+		}
+	}
+
+	role, err := s.db.GetRole(c.Request.Context(), req.Name)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Role created successfully",
+		"role":    role,
+	})
+}
+
+// DeleteRoleHandler handles deleting a role
+func (s *Server) DeleteRoleHandler(c *gin.Context) {
+	name := c.Param("name")
+	if strings.EqualFold(name, mysql.RoleAdmin) {
+		respondError(c, newProblem(http.StatusConflict, CodeRoleProtected, "The admin role cannot be deleted"))
+		return
+	}
+
+	if err := s.db.DeleteRole(c.Request.Context(), name); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Role deleted successfully",
+	})
+}
+
+// ListPermissionsHandler handles listing all permissions
+func (s *Server) ListPermissionsHandler(c *gin.Context) {
+	permissions, err := s.db.ListPermissions(c.Request.Context())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"permissions": permissions,
+	})
+}
+
+// GrantPermissionHandler handles granting a permission to a role
+func (s *Server) GrantPermissionHandler(c *gin.Context) {
+	if err := s.db.GrantPermission(c.Request.Context(), c.Param("name"), c.Param("permission")); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Permission granted successfully",
+	})
+}
+
+// RevokePermissionHandler handles revoking a permission from a role
+func (s *Server) RevokePermissionHandler(c *gin.Context) {
+	if err := s.db.RevokePermission(c.Request.Context(), c.Param("name"), c.Param("permission")); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Permission revoked successfully",
+	})
+}
+
+// GetUserRolesHandler handles listing the roles assigned to a user
+func (s *Server) GetUserRolesHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, newProblem(http.StatusBadRequest, CodeInvalidRequest, "Invalid user ID"))
+		return
+	}
+
+	if _, err := s.db.GetUserByID(c.Request.Context(), id); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	roles, err := s.db.GetUserRoles(c.Request.Context(), id)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"roles": roles,
+	})
+}
+
+// AssignRoleHandler handles assigning a role to a user
+func (s *Server) AssignRoleHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, newProblem(http.StatusBadRequest, CodeInvalidRequest, "Invalid user ID"))
+		return
+	}
+
+	if err := s.db.AssignRole(c.Request.Context(), id, c.Param("role")); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Role assigned successfully",
+	})
+}
+
+// UnassignRoleHandler handles removing a role from a user
+func (s *Server) UnassignRoleHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, newProblem(http.StatusBadRequest, CodeInvalidRequest, "Invalid user ID"))
+		return
+	}
+
+	if err := s.db.UnassignRole(c.Request.Context(), id, c.Param("role")); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Role unassigned successfully",
+	})
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"golang-backend/internal/database"
+)
+
+func TestCreateRoleHandler(t *testing.T) {
+	api := newUserAPI(t)
+
+	w := api.do("POST", "/api/v1/admin/roles", api.adminToken, `{"name": "auditor", "permissions": ["users:read", "roles:read"]}`)
+	expect(t, w, http.StatusCreated, "")
+	role, err := api.store.GetRole(context.Background(), "auditor")
+	if err != nil || len(role.Permissions) != 2 {
+		t.Fatalf("expected the role with its permissions, got %+v, %v", role, err)
+	}
+
+	// An unknown permission leaves no role behind, so the request can be
+	// fixed and retried
+	body := `{"name": "viewer", "permissions": ["users:read", "users:fly"]}`
+	expect(t, api.do("POST", "/api/v1/admin/roles", api.adminToken, body), http.StatusNotFound, CodePermissionNotFound)
+	if _, err := api.store.GetRole(context.Background(), "viewer"); !errors.Is(err, mysql.ErrRoleNotFound) {
+		t.Fatalf("expected no role to be created, got %v", err)
+	}
+	expect(t, api.do("POST", "/api/v1/admin/roles", api.adminToken, `{"name": "viewer", "permissions": ["users:read"]}`), http.StatusCreated, "")
+
+	expect(t, api.do("POST", "/api/v1/admin/roles", api.adminToken, `{"name": "viewer"}`), http.StatusConflict, CodeRoleExists)
+	expect(t, api.do("POST", "/api/v1/admin/roles", api.userToken, `{"name": "other"}`), http.StatusForbidden, CodeForbidden)
+}
+
+func TestDeleteRoleHandler(t *testing.T) {
+	api := newUserAPI(t)
+
+	// Role names are case-insensitive, so no spelling of admin gets through
+	for _, name := range []string{"admin", "Admin", "ADMIN"} {
+		expect(t, api.do("DELETE", "/api/v1/admin/roles/"+name, api.adminToken, ""), http.StatusConflict, CodeRoleProtected)
+	}
+	if _, err := api.store.GetRole(context.Background(), mysql.RoleAdmin); err != nil {
+		t.Fatalf("expected the admin role to be kept, got %v", err)
+	}
+
+	expect(t, api.do("DELETE", "/api/v1/admin/roles/Support", api.userToken, ""), http.StatusForbidden, CodeForbidden)
+	expect(t, api.do("DELETE", "/api/v1/admin/roles/Support", api.adminToken, ""), http.StatusOK, "")
+	expect(t, api.do("DELETE", "/api/v1/admin/roles/support", api.adminToken, ""), http.StatusNotFound, CodeRoleNotFound)
+}
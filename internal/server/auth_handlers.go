@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"golang-backend/internal/auth"
+	"golang-backend/internal/database"
+)
+
+// LoginRequest represents the request body for logging in
+type LoginRequest struct {
+	Login    string `json:"login" binding:"required"` // Username or email
+	Password string `json:"password" binding:"required"`
+}
+
+// RefreshRequest represents the request body for refreshing or revoking tokens
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// findLogin returns the user with login as email or username, or nil if
+// there is none. Usernames may contain "@" too, so logins that look like
+// an email are looked up both ways, the email taking precedence; both
+// queries always run so that their number does not reveal which exists.
+func (s *Server) findLogin(ctx context.Context, login string) (*mysql.User, error) {
+	user, err := s.db.GetUserByUsername(ctx, login)
+	if errors.Is(err, mysql.ErrUserNotFound) {
+		user = nil
+	} else if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(login, "@") {
+		return user, nil
+	}
+
+	byEmail, err := s.db.GetUserByEmail(ctx, login)
+	if errors.Is(err, mysql.ErrUserNotFound) {
+		return user, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return byEmail, nil
+}
+
+// LoginHandler authenticates a user and issues an access token
+func (s *Server) LoginHandler(c *gin.Context) {
+	var req LoginRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	user, err := s.findLogin(c.Request.Context(), req.Login)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	// Verify the password of unknown logins too, against a dummy hash, so
+	// that response times do not reveal which logins exist
+	err = s.db.VerifyPassword(c.Request.Context(), user, req.Password)
+	if err != nil && !errors.Is(err, mysql.ErrInvalidPassword) {
+		respondError(c, err)
+		return
+	}
+
+	// Don't reveal whether the user or the password was wrong
+	if err != nil {
+		respondError(c, mysql.ErrInvalidPassword)
+		return
+	}
+
+	// Every login starts a new refresh token family
+	familyID, err := auth.NewTokenFamily()
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	refreshToken, refreshHash, err := auth.NewRefreshToken()
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	refreshExpiresAt := s.now().Add(s.tokens.RefreshTTL())
+	_, err = s.db.CreateRefreshToken(c.Request.Context(), user.ID, familyID, refreshHash, refreshExpiresAt)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	s.respondWithTokens(c, user, refreshToken, refreshExpiresAt)
+}
+
+// RefreshHandler exchanges a refresh token for a new access and refresh token.
+// The presented refresh token is invalidated; presenting it again revokes
+// every token issued from the same login.
+func (s *Server) RefreshHandler(c *gin.Context) {
+	var req RefreshRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	refreshToken, refreshHash, err := auth.NewRefreshToken()
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	now := s.now()
+	refreshExpiresAt := now.Add(s.tokens.RefreshTTL())
+	rotated, err := s.db.RotateRefreshToken(c.Request.Context(), auth.HashRefreshToken(req.RefreshToken), refreshHash, now, refreshExpiresAt)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	user, err := s.db.GetUserByID(c.Request.Context(), rotated.UserID)
+	if errors.Is(err, mysql.ErrUserNotFound) {
+		// The user was deleted after the token was issued
+		respondError(c, mysql.ErrRefreshTokenNotFound)
+		return
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	s.respondWithTokens(c, user, refreshToken, refreshExpiresAt)
+}
+
+// LogoutHandler revokes the refresh token family of the presented token
+func (s *Server) LogoutHandler(c *gin.Context) {
+	var req RefreshRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	token, err := s.db.GetRefreshToken(c.Request.Context(), auth.HashRefreshToken(req.RefreshToken))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	if err := s.db.RevokeRefreshTokenFamily(c.Request.Context(), token.FamilyID, s.now()); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Logged out successfully",
+	})
+}
+
+// respondWithTokens issues an access token for user and writes it together
+// with the given refresh token
+func (s *Server) respondWithTokens(c *gin.Context, user *mysql.User, refreshToken string, refreshExpiresAt time.Time) {
+	roles, err := s.db.GetUserRoles(c.Request.Context(), user.ID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	accessToken, expiresAt, err := s.tokens.IssueAccessToken(user.ID, user.Username, roles)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"access_token":       accessToken,
+		"token_type":         "Bearer",
+		"expires_in":         int(s.tokens.AccessTTL().Seconds()),
+		"expires_at":         expiresAt,
+		"refresh_token":      refreshToken,
+		"refresh_expires_at": refreshExpiresAt,
+		"user":               user,
+	})
+}
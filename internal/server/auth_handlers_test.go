@@ -0,0 +1,94 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"golang-backend/internal/auth"
+	"golang-backend/internal/database"
+)
+
+// countingHasher is a password hasher counting the passwords it verifies
+type countingHasher struct {
+	auth.BcryptHasher
+	verified atomic.Int32
+}
+
+func (h *countingHasher) Verify(encoded, password string) error {
+	h.verified.Add(1)
+	return h.BcryptHasher.Verify(encoded, password)
+}
+
+func TestLoginHandler(t *testing.T) {
+	hasher := &countingHasher{BcryptHasher: auth.BcryptHasher{Cost: bcrypt.MinCost}}
+	store := mysql.NewMemory(hasher)
+	s, err := New(WithStore(store), WithConfig(testConfig()))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	api := &userAPI{t: t, store: store, handler: s.HTTPServer().Handler}
+	api.createUser(s, "alice")
+	api.createUser(s, "bob@home")
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		code   string
+	}{
+		{"username", `{"login": "alice", "password": "password123"}`, http.StatusOK, ""},
+		{"email", `{"login": "alice@example.com", "password": "password123"}`, http.StatusOK, ""},
+		{"wrong password", `{"login": "alice", "password": "wrong"}`, http.StatusUnauthorized, CodeInvalidCredentials},
+		{"unknown username", `{"login": "bob", "password": "password123"}`, http.StatusUnauthorized, CodeInvalidCredentials},
+		{"unknown email", `{"login": "carol@example.com", "password": "password123"}`, http.StatusUnauthorized, CodeInvalidCredentials},
+		{"username with @", `{"login": "bob@home", "password": "password123"}`, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := hasher.verified.Load()
+			expect(t, api.do("POST", "/api/v1/auth/login", "", tt.body), tt.status, tt.code)
+
+			// Unknown logins are checked against a hash too, so that they
+			// cannot be told apart by how long they take
+			if got := hasher.verified.Load() - before; got != 1 {
+				t.Errorf("expected one password verification, got %d", got)
+			}
+		})
+	}
+}
+
+func TestRefreshHandlerClock(t *testing.T) {
+	now := time.Now()
+	store := mysql.NewMemory(&auth.BcryptHasher{Cost: bcrypt.MinCost})
+	s, err := New(WithStore(store), WithConfig(testConfig()), WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	api := &userAPI{t: t, store: store, handler: s.HTTPServer().Handler}
+	api.createUser(s, "alice")
+
+	refresh := func(w *httptest.ResponseRecorder) string {
+		t.Helper()
+		var tokens struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &tokens); err != nil || tokens.RefreshToken == "" {
+			t.Fatalf("expected a refresh token, got %s", w.Body)
+		}
+		return `{"refresh_token": "` + tokens.RefreshToken + `"}`
+	}
+	w := api.do("POST", "/api/v1/auth/login", "", `{"login": "alice", "password": "password123"}`)
+	expect(t, w, http.StatusOK, "")
+	w = api.do("POST", "/api/v1/auth/refresh", "", refresh(w))
+	expect(t, w, http.StatusOK, "")
+
+	// Refresh tokens expire by the server's clock, not the store's
+	now = now.Add(testConfig().Auth.RefreshTTL + time.Minute)
+	expect(t, api.do("POST", "/api/v1/auth/refresh", "", refresh(w)), http.StatusUnauthorized, CodeInvalidRefreshToken)
+}
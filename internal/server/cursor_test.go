@@ -0,0 +1,50 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCursorCodec(t *testing.T) {
+	codec, err := newCursorCodec("secret")
+	if err != nil {
+		t.Fatalf("failed to create cursor codec: %v", err)
+	}
+
+	want := userCursor{Direction: "next", Sort: "-created_at", Value: "2024-01-01 00:00:00", ID: 42}
+	token, err := codec.encode(want)
+	if err != nil {
+		t.Fatalf("failed to encode cursor: %v", err)
+	}
+
+	var got userCursor
+	if err := codec.decode(token, &got); err != nil {
+		t.Fatalf("failed to decode cursor: %v", err)
+	}
+
+	if got != want {
+		t.Fatalf("expected cursor %+v, got %+v", want, got)
+	}
+
+	// Changing the payload must invalidate the signature
+	payload, sig, _ := strings.Cut(token, ".")
+	forged, err := codec.encode(userCursor{Direction: "next", ID: 1})
+	if err != nil {
+		t.Fatalf("failed to encode cursor: %v", err)
+	}
+	forgedPayload, _, _ := strings.Cut(forged, ".")
+
+	for _, bad := range []string{"", "garbage", payload, forgedPayload + "." + sig} {
+		if err := codec.decode(bad, &got); err != errInvalidCursor {
+			t.Errorf("expected errInvalidCursor for %q, got %v", bad, err)
+		}
+	}
+
+	other, err := newCursorCodec("other")
+	if err != nil {
+		t.Fatalf("failed to create cursor codec: %v", err)
+	}
+	if err := other.decode(token, &got); err != errInvalidCursor {
+		t.Errorf("expected errInvalidCursor for cursor signed with another key, got %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"golang-backend/internal/database"
+)
+
+// userETag returns the entity tag of a user's representation
+func userETag(user *mysql.User) string {
+	return `"` + strconv.Itoa(user.Version) + `"`
+}
+
+// etagMatches reports whether etag is listed in the If-Match or
+// If-None-Match header value header. Weak tags compare equal to their
+// strong counterparts when weak is true.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch enforces the If-Match precondition of a request modifying
+// user. It writes 428 Precondition Required when the header is missing and
+// 412 Precondition Failed when it does not match, and reports whether the
+// request may proceed.
+func checkIfMatch(c *gin.Context, user *mysql.User) bool {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		respondError(c, newProblem(http.StatusPreconditionRequired, CodePreconditionRequired, "If-Match header is required"))
+		return false
+	}
+
+	if !etagMatches(header, userETag(user), false) {
+		respondError(c, mysql.ErrVersionConflict)
+		return false
+	}
+	return true
+}
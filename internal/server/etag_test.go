@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"golang-backend/internal/database"
+)
+
+// versionedStore is a mysql.Service holding a single user
+type versionedStore struct {
+	mysql.Service
+	user    *mysql.User
+	patched mysql.UserChanges // Changes passed to the last PatchUser call
+}
+
+func (s *versionedStore) GetUserByID(ctx context.Context, id int) (*mysql.User, error) {
+	if id != s.user.ID {
+		return nil, mysql.ErrUserNotFound
+	}
+	u := *s.user
+	return &u, nil
+}
+
+func (s *versionedStore) UpdateUser(ctx context.Context, id, version int, username, email string) (*mysql.User, error) {
+	if version != 0 && version != s.user.Version {
+		return nil, mysql.ErrVersionConflict
+	}
+	s.user.Username, s.user.Email = username, email
+	s.user.Version++
+	return s.GetUserByID(ctx, id)
+}
+
+func TestETags(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		header   string
+		value    string
+		want     int
+		wantETag string
+	}{
+		{"get", "GET", "", "", http.StatusOK, `"3"`},
+		{"get unchanged", "GET", "If-None-Match", `"3"`, http.StatusNotModified, `"3"`},
+		{"get unchanged weak", "GET", "If-None-Match", `W/"3"`, http.StatusNotModified, `"3"`},
+		{"get changed", "GET", "If-None-Match", `"2"`, http.StatusOK, `"3"`},
+		{"put without If-Match", "PUT", "", "", http.StatusPreconditionRequired, ""},
+		{"put stale", "PUT", "If-Match", `"2"`, http.StatusPreconditionFailed, ""},
+		{"put weak", "PUT", "If-Match", `W/"3"`, http.StatusPreconditionFailed, ""},
+		{"put current", "PUT", "If-Match", `"2", "3"`, http.StatusOK, `"4"`},
+		{"put any", "PUT", "If-Match", "*", http.StatusOK, `"4"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{db: &versionedStore{user: &mysql.User{ID: 1, Username: "user", Email: "user@example.com", Version: 3}}}
+			r := gin.New()
+			r.GET("/users/:id", s.GetUserHandler)
+			r.PUT("/users/:id", s.UpdateUserHandler)
+
+			var body *strings.Reader
+			if tt.method == "PUT" {
+				body = strings.NewReader(`{"username":"renamed","email":"renamed@example.com"}`)
+			} else {
+				body = strings.NewReader("")
+			}
+			req := httptest.NewRequest(tt.method, "/users/1", body)
+			req.Header.Set("Content-Type", "application/json")
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("expected status %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+			if got := w.Header().Get("ETag"); got != tt.wantETag {
+				t.Fatalf("expected ETag %q, got %q", tt.wantETag, got)
+			}
+		})
+	}
+}
+
+func TestUpdateUserRace(t *testing.T) {
+	// The user changes between the If-Match check and the update
+	store := &racingStore{versionedStore: versionedStore{user: &mysql.User{ID: 1, Version: 3}}}
+	s := &Server{db: store}
+	r := gin.New()
+	r.PUT("/users/:id", s.UpdateUserHandler)
+
+	req := httptest.NewRequest("PUT", "/users/1", strings.NewReader(`{"username":"renamed","email":"renamed@example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", `"3"`)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusPreconditionFailed, w.Code, w.Body.String())
+	}
+}
+
+// racingStore bumps the user's version right after every read
+type racingStore struct {
+	versionedStore
+}
+
+func (s *racingStore) GetUserByID(ctx context.Context, id int) (*mysql.User, error) {
+	u, err := s.versionedStore.GetUserByID(ctx, id)
+	s.user.Version++
+	return u, err
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang-backend/internal/database"
+)
+
+// userEventType is the kind of change a userEvent describes
+type userEventType int
+
+const (
+	userCreated userEventType = iota + 1
+	userUpdated
+	userDeleted
+	userRestored
+)
+
+// userEvent is a change made to a user
+type userEvent struct {
+	Type userEventType
+	User *mysql.User // After the change; only the ID is set for deletions
+	Time time.Time
+}
+
+// userEvents fans out user changes to the watchers subscribed to them.
+// Only changes made through this process are seen.
+type userEvents struct {
+	mu       sync.Mutex
+	watchers map[chan userEvent]struct{}
+}
+
+func newUserEvents() *userEvents {
+	return &userEvents{watchers: map[chan userEvent]struct{}{}}
+}
+
+// watch subscribes to user changes. The returned channel is closed when
+// the watcher falls more than buffer events behind, or once stop is
+// called.
+func (e *userEvents) watch(buffer int) (<-chan userEvent, func()) {
+	ch := make(chan userEvent, buffer)
+
+	e.mu.Lock()
+	e.watchers[ch] = struct{}{}
+	e.mu.Unlock()
+
+	stop := func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if _, ok := e.watchers[ch]; ok {
+			delete(e.watchers, ch)
+			close(ch)
+		}
+	}
+	return ch, stop
+}
+
+// publish sends ev to every watcher without blocking. Watchers whose
+// buffer is full are dropped rather than holding up the change.
+func (e *userEvents) publish(ev userEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for ch := range e.watchers {
+		select {
+		case ch <- ev:
+		default:
+			delete(e.watchers, ch)
+			close(ch)
+		}
+	}
+}
+
+// watchedStore is a mysql.Service publishing the user changes it makes
+// successfully to events
+type watchedStore struct {
+	mysql.Service
+	events *userEvents
+}
+
+func (s *watchedStore) publish(typ userEventType, user *mysql.User) {
+	s.events.publish(userEvent{Type: typ, User: user, Time: time.Now()})
+}
+
+func (s *watchedStore) CreateUser(ctx context.Context, username, email, password string) (*mysql.User, error) {
+	user, err := s.Service.CreateUser(ctx, username, email, password)
+	if err == nil {
+		s.publish(userCreated, user)
+	}
+	return user, err
+}
+
+func (s *watchedStore) UpdateUser(ctx context.Context, id, version int, username, email string) (*mysql.User, error) {
+	user, err := s.Service.UpdateUser(ctx, id, version, username, email)
+	if err == nil {
+		s.publish(userUpdated, user)
+	}
+	return user, err
+}
+
+func (s *watchedStore) PatchUser(ctx context.Context, id, version int, changes mysql.UserChanges) (*mysql.User, error) {
+	user, err := s.Service.PatchUser(ctx, id, version, changes)
+	if err == nil {
+		s.publish(userUpdated, user)
+	}
+	return user, err
+}
+
+func (s *watchedStore) UpdateUserPassword(ctx context.Context, id, version int, password string) error {
+	err := s.Service.UpdateUserPassword(ctx, id, version, password)
+	if err == nil {
+		// The password is not part of the user, but its version changed
+		if user, err := s.Service.GetUserByID(ctx, id); err == nil {
+			s.publish(userUpdated, user)
+		}
+	}
+	return err
+}
+
+func (s *watchedStore) DeleteUser(ctx context.Context, id int) error {
+	err := s.Service.DeleteUser(ctx, id)
+	if err == nil {
+		s.publish(userDeleted, &mysql.User{ID: id})
+	}
+	return err
+}
+
+func (s *watchedStore) RestoreUser(ctx context.Context, id int) (*mysql.User, error) {
+	user, err := s.Service.RestoreUser(ctx, id)
+	if err == nil {
+		s.publish(userRestored, user)
+	}
+	return user, err
+}
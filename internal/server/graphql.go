@@ -0,0 +1,513 @@
+package server
+
+import (
+	"context"
+	_ "embed"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graph-gophers/graphql-go"
+
+	"golang-backend/internal/database"
+	"golang-backend/internal/logging"
+)
+
+//go:embed schema.graphql
+var graphQLSchema string
+
+//go:embed graphiql.html
+var graphiQLPage []byte
+
+// loaderWait is how long a batch loader collects keys before fetching them
+const loaderWait = 2 * time.Millisecond
+
+// newGraphQLSchema parses the GraphQL schema with its resolvers. Up to a
+// page of fields resolve in parallel, so that their lookups are batched
+// together.
+func (s *Server) newGraphQLSchema() *graphql.Schema {
+	return graphql.MustParseSchema(graphQLSchema, &graphQLResolver{s: s},
+		graphql.UseStringDescriptions(),
+		graphql.MaxParallelism(maxPageLimit),
+	)
+}
+
+// graphQLRequest is the body of a GraphQL request
+type graphQLRequest struct {
+	Query         string         `json:"query" binding:"required"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+// graphQLHandler executes GraphQL requests against schema. The principal
+// stored by optionalAuth, if any, is who the request runs as. Resolver
+// errors are reported with the problem the HTTP API would respond with.
+func (s *Server) graphQLHandler(schema *graphql.Schema) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req graphQLRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+
+		ctx := c.Request.Context()
+		ctx = context.WithValue(ctx, loadersContextKey{}, s.newGraphQLLoaders(ctx))
+		if p := principalFrom(c); p != nil {
+			ctx = context.WithValue(ctx, principalContextKey{}, p)
+		}
+
+		resp := schema.Exec(ctx, req.Query, req.OperationName, req.Variables)
+		for _, qe := range resp.Errors {
+			if qe.ResolverError == nil {
+				continue
+			}
+			p := problemFor(qe.ResolverError)
+			if p.Status >= http.StatusInternalServerError {
+				logging.FromContext(ctx).ErrorContext(ctx, "request failed", "method", c.Request.Method, "path", c.Request.URL.Path, "error", qe.ResolverError)
+			}
+			qe.Message = p.Detail
+			qe.Extensions = map[string]any{"code": p.Code, "status": p.Status}
+			if len(p.Errors) > 0 {
+				qe.Extensions["errors"] = p.Errors
+			}
+		}
+
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// graphiQLHandler serves an in-browser GraphQL IDE for /graphql
+func (s *Server) graphiQLHandler(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", graphiQLPage)
+}
+
+// loadersContextKey is the context key holding the *graphQLLoaders of a
+// GraphQL request
+type loadersContextKey struct{}
+
+// graphQLLoaders batch the store lookups of a single GraphQL request
+type graphQLLoaders struct {
+	users *batchLoader[int, *mysql.User]
+	roles *batchLoader[int, []string]
+}
+
+func (s *Server) newGraphQLLoaders(ctx context.Context) *graphQLLoaders {
+	return &graphQLLoaders{
+		users: newBatchLoader(ctx, func(ctx context.Context, ids []int) (map[int]*mysql.User, error) {
+			users, err := s.db.GetUsersByIDs(ctx, ids)
+			if err != nil {
+				return nil, err
+			}
+			byID := make(map[int]*mysql.User, len(users))
+			for _, user := range users {
+				byID[user.ID] = user
+			}
+			return byID, nil
+		}),
+		roles: newBatchLoader(ctx, s.db.GetRolesByUserIDs),
+	}
+}
+
+func loadersFrom(ctx context.Context) *graphQLLoaders {
+	return ctx.Value(loadersContextKey{}).(*graphQLLoaders)
+}
+
+// batchLoader coalesces the lookups made within loaderWait of each other
+// into a single call of fetch, and caches their results. It lives for a
+// single request, so its cache is never stale for long.
+type batchLoader[K comparable, V any] struct {
+	ctx   context.Context
+	fetch func(ctx context.Context, keys []K) (map[K]V, error)
+
+	mu      sync.Mutex
+	results map[K]*loadResult[V]
+	pending []K // Keys waiting for the next fetch
+}
+
+// loadResult is the outcome of loading a key, available once done is closed
+type loadResult[V any] struct {
+	done  chan struct{}
+	value V
+	found bool
+	err   error
+}
+
+func newBatchLoader[K comparable, V any](ctx context.Context, fetch func(context.Context, []K) (map[K]V, error)) *batchLoader[K, V] {
+	return &batchLoader[K, V]{ctx: ctx, fetch: fetch, results: map[K]*loadResult[V]{}}
+}
+
+// load returns the value of key, reporting whether fetch found it
+func (l *batchLoader[K, V]) load(ctx context.Context, key K) (V, bool, error) {
+	l.mu.Lock()
+	r, ok := l.results[key]
+	if !ok {
+		r = &loadResult[V]{done: make(chan struct{})}
+		l.results[key] = r
+		if len(l.pending) == 0 {
+			time.AfterFunc(loaderWait, l.dispatch)
+		}
+		l.pending = append(l.pending, key)
+	}
+	l.mu.Unlock()
+
+	select {
+	case <-r.done:
+		return r.value, r.found, r.err
+	case <-ctx.Done():
+		var zero V
+		return zero, false, ctx.Err()
+	}
+}
+
+// dispatch fetches the pending keys and completes their results
+func (l *batchLoader[K, V]) dispatch() {
+	l.mu.Lock()
+	keys := l.pending
+	l.pending = nil
+	results := make([]*loadResult[V], len(keys))
+	for i, key := range keys {
+		results[i] = l.results[key]
+	}
+	l.mu.Unlock()
+
+	values, err := l.fetch(l.ctx, keys)
+	for i, key := range keys {
+		r := results[i]
+		r.value, r.found = values[key]
+		r.err = err
+		close(r.done)
+	}
+}
+
+// graphQLResolver resolves the Query and Mutation types
+type graphQLResolver struct {
+	s *Server
+}
+
+// authorize mirrors requireSelfOr for the principal of ctx, see
+// Server.authorize
+func (r *graphQLResolver) authorize(ctx context.Context, permission string, selfID int) error {
+	p, _ := ctx.Value(principalContextKey{}).(*Principal)
+	return r.s.authorize(ctx, p, permission, selfID)
+}
+
+// parseID parses a user ID argument
+func parseID(id graphql.ID) (int, error) {
+	n, err := strconv.Atoi(string(id))
+	if err != nil {
+		return 0, newProblem(http.StatusBadRequest, CodeInvalidRequest, "Invalid user ID")
+	}
+	return n, nil
+}
+
+func (r *graphQLResolver) Me(ctx context.Context) (*userResolver, error) {
+	p, _ := ctx.Value(principalContextKey{}).(*Principal)
+	if p == nil {
+		return nil, newProblem(http.StatusUnauthorized, CodeUnauthenticated, "Authentication required")
+	}
+
+	user, found, err := loadersFrom(ctx).users.load(ctx, p.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, mysql.ErrUserNotFound
+	}
+	return &userResolver{r: r, user: user}, nil
+}
+
+func (r *graphQLResolver) User(ctx context.Context, args struct{ ID graphql.ID }) (*userResolver, error) {
+	id, err := parseID(args.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.authorize(ctx, mysql.PermUsersRead, id); err != nil {
+		return nil, err
+	}
+
+	user, found, err := loadersFrom(ctx).users.load(ctx, id)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &userResolver{r: r, user: user}, nil
+}
+
+// userFilterInput is the UserFilter input type
+type userFilterInput struct {
+	Query          *string
+	UsernamePrefix *string
+	EmailPrefix    *string
+	CreatedAfter   *graphql.Time
+	CreatedBefore  *graphql.Time
+	UpdatedAfter   *graphql.Time
+	UpdatedBefore  *graphql.Time
+}
+
+func (r *graphQLResolver) Users(ctx context.Context, args struct {
+	First  *int32
+	After  *string
+	Sort   *string
+	Filter *userFilterInput
+}) (*userConnectionResolver, error) {
+	if err := r.authorize(ctx, mysql.PermUsersList, 0); err != nil {
+		return nil, err
+	}
+
+	// Validate through the same query parameters as the HTTP listing
+	query := url.Values{}
+	set := func(key string, value *string) {
+		if value != nil && *value != "" {
+			query.Set(key, *value)
+		}
+	}
+	if args.First != nil {
+		query.Set("limit", strconv.Itoa(int(*args.First)))
+	}
+	set("cursor", args.After)
+	set("sort", args.Sort)
+	if f := args.Filter; f != nil {
+		set("q", f.Query)
+		set("username", f.UsernamePrefix)
+		set("email", f.EmailPrefix)
+		times := map[string]*graphql.Time{
+			"created_after":  f.CreatedAfter,
+			"created_before": f.CreatedBefore,
+			"updated_after":  f.UpdatedAfter,
+			"updated_before": f.UpdatedBefore,
+		}
+		for key, t := range times {
+			if t != nil {
+				query.Set(key, t.Format(time.RFC3339Nano))
+			}
+		}
+	}
+
+	q, err := r.s.parseListUsersQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	page, err := r.s.db.ListUsers(ctx, q.params)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &userConnectionResolver{page: page}
+	for _, user := range page.Users {
+		cursor, err := r.s.pageCursor("next", q.sort, user)
+		if err != nil {
+			return nil, err
+		}
+		conn.edges = append(conn.edges, &userEdgeResolver{cursor: cursor, node: &userResolver{r: r, user: user}})
+	}
+	return conn, nil
+}
+
+func (r *graphQLResolver) CreateUser(ctx context.Context, args struct{ Input UserRequest }) (*userResolver, error) {
+	if err := validateRequest(&args.Input); err != nil {
+		return nil, err
+	}
+
+	user, err := r.s.db.CreateUser(ctx, args.Input.Username, args.Input.Email, args.Input.Password)
+	if err != nil {
+		return nil, err
+	}
+	return &userResolver{r: r, user: user}, nil
+}
+
+func (r *graphQLResolver) UpdateUser(ctx context.Context, args struct {
+	ID      graphql.ID
+	Input   UpdateUserRequest
+	Version int32
+}) (*userResolver, error) {
+	id, err := parseID(args.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.authorize(ctx, mysql.PermUsersUpdate, id); err != nil {
+		return nil, err
+	}
+	if err := validateRequest(&args.Input); err != nil {
+		return nil, err
+	}
+	if args.Version == 0 {
+		return nil, newProblem(http.StatusPreconditionRequired, CodePreconditionRequired, "version is required")
+	}
+
+	user, err := r.s.db.UpdateUser(ctx, id, int(args.Version), args.Input.Username, args.Input.Email)
+	if err != nil {
+		return nil, err
+	}
+	return &userResolver{r: r, user: user}, nil
+}
+
+func (r *graphQLResolver) UpdatePassword(ctx context.Context, args struct {
+	ID       graphql.ID
+	Password string
+	Version  int32
+}) (*userResolver, error) {
+	id, err := parseID(args.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.authorize(ctx, mysql.PermUsersUpdate, id); err != nil {
+		return nil, err
+	}
+	req := UpdatePasswordRequest{Password: args.Password}
+	if err := validateRequest(&req); err != nil {
+		return nil, err
+	}
+	if args.Version == 0 {
+		return nil, newProblem(http.StatusPreconditionRequired, CodePreconditionRequired, "version is required")
+	}
+
+	if err := r.s.db.UpdateUserPassword(ctx, id, int(args.Version), req.Password); err != nil {
+		return nil, err
+	}
+	// Not through the loader, which may hold the user as it was before
+	user, err := r.s.db.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &userResolver{r: r, user: user}, nil
+}
+
+func (r *graphQLResolver) DeleteUser(ctx context.Context, args struct{ ID graphql.ID }) (graphql.ID, error) {
+	id, err := parseID(args.ID)
+	if err != nil {
+		return "", err
+	}
+	if err := r.authorize(ctx, mysql.PermUsersDelete, id); err != nil {
+		return "", err
+	}
+
+	if err := r.s.db.DeleteUser(ctx, id); err != nil {
+		return "", err
+	}
+	return args.ID, nil
+}
+
+func (r *graphQLResolver) RestoreUser(ctx context.Context, args struct{ ID graphql.ID }) (*userResolver, error) {
+	id, err := parseID(args.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.authorize(ctx, mysql.PermUsersRestore, 0); err != nil {
+		return nil, err
+	}
+
+	user, err := r.s.db.RestoreUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &userResolver{r: r, user: user}, nil
+}
+
+// userResolver resolves the User type
+type userResolver struct {
+	r    *graphQLResolver
+	user *mysql.User
+}
+
+func (u *userResolver) ID() graphql.ID {
+	return graphql.ID(strconv.Itoa(u.user.ID))
+}
+
+func (u *userResolver) Username() string {
+	return u.user.Username
+}
+
+func (u *userResolver) Email() string {
+	return u.user.Email
+}
+
+func (u *userResolver) CreatedAt() graphql.Time {
+	return graphql.Time{Time: u.user.CreatedAt}
+}
+
+func (u *userResolver) UpdatedAt() graphql.Time {
+	return graphql.Time{Time: u.user.UpdatedAt}
+}
+
+func (u *userResolver) Version() int32 {
+	return int32(u.user.Version)
+}
+
+func (u *userResolver) Roles(ctx context.Context) ([]string, error) {
+	if err := u.r.authorize(ctx, mysql.PermRolesRead, u.user.ID); err != nil {
+		return nil, err
+	}
+
+	roles, _, err := loadersFrom(ctx).roles.load(ctx, u.user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if roles == nil {
+		roles = []string{}
+	}
+	return roles, nil
+}
+
+// userConnectionResolver resolves the UserConnection type
+type userConnectionResolver struct {
+	page  *mysql.UserPage
+	edges []*userEdgeResolver
+}
+
+func (c *userConnectionResolver) Edges() []*userEdgeResolver {
+	return c.edges
+}
+
+func (c *userConnectionResolver) Nodes() []*userResolver {
+	nodes := make([]*userResolver, len(c.edges))
+	for i, e := range c.edges {
+		nodes[i] = e.node
+	}
+	return nodes
+}
+
+func (c *userConnectionResolver) PageInfo() *pageInfoResolver {
+	return &pageInfoResolver{conn: c}
+}
+
+// userEdgeResolver resolves the UserEdge type
+type userEdgeResolver struct {
+	cursor string
+	node   *userResolver
+}
+
+func (e *userEdgeResolver) Cursor() string {
+	return e.cursor
+}
+
+func (e *userEdgeResolver) Node() *userResolver {
+	return e.node
+}
+
+// pageInfoResolver resolves the PageInfo type of a connection
+type pageInfoResolver struct {
+	conn *userConnectionResolver
+}
+
+func (p *pageInfoResolver) HasNextPage() bool {
+	return p.conn.page.HasNext
+}
+
+func (p *pageInfoResolver) HasPreviousPage() bool {
+	return p.conn.page.HasPrev
+}
+
+func (p *pageInfoResolver) StartCursor() *string {
+	if len(p.conn.edges) == 0 {
+		return nil
+	}
+	return &p.conn.edges[0].cursor
+}
+
+func (p *pageInfoResolver) EndCursor() *string {
+	if len(p.conn.edges) == 0 {
+		return nil
+	}
+	return &p.conn.edges[len(p.conn.edges)-1].cursor
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang-backend/internal/database"
+)
+
+// graphQLStore is a mysql.Service listing three users, counting the batch
+// lookups made and granting permissions to user 2 only
+type graphQLStore struct {
+	mysql.Service
+
+	mu         sync.Mutex
+	userCalls  int
+	rolesCalls int
+}
+
+func (s *graphQLStore) users() []*mysql.User {
+	return []*mysql.User{
+		{ID: 1, Username: "alice", Email: "alice@example.com", Version: 1},
+		{ID: 2, Username: "bob", Email: "bob@example.com", Version: 1},
+		{ID: 3, Username: "carol", Email: "carol@example.com", Version: 1},
+	}
+}
+
+func (s *graphQLStore) ListUsers(ctx context.Context, params mysql.ListUsersParams) (*mysql.UserPage, error) {
+	return &mysql.UserPage{Users: s.users(), HasNext: true}, nil
+}
+
+func (s *graphQLStore) GetUsersByIDs(ctx context.Context, ids []int) ([]*mysql.User, error) {
+	s.mu.Lock()
+	s.userCalls++
+	s.mu.Unlock()
+
+	var users []*mysql.User
+	for _, u := range s.users() {
+		for _, id := range ids {
+			if u.ID == id {
+				users = append(users, u)
+			}
+		}
+	}
+	return users, nil
+}
+
+func (s *graphQLStore) GetRolesByUserIDs(ctx context.Context, userIDs []int) (map[int][]string, error) {
+	s.mu.Lock()
+	s.rolesCalls++
+	s.mu.Unlock()
+
+	roles := map[int][]string{}
+	for _, id := range userIDs {
+		roles[id] = []string{}
+	}
+	roles[2] = []string{"admin"}
+	return roles, nil
+}
+
+func (s *graphQLStore) GetUserPermissions(ctx context.Context, userID int) ([]string, error) {
+	if userID == 2 {
+		return []string{mysql.PermUsersRead, mysql.PermUsersList, mysql.PermRolesRead}, nil
+	}
+	return nil, nil
+}
+
+func TestGraphQL(t *testing.T) {
+	cursors, err := newCursorCodec("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &graphQLStore{}
+	s := &Server{db: store, tokens: newTestTokenManager(t), cursors: cursors}
+	h := s.RegisterRoutes()
+
+	type response struct {
+		Data   map[string]json.RawMessage
+		Errors []struct {
+			Message    string
+			Extensions struct {
+				Code   string
+				Status int
+				Errors []FieldError
+			}
+		}
+	}
+	exec := func(userID int, query string) response {
+		t.Helper()
+		body, _ := json.Marshal(map[string]string{"query": query})
+		req := httptest.NewRequest("POST", "/graphql", strings.NewReader(string(body)))
+		req.Header.Set("Content-Type", "application/json")
+		if userID != 0 {
+			token, _, err := s.tokens.IssueAccessToken(userID, "user", nil)
+			if err != nil {
+				t.Fatalf("failed to issue token: %v", err)
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+		}
+		var resp response
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid response %s: %v", w.Body, err)
+		}
+		return resp
+	}
+
+	// Nested lookups of a page are batched into one store call
+	resp := exec(2, `{ users(first: 3) { nodes { id roles } pageInfo { hasNextPage endCursor } } }`)
+	if len(resp.Errors) > 0 {
+		t.Fatalf("unexpected errors %+v", resp.Errors)
+	}
+	var users struct {
+		Nodes []struct {
+			ID    string
+			Roles []string
+		}
+		PageInfo struct {
+			HasNextPage bool
+			EndCursor   string
+		}
+	}
+	if err := json.Unmarshal(resp.Data["users"], &users); err != nil {
+		t.Fatal(err)
+	}
+	if len(users.Nodes) != 3 || users.Nodes[1].Roles[0] != "admin" || !users.PageInfo.HasNextPage || users.PageInfo.EndCursor == "" {
+		t.Fatalf("unexpected users %+v", users)
+	}
+	if store.rolesCalls != 1 {
+		t.Fatalf("expected roles to be loaded in 1 call, got %d", store.rolesCalls)
+	}
+
+	resp = exec(2, `{ a: user(id: "1") { username } b: user(id: "3") { username } c: user(id: "9") { username } }`)
+	if len(resp.Errors) > 0 || string(resp.Data["a"]) != `{"username":"alice"}` || string(resp.Data["c"]) != "null" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	if store.userCalls != 1 {
+		t.Fatalf("expected users to be loaded in 1 call, got %d", store.userCalls)
+	}
+
+	// Errors carry the same codes as the HTTP API
+	tests := []struct {
+		name   string
+		userID int
+		query  string
+		code   string
+	}{
+		{"anonymous", 0, `{ users { nodes { id } } }`, CodeUnauthenticated},
+		{"missing permission", 3, `{ users { nodes { id } } }`, CodeForbidden},
+		{"own roles", 3, `{ user(id: "3") { roles } }`, ""},
+		{"invalid id", 2, `{ user(id: "x") { id } }`, CodeInvalidRequest},
+		{"invalid cursor", 2, `{ users(after: "nope") { nodes { id } } }`, CodeInvalidCursor},
+		{"validation", 0, `mutation { createUser(input: {username: "new", email: "bad", password: "x"}) { id } }`, CodeValidationFailed},
+		{"missing version", 1, `mutation { updateUser(id: "1", input: {username: "a", email: "a@example.com"}, version: 0) { id } }`, CodePreconditionRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := exec(tt.userID, tt.query)
+			if tt.code == "" {
+				if len(resp.Errors) > 0 {
+					t.Fatalf("unexpected errors %+v", resp.Errors)
+				}
+				return
+			}
+			if len(resp.Errors) != 1 || resp.Errors[0].Extensions.Code != tt.code {
+				t.Fatalf("expected a %s error, got %+v", tt.code, resp.Errors)
+			}
+		})
+	}
+
+	// Invalid tokens are rejected like on the HTTP API
+	req := httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query":"{ me { id } }"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer invalid")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+}
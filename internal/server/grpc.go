@@ -0,0 +1,452 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin/binding"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"golang-backend/internal/database"
+	"golang-backend/internal/logging"
+	"golang-backend/internal/userpb"
+)
+
+// grpcErrorDomain is the domain of the ErrorInfo detail of gRPC errors
+const grpcErrorDomain = "golang-backend"
+
+// watchBuffer is how many events a Watch stream may fall behind before
+// it is ended
+const watchBuffer = 64
+
+// GRPCServer serves the gRPC API, with health checking and reflection,
+// on its own address
+type GRPCServer struct {
+	*grpc.Server
+	Addr string
+
+	health *health.Server
+}
+
+// ListenAndServe listens on Addr and serves gRPC requests until the server
+// is stopped
+func (g *GRPCServer) ListenAndServe() error {
+	lis, err := net.Listen("tcp", g.Addr)
+	if err != nil {
+		return err
+	}
+	return g.Serve(lis)
+}
+
+// Shutdown reports the server as not serving and stops it gracefully.
+// Calls still running when ctx is done, such as Watch streams, are
+// cancelled.
+func (g *GRPCServer) Shutdown(ctx context.Context) {
+	g.health.Shutdown()
+
+	done := make(chan struct{})
+	go func() {
+		g.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		g.Stop()
+	}
+}
+
+// newGRPCServer returns the gRPC server for s listening on addr
+func (s *Server) newGRPCServer(addr string) *GRPCServer {
+	srv := grpc.NewServer(
+		// Trace calls like HTTP requests, see trace
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(s.unaryInterceptor),
+		grpc.ChainStreamInterceptor(s.streamInterceptor),
+	)
+	userpb.RegisterUserServiceServer(srv, &userService{s: s})
+
+	hs := health.NewServer()
+	hs.SetServingStatus(userpb.UserService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(srv, hs)
+	reflection.Register(srv)
+
+	return &GRPCServer{Server: srv, Addr: addr, health: hs}
+}
+
+// grpcAccess is who may call a UserService method, mirroring the
+// middleware of the matching HTTP route
+type grpcAccess struct {
+	public     bool   // Anyone may call it, without a token
+	permission string // Required permission otherwise
+	self       bool   // Users may call it on themselves without the permission
+}
+
+// grpcMethods holds the access rules by full method name. Methods missing
+// from it are denied, so every method added to UserService needs an entry.
+var grpcMethods = map[string]grpcAccess{
+	userpb.UserService_CreateUser_FullMethodName:     {public: true},
+	userpb.UserService_GetUser_FullMethodName:        {permission: mysql.PermUsersRead, self: true},
+	userpb.UserService_ListUsers_FullMethodName:      {permission: mysql.PermUsersList},
+	userpb.UserService_UpdateUser_FullMethodName:     {permission: mysql.PermUsersUpdate, self: true},
+	userpb.UserService_UpdatePassword_FullMethodName: {permission: mysql.PermUsersUpdate, self: true},
+	userpb.UserService_DeleteUser_FullMethodName:     {permission: mysql.PermUsersDelete, self: true},
+	userpb.UserService_Watch_FullMethodName:          {permission: mysql.PermUsersList},
+}
+
+// grpcPublicServices are the full method name prefixes of the services
+// anyone may call without an entry in grpcMethods
+var grpcPublicServices = []string{
+	"/" + healthpb.Health_ServiceDesc.ServiceName + "/",
+	"/grpc.reflection.",
+}
+
+// principalContextKey is the context key holding the *Principal of a gRPC
+// call or GraphQL request
+type principalContextKey struct{}
+
+// authorizeGRPC enforces the access rules of method on a call with ctx
+// and, for unary calls, req. It returns ctx with the caller's principal.
+func (s *Server) authorizeGRPC(ctx context.Context, method string, req any) (context.Context, error) {
+	access, ok := grpcMethods[method]
+	if !ok {
+		for _, prefix := range grpcPublicServices {
+			if strings.HasPrefix(method, prefix) {
+				return ctx, nil
+			}
+		}
+		return nil, newProblem(http.StatusForbidden, CodeForbidden, "No access rules for "+method)
+	}
+	if access.public {
+		return ctx, nil
+	}
+
+	var header string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("authorization"); len(v) > 0 {
+			header = v[0]
+		}
+	}
+	token, ok := bearerToken(header)
+	if !ok {
+		return nil, newProblem(http.StatusUnauthorized, CodeUnauthenticated, "Authentication required")
+	}
+
+	p, err := s.authenticate(token)
+	if err != nil {
+		return nil, err
+	}
+	ctx = context.WithValue(ctx, principalContextKey{}, p)
+
+	selfID := 0
+	if r, ok := req.(interface{ GetId() int64 }); ok && access.self {
+		selfID = int(r.GetId())
+	}
+	if err := s.authorize(ctx, p, access.permission, selfID); err != nil {
+		return nil, err
+	}
+	return ctx, nil
+}
+
+// grpcRequestContext tags the context of a call like requestID does for
+// HTTP requests, taking the ID from the x-request-id metadata and sending
+// it back with setHeader
+func (s *Server) grpcRequestContext(ctx context.Context, setHeader func(metadata.MD) error) context.Context {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("x-request-id"); len(v) > 0 {
+			id = v[0]
+		}
+	}
+	ctx, id = s.requestContext(ctx, id)
+	_ = setHeader(metadata.Pairs("x-request-id", id))
+	return ctx
+}
+
+func (s *Server) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx = s.grpcRequestContext(ctx, func(md metadata.MD) error { return grpc.SetHeader(ctx, md) })
+	ctx, err := s.authorizeGRPC(ctx, info.FullMethod, req)
+	if err != nil {
+		return nil, grpcError(ctx, info.FullMethod, err)
+	}
+
+	resp, err := handler(ctx, req)
+	if err != nil {
+		return nil, grpcError(ctx, info.FullMethod, err)
+	}
+	return resp, nil
+}
+
+func (s *Server) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx := s.grpcRequestContext(ss.Context(), ss.SetHeader)
+	ctx, err := s.authorizeGRPC(ctx, info.FullMethod, nil)
+	if err != nil {
+		return grpcError(ctx, info.FullMethod, err)
+	}
+
+	if err := handler(srv, &contextStream{ServerStream: ss, ctx: ctx}); err != nil {
+		return grpcError(ctx, info.FullMethod, err)
+	}
+	return nil
+}
+
+// contextStream is a grpc.ServerStream with a replaced context
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// grpcCodes maps the HTTP status of problems to gRPC codes
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:           codes.InvalidArgument,
+	http.StatusUnauthorized:         codes.Unauthenticated,
+	http.StatusForbidden:            codes.PermissionDenied,
+	http.StatusNotFound:             codes.NotFound,
+	http.StatusConflict:             codes.AlreadyExists,
+	http.StatusPreconditionFailed:   codes.Aborted,
+	http.StatusUnsupportedMediaType: codes.InvalidArgument,
+	http.StatusUnprocessableEntity:  codes.FailedPrecondition,
+	http.StatusPreconditionRequired: codes.FailedPrecondition,
+}
+
+// grpcError converts err into the gRPC status matching the problem the
+// HTTP API responds with. The problem code is the reason of an ErrorInfo
+// detail and field errors become BadRequest field violations. Internal
+// errors are logged with the method they occurred in.
+func grpcError(ctx context.Context, method string, err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+
+	p := problemFor(err)
+	if p.Status >= http.StatusInternalServerError {
+		logging.FromContext(ctx).ErrorContext(ctx, "request failed", "method", method, "error", err)
+	}
+
+	code, ok := grpcCodes[p.Status]
+	if !ok {
+		code = codes.Internal
+	}
+
+	details := []protoadapt.MessageV1{&errdetails.ErrorInfo{Reason: p.Code, Domain: grpcErrorDomain}}
+	if len(p.Errors) > 0 {
+		br := &errdetails.BadRequest{}
+		for _, fe := range p.Errors {
+			br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       fe.Field,
+				Description: fe.Message,
+			})
+		}
+		details = append(details, br)
+	}
+
+	st := status.New(code, p.Detail)
+	if withDetails, err := st.WithDetails(details...); err == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
+
+// validateRequest validates obj by its binding rules like bindJSON does
+func validateRequest(obj any) error {
+	if err := binding.Validator.ValidateStruct(obj); err != nil {
+		return bindProblem(err)
+	}
+	return nil
+}
+
+// userService implements userpb.UserServiceServer on top of the server's
+// store. Errors are converted by the interceptors.
+type userService struct {
+	userpb.UnimplementedUserServiceServer
+	s *Server
+}
+
+func (u *userService) CreateUser(ctx context.Context, req *userpb.CreateUserRequest) (*userpb.User, error) {
+	r := UserRequest{Username: req.GetUsername(), Email: req.GetEmail(), Password: req.GetPassword()}
+	if err := validateRequest(&r); err != nil {
+		return nil, err
+	}
+
+	user, err := u.s.db.CreateUser(ctx, r.Username, r.Email, r.Password)
+	if err != nil {
+		return nil, err
+	}
+	return userToProto(user), nil
+}
+
+func (u *userService) GetUser(ctx context.Context, req *userpb.GetUserRequest) (*userpb.User, error) {
+	user, err := u.s.db.GetUserByID(ctx, int(req.GetId()))
+	if err != nil {
+		return nil, err
+	}
+	return userToProto(user), nil
+}
+
+func (u *userService) ListUsers(ctx context.Context, req *userpb.ListUsersRequest) (*userpb.ListUsersResponse, error) {
+	// Validate through the same query parameters as the HTTP listing
+	query := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	if req.GetPageSize() != 0 {
+		set("limit", strconv.Itoa(int(req.GetPageSize())))
+	}
+	set("cursor", req.GetPageToken())
+	set("sort", req.GetSort())
+	set("q", req.GetQuery())
+	set("username", req.GetUsernamePrefix())
+	set("email", req.GetEmailPrefix())
+	times := map[string]*timestamppb.Timestamp{
+		"created_after":  req.GetCreatedAfter(),
+		"created_before": req.GetCreatedBefore(),
+		"updated_after":  req.GetUpdatedAfter(),
+		"updated_before": req.GetUpdatedBefore(),
+	}
+	for key, ts := range times {
+		if ts != nil {
+			set(key, ts.AsTime().Format(time.RFC3339Nano))
+		}
+	}
+
+	q, err := u.s.parseListUsersQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := u.s.db.ListUsers(ctx, q.params)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &userpb.ListUsersResponse{}
+	for _, user := range page.Users {
+		resp.Users = append(resp.Users, userToProto(user))
+	}
+	if n := len(page.Users); n > 0 && page.HasNext {
+		resp.NextPageToken, err = u.s.pageCursor("next", q.sort, page.Users[n-1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+func (u *userService) UpdateUser(ctx context.Context, req *userpb.UpdateUserRequest) (*userpb.User, error) {
+	r := UpdateUserRequest{Username: req.GetUsername(), Email: req.GetEmail()}
+	if err := validateRequest(&r); err != nil {
+		return nil, err
+	}
+	if req.GetVersion() == 0 {
+		return nil, newProblem(http.StatusPreconditionRequired, CodePreconditionRequired, "version is required")
+	}
+
+	user, err := u.s.db.UpdateUser(ctx, int(req.GetId()), int(req.GetVersion()), r.Username, r.Email)
+	if err != nil {
+		return nil, err
+	}
+	return userToProto(user), nil
+}
+
+func (u *userService) UpdatePassword(ctx context.Context, req *userpb.UpdatePasswordRequest) (*emptypb.Empty, error) {
+	r := UpdatePasswordRequest{Password: req.GetPassword()}
+	if err := validateRequest(&r); err != nil {
+		return nil, err
+	}
+	if req.GetVersion() == 0 {
+		return nil, newProblem(http.StatusPreconditionRequired, CodePreconditionRequired, "version is required")
+	}
+
+	if err := u.s.db.UpdateUserPassword(ctx, int(req.GetId()), int(req.GetVersion()), r.Password); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (u *userService) DeleteUser(ctx context.Context, req *userpb.DeleteUserRequest) (*emptypb.Empty, error) {
+	if err := u.s.db.DeleteUser(ctx, int(req.GetId())); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (u *userService) Watch(req *userpb.WatchRequest, stream grpc.ServerStreamingServer[userpb.UserEvent]) error {
+	if u.s.events == nil {
+		return status.Error(codes.Unimplemented, "Watching users is not enabled")
+	}
+
+	events, stop := u.s.events.watch(watchBuffer)
+	defer stop()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case ev, ok := <-events:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "Watch fell behind, watch again and refetch the users")
+			}
+			if err := stream.Send(eventToProto(ev)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func userToProto(user *mysql.User) *userpb.User {
+	u := &userpb.User{
+		Id:       int64(user.ID),
+		Username: user.Username,
+		Email:    user.Email,
+		Version:  int64(user.Version),
+	}
+	if !user.CreatedAt.IsZero() {
+		u.CreateTime = timestamppb.New(user.CreatedAt)
+	}
+	if !user.UpdatedAt.IsZero() {
+		u.UpdateTime = timestamppb.New(user.UpdatedAt)
+	}
+	return u
+}
+
+var eventTypes = map[userEventType]userpb.UserEvent_Type{
+	userCreated:  userpb.UserEvent_TYPE_CREATED,
+	userUpdated:  userpb.UserEvent_TYPE_UPDATED,
+	userDeleted:  userpb.UserEvent_TYPE_DELETED,
+	userRestored: userpb.UserEvent_TYPE_RESTORED,
+}
+
+func eventToProto(ev userEvent) *userpb.UserEvent {
+	return &userpb.UserEvent{
+		Type: eventTypes[ev.Type],
+		User: userToProto(ev.User),
+		Time: timestamppb.New(ev.Time),
+	}
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"golang-backend/internal/database"
+	"golang-backend/internal/userpb"
+)
+
+// grpcStore is a mysql.Service holding a single user that can be created
+// and updated, granting permissions to user 2 only
+type grpcStore struct {
+	versionedStore
+}
+
+func (s *grpcStore) CreateUser(ctx context.Context, username, email, password string) (*mysql.User, error) {
+	if username == s.user.Username {
+		return nil, mysql.ErrDuplicateUsername
+	}
+	return &mysql.User{ID: 7, Username: username, Email: email, Version: 1}, nil
+}
+
+func (s *grpcStore) GetUserPermissions(ctx context.Context, userID int) ([]string, error) {
+	if userID == 2 {
+		return []string{mysql.PermUsersRead, mysql.PermUsersList}, nil
+	}
+	return nil, nil
+}
+
+// newTestGRPCClient serves s over an in-memory connection
+func newTestGRPCClient(t *testing.T, s *Server) *grpc.ClientConn {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	srv := s.newGRPCServer("")
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGRPCUserService(t *testing.T) {
+	events := newUserEvents()
+	store := &grpcStore{versionedStore{user: &mysql.User{ID: 1, Username: "user", Email: "user@example.com", Version: 3}}}
+	s := &Server{
+		db:     &watchedStore{Service: store, events: events},
+		tokens: newTestTokenManager(t),
+		events: events,
+	}
+	conn := newTestGRPCClient(t, s)
+	client := userpb.NewUserServiceClient(conn)
+
+	withToken := func(userID int) context.Context {
+		token, _, err := s.tokens.IssueAccessToken(userID, "user", nil)
+		if err != nil {
+			t.Fatalf("failed to issue token: %v", err)
+		}
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+	}
+	ctx := context.Background()
+
+	// Errors carry the same codes as the HTTP API
+	_, err := client.CreateUser(ctx, &userpb.CreateUserRequest{Username: "new", Email: "not-an-email", Password: "short"})
+	st := status.Convert(err)
+	if st.Code() != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got %v", err)
+	}
+	var reason string
+	var fields []string
+	for _, d := range st.Details() {
+		switch d := d.(type) {
+		case *errdetails.ErrorInfo:
+			reason = d.Reason
+		case *errdetails.BadRequest:
+			for _, v := range d.FieldViolations {
+				fields = append(fields, v.Field)
+			}
+		}
+	}
+	if reason != CodeValidationFailed || len(fields) != 2 || fields[0] != "email" || fields[1] != "password" {
+		t.Fatalf("unexpected error details %q %v", reason, fields)
+	}
+
+	if _, err := client.CreateUser(ctx, &userpb.CreateUserRequest{Username: "user", Email: "user@example.com", Password: "secret123"}); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected AlreadyExists, got %v", err)
+	}
+
+	// Access rules mirror the HTTP routes
+	if _, err := client.GetUser(ctx, &userpb.GetUserRequest{Id: 1}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated, got %v", err)
+	}
+	if _, err := client.GetUser(withToken(3), &userpb.GetUserRequest{Id: 1}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied, got %v", err)
+	}
+	user, err := client.GetUser(withToken(1), &userpb.GetUserRequest{Id: 1})
+	if err != nil || user.GetUsername() != "user" || user.GetVersion() != 3 {
+		t.Fatalf("unexpected user %v: %v", user, err)
+	}
+	if _, err := client.GetUser(withToken(2), &userpb.GetUserRequest{Id: 9}); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound, got %v", err)
+	}
+
+	// Updates need the current version
+	if _, err := client.UpdateUser(withToken(1), &userpb.UpdateUserRequest{Id: 1, Username: "renamed", Email: "renamed@example.com"}); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition without version, got %v", err)
+	}
+	if _, err := client.UpdateUser(withToken(1), &userpb.UpdateUserRequest{Id: 1, Username: "renamed", Email: "renamed@example.com", Version: 2}); status.Code(err) != codes.Aborted {
+		t.Fatalf("expected Aborted for a stale version, got %v", err)
+	}
+
+	// Watchers see changes made after they subscribed
+	watchCtx, cancel := context.WithTimeout(withToken(2), 5*time.Second)
+	defer cancel()
+	stream, err := client.Watch(watchCtx, &userpb.WatchRequest{})
+	if err != nil {
+		t.Fatalf("failed to watch: %v", err)
+	}
+	for !hasWatchers(events) {
+		time.Sleep(time.Millisecond)
+	}
+
+	user, err = client.UpdateUser(withToken(1), &userpb.UpdateUserRequest{Id: 1, Username: "renamed", Email: "renamed@example.com", Version: 3})
+	if err != nil || user.GetVersion() != 4 {
+		t.Fatalf("unexpected user %v: %v", user, err)
+	}
+
+	ev, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("failed to receive event: %v", err)
+	}
+	if ev.GetType() != userpb.UserEvent_TYPE_UPDATED || ev.GetUser().GetUsername() != "renamed" {
+		t.Fatalf("unexpected event %v", ev)
+	}
+
+	health, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: userpb.UserService_ServiceDesc.ServiceName})
+	if err != nil || health.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("unexpected health %v: %v", health, err)
+	}
+}
+
+func TestUserEventsDropSlowWatchers(t *testing.T) {
+	events := newUserEvents()
+	ch, stop := events.watch(1)
+	defer stop()
+
+	events.publish(userEvent{Type: userCreated, User: &mysql.User{ID: 1}})
+	events.publish(userEvent{Type: userCreated, User: &mysql.User{ID: 2}})
+
+	if ev := <-ch; ev.User.ID != 1 {
+		t.Fatalf("expected the first event, got %+v", ev)
+	}
+	if _, ok := <-ch; ok {
+		t.Fatal("expected a watcher that fell behind to be dropped")
+	}
+}
+
+func hasWatchers(e *userEvents) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.watchers) > 0
+}
+
+func TestGRPCAccessRules(t *testing.T) {
+	// Every method has access rules, as methods without are denied
+	desc := userpb.UserService_ServiceDesc
+	for _, m := range desc.Methods {
+		if _, ok := grpcMethods["/"+desc.ServiceName+"/"+m.MethodName]; !ok {
+			t.Errorf("no access rules for %s", m.MethodName)
+		}
+	}
+	for _, m := range desc.Streams {
+		if _, ok := grpcMethods["/"+desc.ServiceName+"/"+m.StreamName]; !ok {
+			t.Errorf("no access rules for %s", m.StreamName)
+		}
+	}
+
+	s := &Server{tokens: newTestTokenManager(t)}
+	ctx := context.Background()
+	tests := []struct {
+		method string
+		want   codes.Code
+	}{
+		{userpb.UserService_CreateUser_FullMethodName, codes.OK},
+		{userpb.UserService_GetUser_FullMethodName, codes.Unauthenticated},
+		{"/" + desc.ServiceName + "/DropUsers", codes.PermissionDenied},
+		{"/other.Service/Method", codes.PermissionDenied},
+		{healthpb.Health_Check_FullMethodName, codes.OK},
+		{"/grpc.reflection.v1.ServerReflection/ServerReflectionInfo", codes.OK},
+	}
+	for _, tt := range tests {
+		_, err := s.authorizeGRPC(ctx, tt.method, nil)
+		if err != nil {
+			err = grpcError(ctx, tt.method, err)
+		}
+		if got := status.Code(err); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.method, tt.want, err)
+		}
+	}
+}
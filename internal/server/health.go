@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"golang-backend/internal/database"
+	"golang-backend/internal/health"
+)
+
+// Timeouts and cache lifetimes of the health checks. Migrations only
+// change on deploys, so their check is cached for longer.
+const (
+	databaseCheckTimeout    = time.Second
+	databaseCheckCacheTTL   = time.Second
+	migrationsCheckTimeout  = 2 * time.Second
+	migrationsCheckCacheTTL = 30 * time.Second
+)
+
+// newHealthRegistry registers the checks of the probes: the database and
+// its migrations must be ready before traffic is sent to the server, and
+// the background workers must be alive
+func (s *Server) newHealthRegistry() *health.Registry {
+	r := health.NewRegistry()
+	r.Register(health.Check{
+		Name:     "database",
+		Func:     s.checkDatabase,
+		Timeout:  databaseCheckTimeout,
+		CacheTTL: databaseCheckCacheTTL,
+	}, health.Readiness, health.Startup)
+	r.Register(health.Check{
+		Name:     "migrations",
+		Func:     s.checkMigrations,
+		Timeout:  migrationsCheckTimeout,
+		CacheTTL: migrationsCheckCacheTTL,
+	}, health.Readiness, health.Startup)
+	r.Register(health.Check{
+		Name: "workers",
+		Func: s.workers.check,
+	}, health.Liveness)
+	return r
+}
+
+// checkDatabase pings the database, warning about the state of the
+// connection pool
+func (s *Server) checkDatabase(ctx context.Context) ([]string, error) {
+	if err := s.db.Ping(ctx); err != nil {
+		return nil, fmt.Errorf("database is unreachable: %w", err)
+	}
+	return mysql.PoolWarnings(s.db.Stats()), nil
+}
+
+// checkMigrations fails while migrations are pending
+func (s *Server) checkMigrations(ctx context.Context) ([]string, error) {
+	pending, err := s.db.PendingMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) > 0 {
+		names := make([]string, len(pending))
+		for i, m := range pending {
+			names[i] = fmt.Sprintf("%d_%s", m.Version, m.Name)
+		}
+		return nil, fmt.Errorf("%d pending migrations: %s", len(pending), strings.Join(names, ", "))
+	}
+	return nil, nil
+}
+
+func (s *Server) livezHandler(c *gin.Context) {
+	s.respondProbe(c, health.Liveness, isVerbose(c))
+}
+
+func (s *Server) readyzHandler(c *gin.Context) {
+	s.respondProbe(c, health.Readiness, isVerbose(c))
+}
+
+func (s *Server) startupzHandler(c *gin.Context) {
+	s.respondProbe(c, health.Startup, isVerbose(c))
+}
+
+// healthHandler reports readiness in verbose mode, for clients of the
+// endpoint that preceded the probes
+func (s *Server) healthHandler(c *gin.Context) {
+	s.respondProbe(c, health.Readiness, true)
+}
+
+// respondProbe runs probe and responds with its report, with 503 if it
+// failed. The results of the individual checks are only listed in verbose
+// mode.
+func (s *Server) respondProbe(c *gin.Context, probe health.Probe, verbose bool) {
+	report := &health.Report{Status: health.StatusOK}
+	if s.health != nil {
+		report = s.health.Run(c.Request.Context(), probe)
+	}
+
+	status := http.StatusOK
+	if !report.Healthy() {
+		status = http.StatusServiceUnavailable
+	}
+	if !verbose {
+		report = &health.Report{Status: report.Status}
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(status, report)
+}
+
+// isVerbose reports whether the verbose query parameter is set, as in
+// ?verbose or ?verbose=true
+func isVerbose(c *gin.Context) bool {
+	v, ok := c.GetQuery("verbose")
+	return ok && v != "false" && v != "0"
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang-backend/internal/database"
+	"golang-backend/internal/health"
+)
+
+// healthStore is a mysql.Service reporting a configurable state
+type healthStore struct {
+	mysql.Service
+	pingErr error
+	pending []mysql.MigrationStatus
+	stats   sql.DBStats
+}
+
+func (s *healthStore) Ping(ctx context.Context) error { return s.pingErr }
+func (s *healthStore) Stats() sql.DBStats             { return s.stats }
+func (s *healthStore) PendingMigrations(ctx context.Context) ([]mysql.MigrationStatus, error) {
+	return s.pending, nil
+}
+
+func TestProbes(t *testing.T) {
+	store := &healthStore{}
+	s := &Server{db: store, workers: newWorkers()}
+	s.health = s.newHealthRegistry()
+	h := s.RegisterRoutes()
+
+	probe := func(path string) (int, health.Report) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		var report health.Report
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+			t.Fatalf("GET %s: failed to decode report %q: %v", path, w.Body, err)
+		}
+		return w.Code, report
+	}
+
+	// Startup fails while migrations are pending, and readiness with it
+	store.pending = []mysql.MigrationStatus{{Version: 11, Name: "add_things"}}
+	if code, report := probe("/startupz"); code != http.StatusServiceUnavailable || report.Status != health.StatusFail || report.Checks != nil {
+		t.Fatalf("expected 503 without checks, got %d: %+v", code, report)
+	}
+	code, report := probe("/readyz?verbose")
+	if code != http.StatusServiceUnavailable || len(report.Checks) != 2 || !strings.Contains(report.Checks[1].Error, "11_add_things") {
+		t.Fatalf("expected the pending migration to be listed, got %d: %+v", code, report)
+	}
+
+	// Liveness does not depend on the database
+	store.pingErr = errors.New("connection refused")
+	if code, _ := probe("/livez"); code != http.StatusOK {
+		t.Fatalf("expected liveness to pass, got %d", code)
+	}
+
+	// A stopped worker fails liveness
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.workers.run(ctx, "test_worker", 1, func(context.Context) {})
+	if code, report := probe("/livez?verbose=true"); code != http.StatusServiceUnavailable || report.Checks[0].Error != "test_worker stopped" {
+		t.Fatalf("expected the stopped worker to fail liveness, got %d: %+v", code, report)
+	}
+
+	// /health reports readiness in verbose mode. Pool warnings do not make
+	// it fail.
+	s.health = s.newHealthRegistry()
+	store.pingErr, store.pending = nil, nil
+	store.stats = sql.DBStats{MaxOpenConnections: 50, OpenConnections: 50}
+	code, report = probe("/health")
+	if code != http.StatusOK || report.Status != health.StatusWarn || len(report.Checks[0].Warnings) != 1 {
+		t.Fatalf("expected 200 with a warning, got %d: %+v", code, report)
+	}
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"golang-backend/internal/database"
+	"golang-backend/internal/logging"
+)
+
+const (
+	// idempotencyWait is how long a request waits for an in-flight request
+	// with the same key before it is rejected with 409
+	idempotencyWait = 2 * time.Second
+	// idempotencyPoll is how often a waiting request checks on the
+	// in-flight one
+	idempotencyPoll = 50 * time.Millisecond
+	// idempotencyLockTimeout is how long a request may stay in flight
+	// before its key is considered abandoned and can be claimed again
+	idempotencyLockTimeout = time.Minute
+)
+
+// idempotencyKeyMaxLength is the longest Idempotency-Key accepted
+var idempotencyKeyMaxLength = 255
+
+// idempotencyReplayHeaders are the response headers stored with a key and
+// replayed with the response
+var idempotencyReplayHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// idempotent honors the Idempotency-Key header. The first request with a
+// key is handled and its response stored; retries with the same key and
+// request get that response replayed, marked with Idempotent-Replayed.
+// Reusing a key for a different request fails with 422, and retries made
+// while the first request is in flight wait for it for up to
+// idempotencyWait, then fail with 409. Keys are scoped to the principal,
+// so it must come after requireAuth on authenticated routes. Anonymous
+// clients have no principal to tell them apart, so their keys are scoped
+// to the route and shared by all of them; a response is only replayed to
+// a client sending the same request, passwords included.
+//
+// Server errors are not stored, so that the request can be retried.
+func (s *Server) idempotent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > idempotencyKeyMaxLength {
+			respondError(c, newProblem(http.StatusBadRequest, CodeInvalidRequest, "Idempotency-Key is too long"))
+			return
+		}
+
+		body, err := readBody(c)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		fingerprint := requestFingerprint(s.fingerprintKey, c.Request.Method, c.Request.URL.Path, body)
+
+		userID := 0
+		if p := principalFrom(c); p != nil {
+			userID = p.UserID
+		} else {
+			key = anonymousIdempotencyKey(c.Request.Method, c.Request.URL.Path, key)
+		}
+
+		ctx := c.Request.Context()
+		deadline := time.Now().Add(idempotencyWait)
+		for {
+			now := s.now()
+			existing, err := s.db.ClaimIdempotencyKey(ctx, userID, key, fingerprint, now, now.Add(s.idempotencyKeyTTL), now.Add(-idempotencyLockTimeout))
+			if err == nil {
+				break
+			}
+			if !errors.Is(err, mysql.ErrIdempotencyKeyExists) {
+				respondError(c, err)
+				return
+			}
+
+			switch {
+			case existing.Fingerprint != fingerprint:
+				respondError(c, newProblem(http.StatusUnprocessableEntity, CodeIdempotencyKeyReused, "Idempotency-Key was already used for a different request"))
+				return
+			case existing.StatusCode != 0:
+				replayResponse(c, existing)
+				return
+			case time.Now().After(deadline):
+				c.Header("Retry-After", "1")
+				respondError(c, newProblem(http.StatusConflict, CodeIdempotencyKeyInUse, "A request with this Idempotency-Key is still in progress"))
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				c.Abort()
+				return
+			case <-time.After(idempotencyPoll):
+			}
+		}
+
+		// Keep the key after the client went away, so that its retry is
+		// answered from the store
+		ctx = context.WithoutCancel(ctx)
+		completed := false
+		defer func() {
+			// Let retries through when the request failed or panicked
+			if !completed {
+				if err := s.db.ReleaseIdempotencyKey(ctx, userID, key); err != nil {
+					logging.FromContext(ctx).ErrorContext(ctx, "failed to release idempotency key", "error", err)
+				}
+			}
+		}()
+
+		w := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+
+		status := w.Status()
+		if status >= http.StatusInternalServerError {
+			return
+		}
+		header := map[string][]string{}
+		for _, name := range idempotencyReplayHeaders {
+			if values := w.Header().Values(name); len(values) > 0 {
+				header[name] = values
+			}
+		}
+		if err := s.db.CompleteIdempotencyKey(ctx, userID, key, status, header, w.body.Bytes()); err != nil {
+			logging.FromContext(ctx).ErrorContext(ctx, "failed to store idempotent response", "error", err)
+			return
+		}
+		completed = true
+	}
+}
+
+// requestFingerprint identifies a request by its method, path and body.
+// Bodies may hold passwords, so the fingerprint is an HMAC keyed with key
+// rather than a plain hash that could be guessed offline from the table.
+func requestFingerprint(key []byte, method, path string, body []byte) string {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(method + " " + path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// anonymousIdempotencyKey returns the key an anonymous request to method
+// and path is stored under, so that the same key can be used on different
+// routes
+func anonymousIdempotencyKey(method, path, key string) string {
+	h := sha256.Sum256([]byte(method + " " + path + "\n" + key))
+	return "anonymous:" + hex.EncodeToString(h[:])
+}
+
+// replayResponse responds with the response stored with record
+func replayResponse(c *gin.Context, record *mysql.IdempotencyKey) {
+	for name, values := range record.Header {
+		c.Writer.Header()[name] = values
+	}
+	c.Header("Idempotent-Replayed", "true")
+	c.Writer.WriteHeader(record.StatusCode)
+	_, _ = c.Writer.Write(record.Body)
+	c.Abort()
+}
+
+// recordingWriter is a gin.ResponseWriter keeping a copy of the body
+// written through it
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang-backend/internal/database"
+)
+
+// idempotencyStore is a mysql.Service keeping idempotency keys in memory.
+// CreateUser fails while fail is set and waits for release, if set.
+type idempotencyStore struct {
+	mysql.Service
+
+	mu      sync.Mutex
+	keys    map[string]*mysql.IdempotencyKey
+	created int
+	fail    bool
+	release chan struct{}
+}
+
+func (s *idempotencyStore) CreateUser(ctx context.Context, username, email, password string) (*mysql.User, error) {
+	if s.release != nil {
+		<-s.release
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail {
+		return nil, errors.New("database is down")
+	}
+	s.created++
+	return &mysql.User{ID: s.created, Username: username, Email: email, Version: 1}, nil
+}
+
+func (s *idempotencyStore) ClaimIdempotencyKey(ctx context.Context, userID int, key, fingerprint string, now, expiresAt, staleBefore time.Time) (*mysql.IdempotencyKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.keys[key]; ok {
+		copy := *existing
+		return &copy, mysql.ErrIdempotencyKeyExists
+	}
+	s.keys[key] = &mysql.IdempotencyKey{UserID: userID, Key: key, Fingerprint: fingerprint, ExpiresAt: expiresAt}
+	return nil, nil
+}
+
+func (s *idempotencyStore) CompleteIdempotencyKey(ctx context.Context, userID int, key string, statusCode int, header map[string][]string, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record := s.keys[key]
+	record.StatusCode, record.Header, record.Body = statusCode, header, body
+	return nil
+}
+
+func (s *idempotencyStore) ReleaseIdempotencyKey(ctx context.Context, userID int, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, key)
+	return nil
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	store := &idempotencyStore{keys: map[string]*mysql.IdempotencyKey{}}
+	s := &Server{db: store, idempotencyKeyTTL: time.Hour}
+	h := s.RegisterRoutes()
+
+	post := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/users/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	alice := `{"username": "alice", "email": "alice@example.com", "password": "secret123"}`
+	bob := `{"username": "bob", "email": "bob@example.com", "password": "secret123"}`
+
+	// Retries get the first response replayed
+	first := post("key-1", alice)
+	if first.Code != http.StatusCreated || first.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("expected 201, got %d: %s", first.Code, first.Body)
+	}
+	retry := post("key-1", alice)
+	if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() ||
+		retry.Header().Get("Idempotent-Replayed") != "true" || retry.Header().Get("Content-Type") != first.Header().Get("Content-Type") {
+		t.Fatalf("expected the first response to be replayed, got %d: %s", retry.Code, retry.Body)
+	}
+	if store.created != 1 {
+		t.Fatalf("expected 1 user to be created, got %d", store.created)
+	}
+
+	// Keys cannot be reused for other requests
+	if w := post("key-1", bob); w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), CodeIdempotencyKeyReused) {
+		t.Fatalf("expected 422 %s, got %d: %s", CodeIdempotencyKeyReused, w.Code, w.Body)
+	}
+
+	// Bodies are read in full to be fingerprinted, up to the usual limit
+	large := `{"username": "` + strings.Repeat("x", maxRequestBodySize) + `"}`
+	if w := post("key-large", large); w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), CodeRequestTooLarge) {
+		t.Fatalf("expected 413 %s, got %d: %s", CodeRequestTooLarge, w.Code, w.Body)
+	}
+
+	// Requests without a key are not deduplicated
+	post("", bob)
+	post("", bob)
+	if store.created != 3 {
+		t.Fatalf("expected 3 users to be created, got %d", store.created)
+	}
+
+	// Server errors are not stored
+	store.fail = true
+	if w := post("key-2", bob); w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d: %s", w.Code, w.Body)
+	}
+	store.fail = false
+	if w := post("key-2", bob); w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("expected the retry to be handled, got %d: %s", w.Code, w.Body)
+	}
+
+	// Duplicates of an in-flight request wait for its response
+	store.release = make(chan struct{})
+	responses := make(chan *httptest.ResponseRecorder, 2)
+	claimedKeys := len(store.keys)
+	go func() { responses <- post("key-3", bob) }()
+	for {
+		store.mu.Lock()
+		claimed := len(store.keys) > claimedKeys
+		store.mu.Unlock()
+		if claimed {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	go func() { responses <- post("key-3", bob) }()
+	time.Sleep(2 * idempotencyPoll)
+	close(store.release)
+
+	replayed := 0
+	for range 2 {
+		w := <-responses
+		if w.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d: %s", w.Code, w.Body)
+		}
+		if w.Header().Get("Idempotent-Replayed") == "true" {
+			replayed++
+		}
+	}
+	if replayed != 1 || store.created != 5 {
+		t.Fatalf("expected one request to be handled and one replayed, got %d replayed and %d users", replayed, store.created)
+	}
+}
+
+func TestRequestFingerprint(t *testing.T) {
+	body := []byte(`{"username": "alice", "email": "alice@example.com", "password": "secret123"}`)
+	fingerprint := requestFingerprint([]byte("secret"), "POST", "/api/v1/users/", body)
+
+	if got := requestFingerprint([]byte("secret"), "POST", "/api/v1/users/", body); got != fingerprint {
+		t.Errorf("expected the same request to have the same fingerprint, got %s and %s", fingerprint, got)
+	}
+	if got := requestFingerprint([]byte("other"), "POST", "/api/v1/users/", body); got == fingerprint {
+		t.Error("expected the fingerprint to depend on the key")
+	}
+
+	// Without the key, the fingerprint cannot be recomputed from a guess
+	h := sha256.New()
+	h.Write([]byte("POST /api/v1/users/\n"))
+	h.Write(body)
+	if hex.EncodeToString(h.Sum(nil)) == fingerprint {
+		t.Error("expected the fingerprint not to be a plain hash of the request")
+	}
+}
+
+func TestIdempotencyKeyScopes(t *testing.T) {
+	api := newUserAPI(t)
+	alice := `{"username": "alice", "email": "alice@example.com", "password": "secret123"}`
+	bob := `{"username": "bob", "email": "bob@example.com", "password": "secret456"}`
+
+	// Anonymous callers reusing a key for another request are rejected
+	// rather than shown each other's responses
+	first := api.do("POST", "/api/v1/users/", "", alice, "Idempotency-Key", "shared")
+	expect(t, first, http.StatusCreated, "")
+	second := api.do("POST", "/api/v1/users/", "", bob, "Idempotency-Key", "shared")
+	expect(t, second, http.StatusUnprocessableEntity, CodeIdempotencyKeyReused)
+	if strings.Contains(second.Body.String(), "alice") {
+		t.Fatalf("expected nothing of alice's response, got %s", second.Body)
+	}
+	retry := api.do("POST", "/api/v1/users/", "", alice, "Idempotency-Key", "shared")
+	if retry.Header().Get("Idempotent-Replayed") != "true" || retry.Body.String() != first.Body.String() {
+		t.Fatalf("expected the retry to be replayed, got %d: %s", retry.Code, retry.Body)
+	}
+
+	// Authenticated users cannot reuse a key for another request
+	expect(t, api.do("POST", "/api/v1/admin/roles", api.adminToken, `{"name": "auditor"}`, "Idempotency-Key", "shared"), http.StatusCreated, "")
+	expect(t, api.do("POST", "/api/v1/admin/roles", api.adminToken, `{"name": "viewer"}`, "Idempotency-Key", "shared"),
+		http.StatusUnprocessableEntity, CodeIdempotencyKeyReused)
+}
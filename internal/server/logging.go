@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"golang-backend/internal/logging"
+)
+
+// requestIDHeader carries the ID correlating the logs of a request. It is
+// taken from the request if the client or a proxy set it, and returned
+// with the response.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the longest request ID accepted from clients
+const maxRequestIDLength = 128
+
+// logger returns the logger of s, or the default logger for servers
+// built without one
+func (s *Server) logger() *slog.Logger {
+	if s.log == nil {
+		return slog.Default()
+	}
+	return s.log
+}
+
+// requestContext returns ctx carrying the logger of s and the request ID
+// id, or a new one if id is not a valid request ID
+func (s *Server) requestContext(ctx context.Context, id string) (context.Context, string) {
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	ctx = logging.NewContext(ctx, s.logger())
+	return logging.WithRequestID(ctx, id), id
+}
+
+// requestID tags the request context with the X-Request-ID of the request,
+// generating one if missing, and returns it in the response
+func (s *Server) requestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, id := s.requestContext(c.Request.Context(), c.GetHeader(requestIDHeader))
+		c.Request = c.Request.WithContext(ctx)
+		c.Header(requestIDHeader, id)
+		c.Next()
+	}
+}
+
+// accessLog logs every request once it has been handled, at error level
+// for server errors and warn level for client errors
+func (s *Server) accessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("route", c.FullPath()),
+			slog.Int("status", status),
+			slog.Duration("latency", time.Since(start)),
+			slog.Int("bytes", max(c.Writer.Size(), 0)),
+		}
+		if p := principalFrom(c); p != nil {
+			attrs = append(attrs, slog.Int("user_id", p.UserID))
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+		s.logger().LogAttrs(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// validRequestID reports whether id is a non-empty printable ASCII string
+// of at most maxRequestIDLength characters
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"golang-backend/internal/database"
+	"golang-backend/internal/logging"
+)
+
+func TestRequestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, logging.Options{Format: "json"})
+	if err != nil {
+		t.Fatalf("failed to create logger: %v", err)
+	}
+	s := &Server{
+		log:    logger,
+		tokens: newTestTokenManager(t),
+		db:     &permissionStore{permissions: map[int][]string{}},
+	}
+	r := gin.New()
+	r.Use(s.requestID(), s.accessLog())
+	r.GET("/users/:id", s.requireAuth(), s.requireSelfOr(mysql.PermUsersRead), func(c *gin.Context) {
+		ctx := c.Request.Context()
+		logging.FromContext(ctx).InfoContext(ctx, "handled", "password", "secret123")
+		c.Status(http.StatusNoContent)
+	})
+
+	token, _, err := s.tokens.IssueAccessToken(7, "user", nil)
+	if err != nil {
+		t.Fatalf("failed to issue token: %v", err)
+	}
+
+	// Request IDs from the client are kept
+	req := httptest.NewRequest("GET", "/users/7", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(requestIDHeader, "abc-123")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent || w.Header().Get(requestIDHeader) != "abc-123" {
+		t.Fatalf("expected 204 with request ID abc-123, got %d and %q", w.Code, w.Header().Get(requestIDHeader))
+	}
+	if strings.Contains(buf.String(), token) || strings.Contains(buf.String(), "secret123") {
+		t.Fatalf("expected secrets to be kept out of the logs, got %s", buf.String())
+	}
+
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("failed to decode log record %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 log records, got %d: %s", len(records), buf.String())
+	}
+	handled, access := records[0], records[1]
+	if handled["request_id"] != "abc-123" || handled["password"] != logging.Redacted {
+		t.Errorf("expected the handler record to be tagged and redacted, got %v", handled)
+	}
+	if access["request_id"] != "abc-123" || access["route"] != "/users/:id" ||
+		access["status"] != float64(http.StatusNoContent) || access["user_id"] != float64(7) || access["method"] != "GET" {
+		t.Errorf("unexpected access log record %v", access)
+	}
+
+	// Missing or invalid request IDs are replaced
+	for _, id := range []string{"", "bad id", strings.Repeat("a", maxRequestIDLength+1)} {
+		req := httptest.NewRequest("GET", "/users/7", nil)
+		if id != "" {
+			req.Header.Set(requestIDHeader, id)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if got := w.Header().Get(requestIDHeader); got == "" || got == id {
+			t.Errorf("expected request ID %q to be replaced, got %q", id, got)
+		}
+	}
+}
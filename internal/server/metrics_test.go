@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+
+	"golang-backend/internal/metrics"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	s := &Server{metrics: metrics.New()}
+	srv := httptest.NewServer(s.RegisterRoutes())
+	defer srv.Close()
+
+	get := func(path string) *http.Response {
+		t.Helper()
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		return resp
+	}
+	get("/").Body.Close()
+	get("/").Body.Close()
+	get("/no/such/route").Body.Close()
+
+	// Websockets are counted while open
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http")+"/websocket", nil)
+	if err != nil {
+		t.Fatalf("failed to open websocket: %v", err)
+	}
+	if _, _, err := conn.Read(ctx); err != nil {
+		t.Fatalf("failed to read from websocket: %v", err)
+	}
+
+	scrape := func() string {
+		t.Helper()
+		resp := get("/metrics")
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("failed to read metrics: %v", err)
+		}
+		return string(b)
+	}
+	body := scrape()
+	for _, want := range []string{
+		`app_http_requests_total{method="GET",route="/",status="200"} 2`,
+		`app_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`app_http_request_duration_seconds_bucket{method="GET",route="/",status="200",le="+Inf"} 2`,
+		`app_websocket_connections 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "/no/such/route") {
+		t.Error("expected unmatched paths to be left out of the labels")
+	}
+
+	conn.Close(websocket.StatusNormalClosure, "")
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(scrape(), "app_websocket_connections 0") {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the websocket to be counted as closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
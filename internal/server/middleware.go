@@ -0,0 +1,196 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// principalKey is the gin.Context key holding the authenticated *Principal
+const principalKey = "principal"
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	UserID   int
+	Username string
+	Roles    []string
+
+	mu          sync.Mutex // Guards permissions, checked concurrently by GraphQL resolvers
+	permissions []string   // Loaded lazily by hasPermission
+}
+
+// HasRole reports whether the principal holds the given role
+func (p *Principal) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+// principalFrom returns the principal stored by requireAuth, or nil
+func principalFrom(c *gin.Context) *Principal {
+	v, ok := c.Get(principalKey)
+	if !ok {
+		return nil
+	}
+	p, _ := v.(*Principal)
+	return p
+}
+
+// requireAuth validates the bearer token of the request and stores the
+// authenticated principal in the context. Requests without a valid token
+// are rejected with 401.
+func (s *Server) requireAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := bearerToken(c.GetHeader("Authorization"))
+		if !ok {
+			c.Header("WWW-Authenticate", `Bearer`)
+			respondError(c, newProblem(http.StatusUnauthorized, CodeUnauthenticated, "Authentication required"))
+			return
+		}
+
+		p, err := s.authenticate(token)
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			respondError(c, err)
+			return
+		}
+		c.Set(principalKey, p)
+
+		c.Next()
+	}
+}
+
+// optionalAuth is requireAuth for requests with an Authorization header.
+// Requests without one continue without a principal.
+func (s *Server) optionalAuth() gin.HandlerFunc {
+	requireAuth := s.requireAuth()
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			c.Next()
+			return
+		}
+		requireAuth(c)
+	}
+}
+
+// bearerToken returns the token of an Authorization header value using
+// the Bearer scheme
+func bearerToken(header string) (string, bool) {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+// authenticate returns the principal an access token was issued to
+func (s *Server) authenticate(token string) (*Principal, error) {
+	claims, err := s.tokens.ParseAccessToken(token)
+	if err != nil {
+		return nil, newProblem(http.StatusUnauthorized, CodeInvalidToken, "Invalid or expired token")
+	}
+
+	// ParseAccessToken already rejects tokens without a numeric subject
+	userID, _ := claims.UserID()
+	return &Principal{
+		UserID:   userID,
+		Username: claims.Username,
+		Roles:    claims.Roles,
+	}, nil
+}
+
+// requirePermission only lets principals holding permission through one of
+// their roles. It must run after requireAuth.
+func (s *Server) requirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowed, err := s.hasPermission(c, permission)
+		if err != nil {
+			respondError(c, fmt.Errorf("failed to check permissions: %w", err))
+			return
+		}
+		if !allowed {
+			forbidden(c)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// requireSelfOr lets a principal through if the :id route parameter is their
+// own user ID or they hold permission. It must run after requireAuth.
+func (s *Server) requireSelfOr(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p := principalFrom(c)
+		if p == nil {
+			forbidden(c)
+			return
+		}
+
+		if id, err := strconv.Atoi(c.Param("id")); err == nil && id == p.UserID {
+			c.Next()
+			return
+		}
+
+		s.requirePermission(permission)(c)
+	}
+}
+
+// hasPermission reports whether the principal of the request holds
+// permission. Permissions are loaded once per request so that changes to
+// roles apply without waiting for the access token to expire.
+func (s *Server) hasPermission(c *gin.Context, permission string) (bool, error) {
+	return s.principalHasPermission(c.Request.Context(), principalFrom(c), permission)
+}
+
+// authorize returns an error unless p holds permission. If selfID is not
+// zero, p may also act on their own user with that ID without it. It is
+// the check behind requireSelfOr for callers outside gin.
+func (s *Server) authorize(ctx context.Context, p *Principal, permission string, selfID int) error {
+	if p == nil {
+		return newProblem(http.StatusUnauthorized, CodeUnauthenticated, "Authentication required")
+	}
+	if selfID != 0 && selfID == p.UserID {
+		return nil
+	}
+
+	allowed, err := s.principalHasPermission(ctx, p, permission)
+	if err != nil {
+		return fmt.Errorf("failed to check permissions: %w", err)
+	}
+	if !allowed {
+		return errForbidden
+	}
+	return nil
+}
+
+// principalHasPermission reports whether p holds permission, loading the
+// permissions of p on first use
+func (s *Server) principalHasPermission(ctx context.Context, p *Principal, permission string) (bool, error) {
+	if p == nil {
+		return false, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.permissions == nil {
+		permissions, err := s.db.GetUserPermissions(ctx, p.UserID)
+		if err != nil {
+			return false, err
+		}
+		p.permissions = permissions
+	}
+
+	return slices.Contains(p.permissions, permission), nil
+}
+
+// errForbidden is the problem for principals lacking a permission
+var errForbidden = newProblem(http.StatusForbidden, CodeForbidden, "You are not allowed to access this resource")
+
+func forbidden(c *gin.Context) {
+	respondError(c, errForbidden)
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"golang-backend/internal/auth"
+	"golang-backend/internal/database"
+)
+
+// permissionStore is a mysql.Service that only answers permission lookups
+type permissionStore struct {
+	mysql.Service
+	permissions map[int][]string
+}
+
+func (s *permissionStore) GetUserPermissions(ctx context.Context, userID int) ([]string, error) {
+	return s.permissions[userID], nil
+}
+
+func newTestTokenManager(t *testing.T) *auth.TokenManager {
+	t.Helper()
+	tokens, err := auth.NewTokenManager(auth.TokenConfig{Secret: []byte("0123456789abcdef0123456789abcdef")})
+	if err != nil {
+		t.Fatalf("failed to create token manager: %v", err)
+	}
+	return tokens
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	s := &Server{
+		tokens: newTestTokenManager(t),
+		db: &permissionStore{permissions: map[int][]string{
+			2: {mysql.PermUsersRead, mysql.PermUsersUpdate},
+			3: {mysql.PermUsersRead},
+		}},
+	}
+	r := gin.New()
+	r.GET("/users/:id", s.requireAuth(), s.requireSelfOr(mysql.PermUsersRead), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"user_id": principalFrom(c).UserID})
+	})
+	r.PUT("/users/:id", s.requireAuth(), s.requireSelfOr(mysql.PermUsersUpdate), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"user_id": principalFrom(c).UserID})
+	})
+
+	userToken, _, err := s.tokens.IssueAccessToken(1, "user", nil)
+	if err != nil {
+		t.Fatalf("failed to issue token: %v", err)
+	}
+	adminToken, _, err := s.tokens.IssueAccessToken(2, "admin", []string{mysql.RoleAdmin})
+	if err != nil {
+		t.Fatalf("failed to issue token: %v", err)
+	}
+	supportToken, _, err := s.tokens.IssueAccessToken(3, "support", []string{mysql.RoleSupport})
+	if err != nil {
+		t.Fatalf("failed to issue token: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		header string
+		want   int
+	}{
+		{"missing token", "GET", "/users/1", "", http.StatusUnauthorized},
+		{"malformed header", "GET", "/users/1", "Token " + userToken, http.StatusUnauthorized},
+		{"invalid token", "GET", "/users/1", "Bearer not-a-token", http.StatusUnauthorized},
+		{"own user", "GET", "/users/1", "Bearer " + userToken, http.StatusOK},
+		{"update own user", "PUT", "/users/1", "Bearer " + userToken, http.StatusOK},
+		{"other user", "GET", "/users/2", "Bearer " + userToken, http.StatusForbidden},
+		{"admin reads other user", "GET", "/users/1", "Bearer " + adminToken, http.StatusOK},
+		{"admin updates other user", "PUT", "/users/1", "Bearer " + adminToken, http.StatusOK},
+		{"support reads other user", "GET", "/users/1", "Bearer " + supportToken, http.StatusOK},
+		{"support updates other user", "PUT", "/users/1", "Bearer " + supportToken, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			if rr.Code != tt.want {
+				t.Errorf("Handler returned wrong status code: got %v want %v", rr.Code, tt.want)
+			}
+			if rr.Code == http.StatusUnauthorized && rr.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("expected WWW-Authenticate header on 401")
+			}
+		})
+	}
+}
@@ -0,0 +1,495 @@
+package server
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"maps"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"golang-backend/internal/database"
+	"golang-backend/internal/health"
+	"golang-backend/internal/openapi"
+)
+
+//go:embed docs.html
+var docsPage []byte
+
+// operation documents the handler of a route in the OpenAPI document.
+// Routes whose handler has no operation are left out of the document.
+type operation struct {
+	summary    string
+	tag        string
+	permission string               // Required permission; empty for public operations
+	self       bool                 // Users may call it on themselves without the permission
+	params     []*openapi.Parameter // Query and header parameters; path parameters come from the route
+	request    map[string]any       // Request body type by media type
+	responses  map[int]any          // Response body type by status; nil for responses without a body
+	etag       bool                 // Successful responses carry the user's ETag
+}
+
+// Response bodies as written by the handlers, declared for the OpenAPI
+// document. Responses are validated against them in test mode, so they
+// cannot silently drift apart.
+type (
+	messageResponse struct {
+		Message string `json:"message"`
+	}
+	userResponse struct {
+		User *mysql.User `json:"user"`
+	}
+	userMessageResponse struct {
+		Message string      `json:"message"`
+		User    *mysql.User `json:"user"`
+	}
+	userListResponse struct {
+		Users      []*mysql.User `json:"users"`
+		Pagination Pagination    `json:"pagination"`
+	}
+	tokenResponse struct {
+		AccessToken      string      `json:"access_token"`
+		TokenType        string      `json:"token_type"`
+		ExpiresIn        int         `json:"expires_in"` // Seconds
+		ExpiresAt        time.Time   `json:"expires_at"`
+		RefreshToken     string      `json:"refresh_token"`
+		RefreshExpiresAt time.Time   `json:"refresh_expires_at"`
+		User             *mysql.User `json:"user"`
+	}
+	roleResponse struct {
+		Role *mysql.Role `json:"role"`
+	}
+	roleMessageResponse struct {
+		Message string      `json:"message"`
+		Role    *mysql.Role `json:"role"`
+	}
+	rolesResponse struct {
+		Roles []*mysql.Role `json:"roles"`
+	}
+	permissionsResponse struct {
+		Permissions []*mysql.Permission `json:"permissions"`
+	}
+	userRolesResponse struct {
+		Roles []string `json:"roles"`
+	}
+)
+
+// userMergePatch is a JSON Merge Patch of a user, see PatchUserHandler
+type userMergePatch struct {
+	Username string `json:"username,omitempty"`
+	Email    string `json:"email,omitempty" binding:"omitempty,email"`
+}
+
+// patchOperation is a single operation of a JSON Patch
+type patchOperation struct {
+	Op    string `json:"op" binding:"required,oneof=add remove replace move copy test"`
+	Path  string `json:"path" binding:"required"`
+	From  string `json:"from,omitempty"`
+	Value any    `json:"value,omitempty"`
+}
+
+func jsonBody(v any) map[string]any {
+	return map[string]any{"application/json": v}
+}
+
+var minPageLimit = 1.0
+
+// Parameters shared by several operations
+var (
+	ifMatch = &openapi.Parameter{
+		Name:        "If-Match",
+		In:          "header",
+		Description: "ETag of the user as last read. Required: a missing header is rejected with 428 and a stale one with 412.",
+		Schema:      &openapi.Schema{Type: openapi.Type{"string"}},
+	}
+	ifNoneMatch = &openapi.Parameter{
+		Name:        "If-None-Match",
+		In:          "header",
+		Description: "ETag of a cached copy; 304 is returned if it is still current",
+		Schema:      &openapi.Schema{Type: openapi.Type{"string"}},
+	}
+	idempotencyKey = &openapi.Parameter{
+		Name:        "Idempotency-Key",
+		In:          "header",
+		Description: "Unique key of the request, e.g. a UUID. Retries with the same key and body get the first response replayed instead of repeating the request.",
+		Schema:      &openapi.Schema{Type: openapi.Type{"string"}, MaxLength: &idempotencyKeyMaxLength},
+	}
+	listUsersParameters = []*openapi.Parameter{
+		queryParameter("limit", "Page size, capped at 100", &openapi.Schema{Type: openapi.Type{"integer"}, Minimum: &minPageLimit}),
+		queryParameter("cursor", "Cursor of the page to fetch, from a previous response", nil),
+		queryParameter("sort", `Field to sort by, prefixed with "-" for descending order`, &openapi.Schema{Type: openapi.Type{"string"}, Enum: userSortValues()}),
+		queryParameter("q", "Prefix of the username or email", nil),
+		queryParameter("username", "Prefix of the username", nil),
+		queryParameter("email", "Prefix of the email", nil),
+		queryParameter("created_after", "Only users created at or after this time", dateTime()),
+		queryParameter("created_before", "Only users created before this time", dateTime()),
+		queryParameter("updated_after", "Only users updated at or after this time", dateTime()),
+		queryParameter("updated_before", "Only users updated before this time", dateTime()),
+	}
+)
+
+func queryParameter(name, description string, schema *openapi.Schema) *openapi.Parameter {
+	if schema == nil {
+		schema = &openapi.Schema{Type: openapi.Type{"string"}}
+	}
+	return &openapi.Parameter{Name: name, In: "query", Description: description, Schema: schema}
+}
+
+func dateTime() *openapi.Schema {
+	return &openapi.Schema{Type: openapi.Type{"string"}, Format: "date-time"}
+}
+
+// userSortValues returns the accepted values of the sort query parameter
+func userSortValues() []any {
+	var values []any
+	for _, f := range []string{mysql.SortByID, mysql.SortByUsername, mysql.SortByEmail, mysql.SortByCreatedAt, mysql.SortByUpdatedAt} {
+		values = append(values, f, "-"+f)
+	}
+	return values
+}
+
+// probeResponses are the responses of the health probes, which fail with
+// 503 and their report rather than a problem
+var probeResponses = map[int]any{200: health.Report{}, 503: health.Report{}}
+
+var verboseParameter = queryParameter("verbose", "List the result of every check, as in ?verbose or ?verbose=true", nil)
+
+// pathParameters describes the path parameters used in routes
+var pathParameters = map[string]*openapi.Parameter{
+	"id":         {Description: "User ID", Schema: &openapi.Schema{Type: openapi.Type{"integer"}}},
+	"name":       {Description: "Role name", Schema: &openapi.Schema{Type: openapi.Type{"string"}}},
+	"role":       {Description: "Role name", Schema: &openapi.Schema{Type: openapi.Type{"string"}}},
+	"permission": {Description: "Permission name", Schema: &openapi.Schema{Type: openapi.Type{"string"}}},
+}
+
+// operations documents the handlers by name. Every version a handler is
+// registered in shares its operation.
+var operations = map[string]operation{
+	"HelloWorldHandler": {summary: "Hello world", tag: "Service", responses: map[int]any{200: map[string]string{}}},
+	"healthHandler":     {summary: "Readiness with the result of every check", tag: "Service", responses: probeResponses},
+	"livezHandler":      {summary: "Liveness probe", tag: "Service", params: []*openapi.Parameter{verboseParameter}, responses: probeResponses},
+	"readyzHandler":     {summary: "Readiness probe", tag: "Service", params: []*openapi.Parameter{verboseParameter}, responses: probeResponses},
+	"startupzHandler":   {summary: "Startup probe", tag: "Service", params: []*openapi.Parameter{verboseParameter}, responses: probeResponses},
+
+	"LoginHandler":   {summary: "Log in and get an access token", tag: "Auth", request: jsonBody(LoginRequest{}), responses: map[int]any{200: tokenResponse{}}},
+	"RefreshHandler": {summary: "Rotate refresh token", tag: "Auth", request: jsonBody(RefreshRequest{}), responses: map[int]any{200: tokenResponse{}}},
+	"LogoutHandler":  {summary: "Revoke refresh token family", tag: "Auth", request: jsonBody(RefreshRequest{}), responses: map[int]any{200: messageResponse{}}},
+
+	"CreateUserHandler": {
+		summary: "Create user (sign up)", tag: "Users",
+		params: []*openapi.Parameter{idempotencyKey}, request: jsonBody(UserRequest{}), responses: map[int]any{201: userMessageResponse{}},
+	},
+	"GetAllUsersHandler": {
+		summary: "List users", tag: "Users", permission: mysql.PermUsersList,
+		params: listUsersParameters, responses: map[int]any{200: userListResponse{}},
+	},
+	"GetUserHandler": {
+		summary: "Get user by ID", tag: "Users", permission: mysql.PermUsersRead, self: true, etag: true,
+		params: []*openapi.Parameter{ifNoneMatch}, responses: map[int]any{200: userResponse{}, 304: nil},
+	},
+	"UpdateUserHandler": {
+		summary: "Update user", tag: "Users", permission: mysql.PermUsersUpdate, self: true, etag: true,
+		params: []*openapi.Parameter{ifMatch}, request: jsonBody(UpdateUserRequest{}), responses: map[int]any{200: userMessageResponse{}},
+	},
+	"PatchUserHandler": {
+		summary: "Partially update user", tag: "Users", permission: mysql.PermUsersUpdate, self: true, etag: true,
+		params:    []*openapi.Parameter{ifMatch},
+		request:   map[string]any{mergePatchContentType: userMergePatch{}, jsonPatchContentType: []patchOperation{}},
+		responses: map[int]any{200: userMessageResponse{}},
+	},
+	"UpdatePasswordHandler": {
+		summary: "Update password", tag: "Users", permission: mysql.PermUsersUpdate, self: true, etag: true,
+		params: []*openapi.Parameter{ifMatch}, request: jsonBody(UpdatePasswordRequest{}), responses: map[int]any{200: messageResponse{}},
+	},
+	"DeleteUserHandler": {
+		summary: "Delete user", tag: "Users", permission: mysql.PermUsersDelete, self: true,
+		responses: map[int]any{200: messageResponse{}},
+	},
+	"RestoreUserHandler": {
+		summary: "Restore deleted user", tag: "Users", permission: mysql.PermUsersRestore,
+		params: []*openapi.Parameter{idempotencyKey}, responses: map[int]any{200: userMessageResponse{}},
+	},
+
+	"ListRolesHandler": {summary: "List roles", tag: "Admin", permission: mysql.PermRolesRead, responses: map[int]any{200: rolesResponse{}}},
+	"CreateRoleHandler": {
+		summary: "Create role", tag: "Admin", permission: mysql.PermRolesManage,
+		params: []*openapi.Parameter{idempotencyKey}, request: jsonBody(RoleRequest{}), responses: map[int]any{201: roleMessageResponse{}},
+	},
+	"GetRoleHandler":          {summary: "Get role", tag: "Admin", permission: mysql.PermRolesRead, responses: map[int]any{200: roleResponse{}}},
+	"DeleteRoleHandler":       {summary: "Delete role", tag: "Admin", permission: mysql.PermRolesManage, responses: map[int]any{200: messageResponse{}}},
+	"GrantPermissionHandler":  {summary: "Grant permission", tag: "Admin", permission: mysql.PermRolesManage, responses: map[int]any{200: messageResponse{}}},
+	"RevokePermissionHandler": {summary: "Revoke permission", tag: "Admin", permission: mysql.PermRolesManage, responses: map[int]any{200: messageResponse{}}},
+	"ListPermissionsHandler":  {summary: "List permissions", tag: "Admin", permission: mysql.PermRolesRead, responses: map[int]any{200: permissionsResponse{}}},
+	"ListDeletedUsersHandler": {
+		summary: "List deleted users", tag: "Admin", permission: mysql.PermUsersRestore,
+		params: listUsersParameters, responses: map[int]any{200: userListResponse{}},
+	},
+	"GetUserRolesHandler": {summary: "Get user roles", tag: "Admin", permission: mysql.PermRolesRead, responses: map[int]any{200: userRolesResponse{}}},
+	"AssignRoleHandler":   {summary: "Assign role", tag: "Admin", permission: mysql.PermRolesManage, responses: map[int]any{200: messageResponse{}}},
+	"UnassignRoleHandler": {summary: "Unassign role", tag: "Admin", permission: mysql.PermRolesManage, responses: map[int]any{200: messageResponse{}}},
+}
+
+// openAPIDocument builds the OpenAPI document of the given routes. Routes
+// of deprecated API versions are marked deprecated.
+func (s *Server) openAPIDocument(routes gin.RoutesInfo) *openapi.Document {
+	doc := openapi.New("golang-backend API", "1")
+	gen := openapi.NewGenerator()
+	problem := map[string]*openapi.MediaType{problemContentType: {Schema: gen.Schema(Problem{})}}
+
+	for _, route := range routes {
+		name := handlerName(route.Handler)
+		op, ok := operations[name]
+		if !ok {
+			continue
+		}
+
+		o := &openapi.Operation{
+			Summary:   op.summary,
+			Tags:      []string{op.tag},
+			Responses: map[string]*openapi.Response{"default": {Description: "Error", Content: problem}},
+		}
+		if v := s.versionOf(route.Path); v != nil && v.deprecation != nil {
+			o.Deprecated = true
+		} else {
+			o.OperationID = strings.ToLower(name[:1]) + strings.TrimSuffix(name[1:], "Handler")
+		}
+
+		if op.permission != "" {
+			o.Security = []map[string][]string{{"bearerAuth": {}}}
+			o.Description = "Requires the `" + op.permission + "` permission"
+			if op.self {
+				o.Description += " unless called on the authenticated user"
+			}
+			o.Description += "."
+		}
+
+		path, params := openAPIPath(route.Path)
+		for _, p := range params {
+			param := openapi.Parameter{Schema: &openapi.Schema{Type: openapi.Type{"string"}}}
+			if known, ok := pathParameters[p]; ok {
+				param = *known
+			}
+			param.Name, param.In, param.Required = p, "path", true
+			o.Parameters = append(o.Parameters, &param)
+		}
+		o.Parameters = append(o.Parameters, op.params...)
+
+		if op.request != nil {
+			o.RequestBody = &openapi.RequestBody{Required: true, Content: map[string]*openapi.MediaType{}}
+			for contentType, body := range op.request {
+				o.RequestBody.Content[contentType] = &openapi.MediaType{Schema: gen.Schema(body)}
+			}
+		}
+
+		for status, body := range op.responses {
+			resp := &openapi.Response{Description: http.StatusText(status)}
+			if body != nil {
+				resp.Content = map[string]*openapi.MediaType{"application/json": {Schema: gen.Schema(body)}}
+			}
+			if op.etag {
+				resp.Headers = map[string]*openapi.Header{
+					"ETag": {Description: "Current version of the user", Schema: &openapi.Schema{Type: openapi.Type{"string"}}},
+				}
+			}
+			o.Responses[strconv.Itoa(status)] = resp
+		}
+
+		doc.AddOperation(route.Method, path, o)
+	}
+
+	doc.Components.Schemas = gen.Schemas()
+	doc.Components.SecuritySchemes = map[string]*openapi.SecurityScheme{
+		"bearerAuth": {Type: "http", Scheme: "bearer", BearerFormat: "JWT", Description: "Access token from /auth/login"},
+	}
+	return doc
+}
+
+// handlerName returns the name of the method or function a route is
+// handled by, e.g. "GetUserHandler"
+func handlerName(handler string) string {
+	name := handler[strings.LastIndex(handler, ".")+1:]
+	return strings.TrimSuffix(name, "-fm")
+}
+
+// openAPIPath converts a gin route path such as "/users/:id" into an
+// OpenAPI path template, returning the names of its parameters
+func openAPIPath(route string) (string, []string) {
+	var params []string
+	segments := strings.Split(route, "/")
+	for i, seg := range segments {
+		if len(seg) > 1 && (seg[0] == ':' || seg[0] == '*') {
+			params = append(params, seg[1:])
+			segments[i] = "{" + seg[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// openAPIHandler serves the OpenAPI document
+func (s *Server) openAPIHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, s.spec)
+}
+
+// docsHandler serves a page rendering the OpenAPI document. The page
+// loads nothing but the document from other URLs.
+func (s *Server) docsHandler(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", docsPage)
+}
+
+// validateOpenAPI rejects requests that do not match the operation
+// documented for their route. In test mode, responses are checked too and
+// replaced by a 500 describing the mismatch. Routes missing from the
+// document are not checked.
+func (s *Server) validateOpenAPI() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path, _ := openAPIPath(c.FullPath())
+		op := s.spec.Operation(c.Request.Method, path)
+		if op == nil {
+			c.Next()
+			return
+		}
+
+		if err := s.checkRequest(c, op); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		if gin.Mode() != gin.TestMode {
+			c.Next()
+			return
+		}
+
+		w := &bufferedWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+
+		if err := s.checkResponse(op, w.Status(), w.Header(), w.body.Bytes()); err != nil {
+			// Test mode only, so the mismatch is returned to the test
+			respondError(c, newProblem(http.StatusInternalServerError, CodeInternal, err.Error()))
+			return
+		}
+		if w.body.Len() > 0 {
+			_, _ = w.ResponseWriter.Write(w.body.Bytes())
+		}
+	}
+}
+
+// checkRequest validates the parameters and body of the request against op
+func (s *Server) checkRequest(c *gin.Context, op *openapi.Operation) error {
+	var errs []openapi.ValidationError
+	for _, p := range op.Parameters {
+		var raw string
+		var ok bool
+		switch p.In {
+		case "path":
+			raw, ok = c.Params.Get(p.Name)
+		case "query":
+			raw, ok = c.GetQuery(p.Name)
+		case "header":
+			raw = c.GetHeader(p.Name)
+			ok = raw != ""
+		}
+
+		if !ok {
+			if p.Required {
+				errs = append(errs, openapi.ValidationError{Path: p.Name, Rule: "required", Message: "is required"})
+			}
+			continue
+		}
+		errs = append(errs, s.spec.ValidateParameter(p, raw)...)
+	}
+
+	if op.RequestBody != nil {
+		media, ok := op.RequestBody.Content[c.ContentType()]
+		if !ok {
+			accepted := slices.Sorted(maps.Keys(op.RequestBody.Content))
+			return newProblem(http.StatusUnsupportedMediaType, CodeUnsupportedMediaType,
+				"Content-Type must be "+strings.Join(accepted, " or "))
+		}
+
+		body, err := readBody(c)
+		if err != nil {
+			return err
+		}
+
+		v, err := openapi.DecodeJSON(body)
+		if err != nil {
+			return newProblem(http.StatusBadRequest, CodeInvalidRequest, "Request body must be valid JSON")
+		}
+		errs = append(errs, s.spec.Validate(media.Schema, v)...)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	p := newProblem(http.StatusBadRequest, CodeValidationFailed, "Request validation failed")
+	for _, e := range errs {
+		p.Errors = append(p.Errors, FieldError{Field: e.Path, Code: e.Rule, Message: e.Message})
+	}
+	return p
+}
+
+// checkResponse validates a response with status, header and body
+// against op
+func (s *Server) checkResponse(op *openapi.Operation, status int, header http.Header, body []byte) error {
+	resp, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		resp = op.Responses["default"]
+	}
+	if resp == nil {
+		return fmt.Errorf("status %d is not documented", status)
+	}
+
+	if len(resp.Content) == 0 {
+		if len(body) > 0 {
+			return fmt.Errorf("status %d is documented without a body", status)
+		}
+		return nil
+	}
+
+	contentType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	media, ok := resp.Content[contentType]
+	if !ok {
+		return fmt.Errorf("content type %q is not documented for status %d", contentType, status)
+	}
+
+	v, err := openapi.DecodeJSON(body)
+	if err != nil {
+		return fmt.Errorf("invalid JSON response: %w", err)
+	}
+	if errs := s.spec.Validate(media.Schema, v); len(errs) > 0 {
+		return fmt.Errorf("response does not match the OpenAPI document: %w", errors.Join(validationErrors(errs)...))
+	}
+	return nil
+}
+
+func validationErrors(errs []openapi.ValidationError) []error {
+	out := make([]error, len(errs))
+	for i, e := range errs {
+		out[i] = e
+	}
+	return out
+}
+
+// bufferedWriter holds back the response body so it can be validated
+// before it is sent
+type bufferedWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
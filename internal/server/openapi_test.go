@@ -0,0 +1,190 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"golang-backend/internal/database"
+)
+
+func TestOpenAPIDocument(t *testing.T) {
+	s := &Server{}
+	h := s.RegisterRoutes()
+
+	req := httptest.NewRequest("GET", "/openapi.json", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	var doc struct {
+		OpenAPI    string                                `json:"openapi"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Required   []string `json:"required"`
+				Properties map[string]struct {
+					Type      string `json:"type"`
+					Format    string `json:"format"`
+					MinLength int    `json:"minLength"`
+				} `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("failed to decode document: %v", err)
+	}
+
+	if doc.OpenAPI != "3.1.0" {
+		t.Fatalf("expected OpenAPI 3.1.0, got %q", doc.OpenAPI)
+	}
+	for _, method := range []string{"get", "put", "patch", "delete"} {
+		if _, ok := doc.Paths["/api/v1/users/{id}"][method]; !ok {
+			t.Fatalf("expected %s /api/v1/users/{id} to be documented", method)
+		}
+	}
+	if _, ok := doc.Paths["/websocket"]; ok {
+		t.Fatal("expected undocumented routes to be left out")
+	}
+
+	var legacy struct {
+		Deprecated bool `json:"deprecated"`
+	}
+	if err := json.Unmarshal(doc.Paths["/api/users/{id}"]["get"], &legacy); err != nil || !legacy.Deprecated {
+		t.Fatalf("expected unversioned route to be deprecated, got %s", doc.Paths["/api/users/{id}"]["get"])
+	}
+
+	user := doc.Components.Schemas["UserRequest"]
+	if !slices.Equal(user.Required, []string{"username", "email", "password"}) {
+		t.Fatalf("unexpected required fields %v", user.Required)
+	}
+	if user.Properties["email"].Format != "email" || user.Properties["password"].MinLength != 6 {
+		t.Fatalf("expected binding rules in schema, got %+v", user.Properties)
+	}
+	if _, ok := doc.Components.Schemas["User"].Properties["password"]; ok {
+		t.Fatal("expected password to be left out of the User schema")
+	}
+
+	req = httptest.NewRequest("GET", "/docs", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "/openapi.json") {
+		t.Fatalf("expected docs page, got status %d", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "https://") {
+		t.Fatal("expected the docs page not to load anything from other hosts")
+	}
+}
+
+func TestListUsersParametersDocumented(t *testing.T) {
+	var names []string
+	for _, p := range listUsersParameters {
+		names = append(names, p.Name)
+	}
+	if !slices.Equal(names, listUsersQueryParams) {
+		t.Fatalf("documented parameters %v do not match accepted parameters %v", names, listUsersQueryParams)
+	}
+}
+
+func TestOpenAPIValidation(t *testing.T) {
+	mode := gin.Mode()
+	gin.SetMode(gin.TestMode)
+	defer gin.SetMode(mode)
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		want        int
+		wantFields  []string
+	}{
+		{"valid", "PUT", "/api/v1/users/1", "application/json", `{"username":"renamed","email":"renamed@example.com"}`, http.StatusOK, nil},
+		{"invalid id", "PUT", "/api/v1/users/abc", "application/json", `{"username":"renamed","email":"renamed@example.com"}`, http.StatusBadRequest, []string{"id"}},
+		{"invalid body", "PUT", "/api/v1/users/1", "application/json", `{"email":"not-an-email","username":1}`, http.StatusBadRequest, []string{"email", "username"}},
+		{"missing field", "PUT", "/api/v1/users/1", "application/json", `{"username":"renamed"}`, http.StatusBadRequest, []string{"email"}},
+		{"wrong content type", "PUT", "/api/v1/users/1", "text/plain", `{}`, http.StatusUnsupportedMediaType, nil},
+		{"too large", "PUT", "/api/v1/users/1", "application/json", `{"username":"` + strings.Repeat("x", maxRequestBodySize) + `"}`, http.StatusRequestEntityTooLarge, nil},
+		{"invalid json patch", "PATCH", "/api/v1/users/1", jsonPatchContentType, `[{"op":"rename","path":"/username"}]`, http.StatusBadRequest, []string{"[0].op"}},
+		{"valid merge patch", "PATCH", "/api/v1/users/1", mergePatchContentType, `{"username":"renamed"}`, http.StatusOK, nil},
+		{"get", "GET", "/api/v1/users/1", "", "", http.StatusOK, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{db: &versionedStore{user: &mysql.User{ID: 1, Username: "user", Email: "user@example.com", Version: 3}}}
+			r := gin.New()
+			r.Use(s.validateOpenAPI())
+			r.GET("/api/v1/users/:id", s.GetUserHandler)
+			r.PUT("/api/v1/users/:id", s.UpdateUserHandler)
+			r.PATCH("/api/v1/users/:id", s.PatchUserHandler)
+			s.spec = s.openAPIDocument(r.Routes())
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			req.Header.Set("If-Match", "*")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("expected status %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+			if w.Code != http.StatusOK {
+				var p Problem
+				if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+					t.Fatalf("failed to decode problem: %v", err)
+				}
+				var fields []string
+				for _, fe := range p.Errors {
+					fields = append(fields, fe.Field)
+				}
+				if !slices.Equal(fields, tt.wantFields) {
+					t.Fatalf("expected failing fields %v, got %v", tt.wantFields, fields)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenAPIResponseValidation(t *testing.T) {
+	s := &Server{}
+	r := gin.New()
+	r.GET("/api/v1/users/:id", s.GetUserHandler)
+	s.spec = s.openAPIDocument(r.Routes())
+	op := s.spec.Operation("GET", "/api/v1/users/{id}")
+
+	header := http.Header{"Content-Type": {"application/json; charset=utf-8"}}
+	valid := `{"user":{"id":1,"username":"user","email":"user@example.com","created_at":"2026-01-01T00:00:00Z","updated_at":"2026-01-01T00:00:00Z"}}`
+	if err := s.checkResponse(op, http.StatusOK, header, []byte(valid)); err != nil {
+		t.Fatalf("expected valid response, got %v", err)
+	}
+	if err := s.checkResponse(op, http.StatusNotModified, http.Header{}, nil); err != nil {
+		t.Fatalf("expected valid 304 response, got %v", err)
+	}
+
+	invalid := []struct {
+		status int
+		header http.Header
+		body   string
+	}{
+		{http.StatusOK, header, `{"user":{"id":"1"}}`},
+		{http.StatusOK, header, `{"users":[]}`},
+		{http.StatusOK, http.Header{"Content-Type": {"text/plain"}}, valid},
+		{http.StatusNotFound, header, `{"message":"not found"}`},
+	}
+	for _, tt := range invalid {
+		if err := s.checkResponse(op, tt.status, tt.header, []byte(tt.body)); err == nil {
+			t.Fatalf("expected %d %s to be rejected", tt.status, tt.body)
+		}
+	}
+}
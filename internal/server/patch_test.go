@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"golang-backend/internal/database"
+)
+
+func (s *versionedStore) PatchUser(ctx context.Context, id, version int, changes mysql.UserChanges) (*mysql.User, error) {
+	if version != 0 && version != s.user.Version {
+		return nil, mysql.ErrVersionConflict
+	}
+	s.patched = changes
+	if changes.Username != nil {
+		s.user.Username = *changes.Username
+	}
+	if changes.Email != nil {
+		s.user.Email = *changes.Email
+	}
+	s.user.Version++
+	return s.GetUserByID(ctx, id)
+}
+
+func TestPatchUser(t *testing.T) {
+	tests := []struct {
+		name         string
+		contentType  string
+		body         string
+		want         int
+		wantUsername string
+		wantEmail    string
+	}{
+		{"merge patch username", mergePatchContentType, `{"username":"renamed"}`, http.StatusOK, "renamed", ""},
+		{"merge patch unchanged", mergePatchContentType, `{"email":"user@example.com"}`, http.StatusOK, "", ""},
+		{"merge patch remove required", mergePatchContentType, `{"username":null}`, http.StatusBadRequest, "", ""},
+		{"merge patch invalid email", mergePatchContentType, `{"email":"not-an-email"}`, http.StatusBadRequest, "", ""},
+		{"merge patch unknown field", mergePatchContentType, `{"password":"secret123"}`, http.StatusBadRequest, "", ""},
+		{"merge patch malformed", mergePatchContentType, `{`, http.StatusBadRequest, "", ""},
+		{"json patch email", jsonPatchContentType, `[{"op":"test","path":"/username","value":"user"},{"op":"replace","path":"/email","value":"new@example.com"}]`, http.StatusOK, "", "new@example.com"},
+		{"json patch failed test", jsonPatchContentType, `[{"op":"test","path":"/username","value":"other"},{"op":"replace","path":"/email","value":"new@example.com"}]`, http.StatusUnprocessableEntity, "", ""},
+		{"json patch missing path", jsonPatchContentType, `[{"op":"remove","path":"/id"}]`, http.StatusUnprocessableEntity, "", ""},
+		{"json patch malformed", jsonPatchContentType, `{"op":"replace"}`, http.StatusBadRequest, "", ""},
+		{"plain json", "application/json", `{"username":"renamed"}`, http.StatusUnsupportedMediaType, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &versionedStore{user: &mysql.User{ID: 1, Username: "user", Email: "user@example.com", Version: 3}}
+			s := &Server{db: store}
+			r := gin.New()
+			r.PATCH("/users/:id", s.PatchUserHandler)
+
+			req := httptest.NewRequest("PATCH", "/users/1", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			req.Header.Set("If-Match", `"3"`)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("expected status %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+
+			// Only the fields that changed are written
+			if got := deref(store.patched.Username); got != tt.wantUsername {
+				t.Fatalf("expected username change %q, got %q", tt.wantUsername, got)
+			}
+			if got := deref(store.patched.Email); got != tt.wantEmail {
+				t.Fatalf("expected email change %q, got %q", tt.wantEmail, got)
+			}
+			if got := w.Header().Get("ETag"); got != `"4"` {
+				t.Fatalf("expected ETag %q, got %q", `"4"`, got)
+			}
+		})
+	}
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,252 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"go.opentelemetry.io/otel/trace"
+
+	"golang-backend/internal/database"
+	"golang-backend/internal/logging"
+)
+
+// problemContentType is the media type of error responses (RFC 7807)
+const problemContentType = "application/problem+json"
+
+// Error codes returned in the "code" member of problem responses. Clients
+// may rely on them; messages in "detail" may change at any time.
+const (
+	CodeInvalidRequest       = "invalid_request"       // Malformed request
+	CodeValidationFailed     = "validation_failed"     // See "errors" for the failing fields
+	CodeInvalidCursor        = "invalid_cursor"        // Pagination cursor was modified or is for another sort
+	CodeUnauthenticated      = "unauthenticated"       // No access token
+	CodeInvalidToken         = "invalid_token"         // Access token is invalid or expired
+	CodeInvalidCredentials   = "invalid_credentials"   // Wrong login or password
+	CodeInvalidRefreshToken  = "invalid_refresh_token" // Refresh token is unknown, expired, revoked or reused
+	CodeForbidden            = "forbidden"             // Missing permission
+	CodeNotFound             = "not_found"             // No such route
+	CodeUserNotFound         = "user_not_found"
+	CodeRoleNotFound         = "role_not_found"
+	CodePermissionNotFound   = "permission_not_found"
+	CodeUsernameTaken        = "username_taken"
+	CodeEmailTaken           = "email_taken"
+	CodeRoleExists           = "role_exists"
+	CodeRoleProtected        = "role_protected"        // The role cannot be deleted
+	CodePreconditionRequired = "precondition_required" // If-Match is missing
+	CodeVersionConflict      = "version_conflict"      // If-Match does not match the current version
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeRequestTooLarge      = "request_too_large"      // Request body is larger than maxRequestBodySize
+	CodePatchNotApplicable   = "patch_not_applicable"   // JSON Patch cannot be applied to the resource
+	CodeIdempotencyKeyReused = "idempotency_key_reused" // Idempotency-Key was used for a different request
+	CodeIdempotencyKeyInUse  = "idempotency_key_in_use" // A request with the same Idempotency-Key is still in flight
+	CodeInternal             = "internal_error"         // Details are logged, never returned
+)
+
+// Problem is an RFC 7807 problem details object extended with a stable
+// error code and, for validation failures, the failing fields
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError describes why a single request field was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"` // Failed rule, e.g. "required" or "email"
+	Message string `json:"message"`
+}
+
+func (p *Problem) Error() string {
+	return p.Code + ": " + p.Detail
+}
+
+// newProblem returns a problem with the given status, code and detail
+func newProblem(status int, code, detail string) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// problemFor maps err to the problem returned to the client. Errors that
+// are neither a *Problem nor a known domain error become an opaque 500.
+func problemFor(err error) *Problem {
+	var p *Problem
+	if errors.As(err, &p) {
+		copy := *p
+		return &copy
+	}
+
+	switch {
+	case errors.Is(err, mysql.ErrUserNotFound):
+		return newProblem(http.StatusNotFound, CodeUserNotFound, "User not found")
+	case errors.Is(err, mysql.ErrDuplicateUsername):
+		return newProblem(http.StatusConflict, CodeUsernameTaken, "Username is already taken by an active or deleted user")
+	case errors.Is(err, mysql.ErrDuplicateEmail):
+		return newProblem(http.StatusConflict, CodeEmailTaken, "Email is already taken by an active or deleted user")
+	case errors.Is(err, mysql.ErrVersionConflict):
+		return newProblem(http.StatusPreconditionFailed, CodeVersionConflict, "User has been modified, fetch it again and retry")
+	case errors.Is(err, mysql.ErrInvalidPassword):
+		return newProblem(http.StatusUnauthorized, CodeInvalidCredentials, "Invalid credentials")
+	case errors.Is(err, mysql.ErrRefreshTokenNotFound),
+		errors.Is(err, mysql.ErrRefreshTokenExpired),
+		errors.Is(err, mysql.ErrRefreshTokenRevoked),
+		errors.Is(err, mysql.ErrRefreshTokenReused):
+		return newProblem(http.StatusUnauthorized, CodeInvalidRefreshToken, "Invalid refresh token")
+	case errors.Is(err, mysql.ErrRoleNotFound):
+		return newProblem(http.StatusNotFound, CodeRoleNotFound, "Role not found")
+	case errors.Is(err, mysql.ErrPermissionNotFound):
+		return newProblem(http.StatusNotFound, CodePermissionNotFound, "Permission not found")
+	case errors.Is(err, mysql.ErrRoleExists):
+		return newProblem(http.StatusConflict, CodeRoleExists, "Role already exists")
+	case errors.Is(err, mysql.ErrInvalidSortField):
+		return newProblem(http.StatusBadRequest, CodeInvalidRequest, "Invalid sort field")
+	case errors.Is(err, errInvalidCursor):
+		return newProblem(http.StatusBadRequest, CodeInvalidCursor, "Invalid cursor")
+	}
+
+	return newProblem(http.StatusInternalServerError, CodeInternal, "An internal error occurred")
+}
+
+// respondError aborts the request with the problem for err. Internal
+// errors are logged with the request they occurred in.
+func respondError(c *gin.Context, err error) {
+	p := problemFor(err)
+	if p.Status >= http.StatusInternalServerError {
+		ctx := c.Request.Context()
+		logging.FromContext(ctx).ErrorContext(ctx, "request failed", "method", c.Request.Method, "path", c.Request.URL.Path, "error", err)
+		trace.SpanFromContext(ctx).RecordError(err)
+	}
+	p.Instance = c.Request.URL.Path
+
+	c.Header("Content-Type", problemContentType)
+	c.AbortWithStatusJSON(p.Status, p)
+}
+
+// maxRequestBodySize is the size of the largest request body read in full
+const maxRequestBodySize = 64 << 10
+
+// readBody reads the request body, of up to maxRequestBodySize bytes,
+// and puts it back for the handlers after it. Failures are returned as
+// problems.
+func readBody(c *gin.Context) ([]byte, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxRequestBodySize))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return nil, bindProblem(err)
+	}
+	if err != nil {
+		return nil, newProblem(http.StatusBadRequest, CodeInvalidRequest, "Failed to read request body")
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// bindJSON binds the JSON request body into obj and validates it. On
+// failure it responds with the problem describing what is wrong with the
+// body and returns false.
+func bindJSON(c *gin.Context, obj any) bool {
+	if err := c.ShouldBindJSON(obj); err != nil {
+		respondError(c, bindProblem(err))
+		return false
+	}
+	return true
+}
+
+// bindProblem describes an error returned by decoding or validating a
+// request body
+func bindProblem(err error) *Problem {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return newProblem(http.StatusRequestEntityTooLarge, CodeRequestTooLarge, fmt.Sprintf("Request body must not be larger than %d bytes", maxBytesErr.Limit))
+	}
+
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		p := newProblem(http.StatusBadRequest, CodeValidationFailed, "Request validation failed")
+		for _, fe := range validationErrs {
+			p.Errors = append(p.Errors, FieldError{
+				Field:   fe.Field(),
+				Code:    fe.Tag(),
+				Message: validationMessage(fe),
+			})
+		}
+		return p
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		p := newProblem(http.StatusBadRequest, CodeValidationFailed, "Request validation failed")
+		p.Errors = []FieldError{{
+			Field:   typeErr.Field,
+			Code:    "type",
+			Message: "must be a " + typeErr.Type.String(),
+		}}
+		return p
+	}
+
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return newProblem(http.StatusBadRequest, CodeInvalidRequest, "Request body must be valid JSON")
+	}
+
+	if strings.HasPrefix(err.Error(), "json: unknown field ") {
+		p := newProblem(http.StatusBadRequest, CodeValidationFailed, "Request validation failed")
+		p.Errors = []FieldError{{
+			Field:   strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`),
+			Code:    "unknown",
+			Message: "is not a known field",
+		}}
+		return p
+	}
+
+	return newProblem(http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+}
+
+// validationMessage returns a human readable message for a failed rule
+func validationMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "min":
+		return fmt.Sprintf("must be at least %s characters long", fe.Param())
+	case "max":
+		return fmt.Sprintf("must be at most %s characters long", fe.Param())
+	}
+	return "is invalid"
+}
+
+func init() {
+	// Report fields by their JSON name rather than the Go field name
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(f reflect.StructField) string {
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				return ""
+			}
+			if name == "" {
+				return f.Name
+			}
+			return name
+		})
+	}
+}
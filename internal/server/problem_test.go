@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"golang-backend/internal/database"
+)
+
+// failingStore is a mysql.Service whose user operations fail with err
+type failingStore struct {
+	mysql.Service
+	err error
+}
+
+func (s *failingStore) GetUserByID(ctx context.Context, id int) (*mysql.User, error) {
+	return nil, s.err
+}
+
+func (s *failingStore) CreateUser(ctx context.Context, username, email, password string) (*mysql.User, error) {
+	return nil, s.err
+}
+
+func TestProblemResponses(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantCode   string
+		wantFields []string
+	}{
+		{"not found", mysql.ErrUserNotFound, "GET", "/users/1", "", http.StatusNotFound, CodeUserNotFound, nil},
+		{"internal error", errors.New("dial tcp 10.0.0.1:3306: connection refused"), "GET", "/users/1", "", http.StatusInternalServerError, CodeInternal, nil},
+		{"invalid id", nil, "GET", "/users/abc", "", http.StatusBadRequest, CodeInvalidRequest, nil},
+		{"duplicate email", mysql.ErrDuplicateEmail, "POST", "/users", `{"username":"john","email":"john@example.com","password":"secret123"}`, http.StatusConflict, CodeEmailTaken, nil},
+		{"validation", nil, "POST", "/users", `{"email":"not-an-email","password":"short"}`, http.StatusBadRequest, CodeValidationFailed, []string{"username", "email", "password"}},
+		{"wrong type", nil, "POST", "/users", `{"username":1}`, http.StatusBadRequest, CodeValidationFailed, []string{"username"}},
+		{"malformed", nil, "POST", "/users", `{`, http.StatusBadRequest, CodeInvalidRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{db: &failingStore{err: tt.err}}
+			r := gin.New()
+			r.GET("/users/:id", s.GetUserHandler)
+			r.POST("/users", s.CreateUserHandler)
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if ct := w.Header().Get("Content-Type"); ct != problemContentType {
+				t.Fatalf("expected Content-Type %q, got %q", problemContentType, ct)
+			}
+
+			var p Problem
+			if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+				t.Fatalf("failed to decode problem: %v", err)
+			}
+			if p.Code != tt.wantCode || p.Status != tt.wantStatus || p.Instance != tt.path {
+				t.Fatalf("unexpected problem %+v", p)
+			}
+
+			var fields []string
+			for _, fe := range p.Errors {
+				fields = append(fields, fe.Field)
+			}
+			if strings.Join(fields, ",") != strings.Join(tt.wantFields, ",") {
+				t.Fatalf("expected errors for %v, got %+v", tt.wantFields, p.Errors)
+			}
+
+			// Internal details are never returned
+			if strings.Contains(w.Body.String(), "10.0.0.1") {
+				t.Fatalf("response leaks internal error: %s", w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+// runUserPurger permanently removes users that have been soft-deleted for
+// longer than retention. It purges once immediately and then every
+// interval until ctx is cancelled.
+func (s *Server) runUserPurger(ctx context.Context, retention, interval time.Duration) {
+	s.workers.run(ctx, "user_purger", interval, func(ctx context.Context) {
+		purged, err := s.db.PurgeDeletedUsers(ctx, s.now().Add(-retention))
+		if err != nil && ctx.Err() == nil {
+			s.logger().ErrorContext(ctx, "failed to purge deleted users", "error", err)
+		} else if purged > 0 {
+			s.logger().InfoContext(ctx, "purged deleted users", "count", purged)
+		}
+	})
+}
+
+// runIdempotencyKeyPurger removes expired idempotency keys once
+// immediately and then every interval until ctx is cancelled
+func (s *Server) runIdempotencyKeyPurger(ctx context.Context, interval time.Duration) {
+	s.workers.run(ctx, "idempotency_key_purger", interval, func(ctx context.Context) {
+		if _, err := s.db.PurgeExpiredIdempotencyKeys(ctx, s.now()); err != nil && ctx.Err() == nil {
+			s.logger().ErrorContext(ctx, "failed to purge idempotency keys", "error", err)
+		}
+	})
+}
+
+// runEvery calls fn once immediately and then every interval until ctx is
+// cancelled
+func runEvery(ctx context.Context, interval time.Duration, fn func(ctx context.Context)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		fn(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
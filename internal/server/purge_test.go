@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang-backend/internal/database"
+)
+
+// purgeStore is a mysql.Service that records purge requests
+type purgeStore struct {
+	mysql.Service
+	cutoffs chan time.Time
+}
+
+func (s *purgeStore) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	s.cutoffs <- deletedBefore
+	return 0, nil
+}
+
+func (s *purgeStore) PurgeExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int64, error) {
+	return 0, nil
+}
+
+func TestUserPurger(t *testing.T) {
+	store := &purgeStore{cutoffs: make(chan time.Time, 10)}
+	s := &Server{db: store}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.runUserPurger(ctx, 24*time.Hour, 10*time.Millisecond)
+		close(done)
+	}()
+
+	// The first purge runs immediately, the next one after the interval
+	for i := 0; i < 2; i++ {
+		select {
+		case cutoff := <-store.cutoffs:
+			if age := time.Since(cutoff); age < 24*time.Hour || age > 25*time.Hour {
+				t.Fatalf("expected users deleted more than 24h ago to be purged, got cutoff %v ago", age)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("purge %d did not run", i+1)
+		}
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("purger did not stop after cancellation")
+	}
+}
@@ -1,46 +1,129 @@
 package server
 
 import (
+	"expvar"
 	"net/http"
 
 	"fmt"
-	"log"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 
 	"github.com/coder/websocket"
+
+	"golang-backend/internal/database"
 )
 
 func (s *Server) RegisterRoutes() http.Handler {
-	r := gin.Default()
-
-	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:5173"}, // Add your frontend URL
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
-		AllowHeaders:     []string{"Accept", "Authorization", "Content-Type"},
-		AllowCredentials: true, // Enable cookies/auth
+	r := gin.New()
+	r.Use(s.requestID(), s.trace(), s.accessLog(), s.instrument(), gin.CustomRecovery(func(c *gin.Context, recovered any) {
+		respondError(c, fmt.Errorf("panic: %v", recovered))
 	}))
+	r.NoRoute(func(c *gin.Context) {
+		respondError(c, newProblem(http.StatusNotFound, CodeNotFound, "No such route"))
+	})
+
+	if len(s.corsOrigins) > 0 {
+		r.Use(cors.New(cors.Config{
+			AllowOrigins:     s.corsOrigins,
+			AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
+			AllowHeaders:     []string{"Accept", "Authorization", "Content-Type", "If-Match", "If-None-Match", "Idempotency-Key", "X-Request-ID", "traceparent", "tracestate"},
+			ExposeHeaders:    []string{"ETag", "Deprecation", "Sunset", "Link", "Idempotent-Replayed", "Retry-After", "X-Request-ID", "traceparent"},
+			AllowCredentials: true, // Enable cookies/auth
+		}))
+	}
+
+	if s.validateRequests {
+		r.Use(s.validateOpenAPI())
+	}
 
 	r.GET("/", s.HelloWorldHandler)
 
+	// Probes, plus the endpoint that preceded them
+	r.GET("/livez", s.livezHandler)
+	r.GET("/readyz", s.readyzHandler)
+	r.GET("/startupz", s.startupzHandler)
 	r.GET("/health", s.healthHandler)
 
+	// Prometheus metrics
+	if s.metrics != nil {
+		r.GET("/metrics", gin.WrapH(s.metrics.Handler()))
+	}
+
 	r.GET("/websocket", s.websocketHandler)
 
+	// Versioned API, plus deprecated aliases such as the unversioned /api
+	api := r.Group(apiBasePath)
+	for _, v := range s.apiVersions() {
+		g := api.Group(v.prefix)
+		if v.deprecation != nil {
+			g.Use(deprecated(api.BasePath()+v.prefix, *v.deprecation))
+		}
+		v.register(g)
+	}
+
+	// GraphQL API over the same store, with an IDE outside production
+	r.POST("/graphql", s.optionalAuth(), s.graphQLHandler(s.newGraphQLSchema()))
+	if s.graphiQL {
+		r.GET("/graphiql", s.graphiQLHandler)
+	}
+
+	// Usage counters, including calls to deprecated routes
+	r.GET("/debug/vars", s.requireAuth(), s.requirePermission(mysql.PermRolesRead), gin.WrapH(expvar.Handler()))
+
+	// API description, generated from the routes registered above
+	r.GET("/openapi.json", s.openAPIHandler)
+	r.GET("/docs", s.docsHandler)
+	s.spec = s.openAPIDocument(r.Routes())
+
+	return r
+}
+
+// registerV1Routes registers the routes of version 1 of the API on g
+func (s *Server) registerV1Routes(g *gin.RouterGroup) {
+	// Auth routes
+	authGroup := g.Group("/auth")
+	{
+		authGroup.POST("/login", s.LoginHandler)     // Log in and get an access token
+		authGroup.POST("/refresh", s.RefreshHandler) // Rotate refresh token
+		authGroup.POST("/logout", s.LogoutHandler)   // Revoke refresh token family
+	}
+
 	// User routes
-	userGroup := r.Group("/api/users")
+	userGroup := g.Group("/users")
 	{
-		userGroup.POST("/", s.CreateUserHandler)           // Create user
-		userGroup.GET("/", s.GetAllUsersHandler)          // Get all users
-		userGroup.GET("/:id", s.GetUserHandler)           // Get user by ID
-		userGroup.PUT("/:id", s.UpdateUserHandler)        // Update user
-		userGroup.PATCH("/:id/password", s.UpdatePasswordHandler) // Update password
-		userGroup.DELETE("/:id", s.DeleteUserHandler)     // Delete user
+		userGroup.POST("/", s.idempotent(), s.CreateUserHandler) // Create user (sign up)
+
+		authed := userGroup.Group("", s.requireAuth())
+		authed.GET("/", s.requirePermission(mysql.PermUsersList), s.GetAllUsersHandler)                                // Get all users
+		authed.GET("/:id", s.requireSelfOr(mysql.PermUsersRead), s.GetUserHandler)                                     // Get user by ID
+		authed.PUT("/:id", s.requireSelfOr(mysql.PermUsersUpdate), s.UpdateUserHandler)                                // Update user
+		authed.PATCH("/:id", s.requireSelfOr(mysql.PermUsersUpdate), s.PatchUserHandler)                               // Partially update user
+		authed.PATCH("/:id/password", s.requireSelfOr(mysql.PermUsersUpdate), s.UpdatePasswordHandler)                 // Update password
+		authed.DELETE("/:id", s.requireSelfOr(mysql.PermUsersDelete), s.DeleteUserHandler)                             // Delete user
+		authed.POST("/:id/restore", s.requirePermission(mysql.PermUsersRestore), s.idempotent(), s.RestoreUserHandler) // Restore deleted user
 	}
 
-	return r
+	// Admin routes
+	adminGroup := g.Group("/admin", s.requireAuth())
+	{
+		read := s.requirePermission(mysql.PermRolesRead)
+		manage := s.requirePermission(mysql.PermRolesManage)
+		restore := s.requirePermission(mysql.PermUsersRestore)
+
+		adminGroup.GET("/roles", read, s.ListRolesHandler)                                           // List roles
+		adminGroup.POST("/roles", manage, s.idempotent(), s.CreateRoleHandler)                       // Create role
+		adminGroup.GET("/roles/:name", read, s.GetRoleHandler)                                       // Get role
+		adminGroup.DELETE("/roles/:name", manage, s.DeleteRoleHandler)                               // Delete role
+		adminGroup.PUT("/roles/:name/permissions/:permission", manage, s.GrantPermissionHandler)     // Grant permission
+		adminGroup.DELETE("/roles/:name/permissions/:permission", manage, s.RevokePermissionHandler) // Revoke permission
+		adminGroup.GET("/permissions", read, s.ListPermissionsHandler)                               // List permissions
+		adminGroup.GET("/users/deleted", restore, s.ListDeletedUsersHandler)                         // List deleted users
+		adminGroup.GET("/users/:id/roles", read, s.GetUserRolesHandler)                              // Get user roles
+		adminGroup.PUT("/users/:id/roles/:role", manage, s.AssignRoleHandler)                        // Assign role
+		adminGroup.DELETE("/users/:id/roles/:role", manage, s.UnassignRoleHandler)                   // Unassign role
+	}
 }
 
 func (s *Server) HelloWorldHandler(c *gin.Context) {
@@ -50,17 +133,13 @@ func (s *Server) HelloWorldHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, resp)
 }
 
-func (s *Server) healthHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, s.db.Health())
-}
-
 func (s *Server) websocketHandler(c *gin.Context) {
 	w := c.Writer
 	r := c.Request
 	socket, err := websocket.Accept(w, r, nil)
 
 	if err != nil {
-		log.Printf("could not open websocket: %v", err)
+		s.logger().WarnContext(r.Context(), "could not open websocket", "error", err)
 		_, _ = w.Write([]byte("could not open websocket"))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -68,6 +147,9 @@ func (s *Server) websocketHandler(c *gin.Context) {
 
 	defer socket.Close(websocket.StatusGoingAway, "server closing websocket")
 
+	s.metrics.WebsocketOpened()
+	defer s.metrics.WebsocketClosed()
+
 	ctx := r.Context()
 	socketCtx := socket.CloseRead(ctx)
 
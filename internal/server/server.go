@@ -1,39 +1,169 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
-	"os"
-	"strconv"
 	"time"
 
-	_ "github.com/joho/godotenv/autoload"
-
+	"golang-backend/internal/auth"
+	"golang-backend/internal/config"
 	"golang-backend/internal/database"
+	"golang-backend/internal/health"
+	"golang-backend/internal/metrics"
+	"golang-backend/internal/openapi"
 )
 
 type Server struct {
-	port int
+	port    int
+	log     *slog.Logger
+	metrics *metrics.Metrics // Optional
+	clock   func() time.Time // time.Now if nil, see now
+
+	db      mysql.Service
+	tokens  *auth.TokenManager
+	cursors *cursorCodec
+	events  *userEvents // Changes made through db, for UserService.Watch
+	workers *workers    // Background workers, for the liveness probe
+	health  *health.Registry
+
+	cfg               *config.Config
+	corsOrigins       []string      // Origins allowed to make cross-origin requests, none if empty
+	idempotencyKeyTTL time.Duration // How long responses to Idempotency-Key requests are replayed
+	fingerprintKey    []byte        // Keys the request fingerprints stored with idempotency keys
 
-	db mysql.Service
+	spec             *openapi.Document
+	validateRequests bool // Validate requests, and in test mode responses, against spec
+	graphiQL         bool // Serve the GraphiQL IDE at /graphiql
+
+	httpServer *http.Server
+	grpcServer *GRPCServer // Nil unless a gRPC port is configured
 }
 
-func NewServer() *http.Server {
-	port, _ := strconv.Atoi(os.Getenv("PORT"))
-	NewServer := &Server{
-		port: port,
+// Option configures a Server built by New
+type Option func(*Server)
 
-		db: mysql.New(),
+// WithStore sets the store the server reads and writes users from. It is
+// required.
+func WithStore(db mysql.Service) Option {
+	return func(s *Server) { s.db = db }
+}
+
+// WithConfig sets the configuration of the server, config.Default() if
+// not given. Only the HTTP, gRPC, Auth and Users sections and Env are
+// used; the store is configured by the caller.
+func WithConfig(cfg *config.Config) Option {
+	return func(s *Server) { s.cfg = cfg }
+}
+
+// WithLogger sets the logger of the server, slog.Default() if not given
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Server) { s.log = logger }
+}
+
+// WithClock sets the clock the server computes and checks expirations with,
+// including those of tokens and idempotency keys, time.Now if not given
+func WithClock(now func() time.Time) Option {
+	return func(s *Server) { s.clock = now }
+}
+
+// WithMetrics sets the metrics the server records requests to and serves
+// at /metrics. Without it, no metrics are recorded.
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(s *Server) { s.metrics = m }
+}
+
+// New returns a server configured by opts. Its HTTP server, and its gRPC
+// server if a gRPC port is configured, are ready to listen; background
+// workers only run once started with StartWorkers.
+func New(opts ...Option) (*Server, error) {
+	s := &Server{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.db == nil {
+		return nil, errors.New("server: no store, see WithStore")
 	}
+	if s.cfg == nil {
+		s.cfg = config.Default()
+	}
+	cfg := s.cfg
+
+	tokens, err := auth.NewTokenManager(auth.TokenConfig{
+		Algorithm:     cfg.Auth.JWTAlgorithm,
+		Secret:        []byte(cfg.Auth.JWTSecret),
+		PrivateKeyPEM: []byte(cfg.Auth.JWTPrivateKey),
+		Issuer:        cfg.Auth.JWTIssuer,
+		Audience:      cfg.Auth.JWTAudience,
+		AccessTTL:     cfg.Auth.AccessTTL,
+		RefreshTTL:    cfg.Auth.RefreshTTL,
+		Now:           s.now,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.HTTP.CursorSecret == "" {
+		s.logger().Warn("CURSOR_SECRET is not set, pagination cursors and idempotency keys will not survive restarts")
+	}
+	cursors, err := newCursorCodec(cfg.HTTP.CursorSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	s.events = newUserEvents()
+	s.db = &watchedStore{Service: s.db, events: s.events}
+	s.port = cfg.HTTP.Port
+	s.tokens = tokens
+	s.cursors = cursors
+	s.workers = newWorkers()
+	s.corsOrigins = cfg.HTTP.CORSOrigins
+	s.idempotencyKeyTTL = cfg.HTTP.IdempotencyKeyTTL
+	s.fingerprintKey = cursors.key
+	s.validateRequests = cfg.HTTP.OpenAPIValidate
+	s.graphiQL = !cfg.Production()
+	s.health = s.newHealthRegistry()
 
 	// Declare Server config
-	server := &http.Server{
-		Addr:         fmt.Sprintf(":%d", NewServer.port),
-		Handler:      NewServer.RegisterRoutes(),
+	s.httpServer = &http.Server{
+		Addr:         fmt.Sprintf(":%d", s.port),
+		Handler:      s.RegisterRoutes(),
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
 	}
 
-	return server
+	if cfg.GRPC.Port != 0 {
+		s.grpcServer = s.newGRPCServer(fmt.Sprintf(":%d", cfg.GRPC.Port))
+	}
+
+	return s, nil
+}
+
+// HTTPServer returns the HTTP server serving the API
+func (s *Server) HTTPServer() *http.Server {
+	return s.httpServer
+}
+
+// GRPCServer returns the gRPC server sharing the store of the HTTP API, or
+// nil if no gRPC port is configured
+func (s *Server) GRPCServer() *GRPCServer {
+	return s.grpcServer
+}
+
+// StartWorkers purges soft-deleted users and expired idempotency keys in
+// the background until ctx is cancelled
+func (s *Server) StartWorkers(ctx context.Context) {
+	go s.runUserPurger(ctx, s.cfg.Users.Retention, s.cfg.Users.PurgeInterval)
+	go s.runIdempotencyKeyPurger(ctx, s.cfg.Users.PurgeInterval)
+}
+
+// now returns the current time according to the clock of the server
+func (s *Server) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+	return s.clock()
 }
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang-backend/internal/config"
+)
+
+// testConfig returns a valid configuration for servers under test
+func testConfig() *config.Config {
+	cfg := config.Default()
+	cfg.Auth.JWTSecret = "0123456789abcdef0123456789abcdef"
+	cfg.HTTP.CursorSecret = "cursor-secret"
+	return cfg
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(WithConfig(testConfig())); err == nil || !strings.Contains(err.Error(), "WithStore") {
+		t.Fatalf("expected a missing store to be rejected, got %v", err)
+	}
+
+	cfg := testConfig()
+	cfg.Auth.JWTSecret = "short"
+	if _, err := New(WithStore(&purgeStore{}), WithConfig(cfg)); err == nil {
+		t.Fatal("expected an invalid JWT secret to be rejected")
+	}
+
+	// Servers are independent of each other
+	first, err := New(WithStore(&purgeStore{}), WithConfig(testConfig()))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	cfg = testConfig()
+	cfg.HTTP.Port = 9090
+	cfg.GRPC.Port = 9091
+	second, err := New(WithStore(&purgeStore{}), WithConfig(cfg))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	if first.HTTPServer().Addr != ":8080" || second.HTTPServer().Addr != ":9090" {
+		t.Errorf("expected each server to listen on its own port, got %s and %s", first.HTTPServer().Addr, second.HTTPServer().Addr)
+	}
+	if first.GRPCServer() != nil || second.GRPCServer() == nil || second.GRPCServer().Addr != ":9091" {
+		t.Errorf("expected only the second server to serve gRPC, got %v and %v", first.GRPCServer(), second.GRPCServer())
+	}
+
+	rec := httptest.NewRecorder()
+	first.HTTPServer().Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected the routes to be registered, got %d", rec.Code)
+	}
+}
+
+func TestWithClock(t *testing.T) {
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := &purgeStore{cutoffs: make(chan time.Time, 10)}
+	s, err := New(WithStore(store), WithConfig(testConfig()), WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.StartWorkers(ctx)
+
+	select {
+	case cutoff := <-store.cutoffs:
+		if want := now.Add(-testConfig().Users.Retention); !cutoff.Equal(want) {
+			t.Errorf("expected cutoff %v from the injected clock, got %v", want, cutoff)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("purge did not run")
+	}
+
+	// Access tokens expire by the same clock
+	_, expiresAt, err := s.tokens.IssueAccessToken(1, "user", nil)
+	if err != nil {
+		t.Fatalf("failed to issue token: %v", err)
+	}
+	if want := now.Add(testConfig().Auth.AccessTTL); !expiresAt.Equal(want) {
+		t.Errorf("expected the token to expire at %v, got %v", want, expiresAt)
+	}
+	token, _, err := newTestTokenManager(t).IssueAccessToken(1, "user", nil)
+	if err != nil {
+		t.Fatalf("failed to issue token: %v", err)
+	}
+	if _, err := s.tokens.ParseAccessToken(token); err == nil {
+		t.Error("expected a token issued now to have expired by the injected clock")
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+	defer otel.SetTextMapPropagator(otel.GetTextMapPropagator())
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	s := &Server{}
+	h := s.RegisterRoutes()
+
+	// Incoming trace context is continued and returned
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if got := w.Header().Get("traceparent"); !strings.HasPrefix(got, "00-"+traceID+"-") {
+		t.Errorf("expected the traceparent of trace %s, got %q", traceID, got)
+	}
+
+	// Spans are named after the route template
+	req = httptest.NewRequest("GET", "/api/v1/users/42", nil)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	if spans[0].Name() != "GET /" || spans[0].SpanContext().TraceID().String() != traceID || spans[0].Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("expected GET / in trace %s, got %s in %s", traceID, spans[0].Name(), spans[0].SpanContext().TraceID())
+	}
+	if spans[1].Name() != "GET /api/v1/users/:id" || spans[1].Parent().IsValid() {
+		t.Errorf("expected a root span GET /api/v1/users/:id, got %s", spans[1].Name())
+	}
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range spans[1].Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	if attrs["http.route"].AsString() != "/api/v1/users/:id" || attrs["http.response.status_code"].AsInt64() != http.StatusUnauthorized || attrs["request_id"].AsString() == "" {
+		t.Errorf("unexpected span attributes %v", attrs)
+	}
+	if spans[1].Status().Code == codes.Error {
+		t.Error("expected client errors not to mark the span as failed")
+	}
+}
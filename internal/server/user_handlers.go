@@ -1,10 +1,22 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
+	jsonpatch "github.com/evanphx/json-patch/v5"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+
+	"golang-backend/internal/database"
 )
 
 // UserRequest represents the request body for user operations
@@ -28,36 +40,14 @@ type UpdatePasswordRequest struct {
 // CreateUserHandler handles user creation
 func (s *Server) CreateUserHandler(c *gin.Context) {
 	var req UserRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid request data: " + err.Error(),
-		})
+	if !bindJSON(c, &req) {
 		return
 	}
 
-	// Check if user already exists
-	existingUser, _ := s.db.GetUserByEmail(c.Request.Context(), req.Email)
-	if existingUser != nil {
-		c.JSON(http.StatusConflict, gin.H{
-			"error": "User with this email already exists",
-		})
-		return
-	}
-
-	existingUser, _ = s.db.GetUserByUsername(c.Request.Context(), req.Username)
-	if existingUser != nil {
-		c.JSON(http.StatusConflict, gin.H{
-			"error": "User with this username already exists",
-		})
-		return
-	}
-
-	// Create user
+	// Create user, relying on the unique keys to reject duplicates
 	user, err := s.db.CreateUser(c.Request.Context(), req.Username, req.Email, req.Password)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to create user: " + err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
@@ -72,17 +62,20 @@ func (s *Server) GetUserHandler(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid user ID",
-		})
+		respondError(c, newProblem(http.StatusBadRequest, CodeInvalidRequest, "Invalid user ID"))
 		return
 	}
 
 	user, err := s.db.GetUserByID(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": "User not found",
-		})
+		respondError(c, err)
+		return
+	}
+
+	etag := userETag(user)
+	c.Header("ETag", etag)
+	if etagMatches(c.GetHeader("If-None-Match"), etag, true) {
+		c.Status(http.StatusNotModified)
 		return
 	}
 
@@ -91,80 +84,318 @@ func (s *Server) GetUserHandler(c *gin.Context) {
 	})
 }
 
-// GetAllUsersHandler handles getting all users
+// Page sizes for listing users
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// Pagination describes how to fetch the pages around the returned one
+type Pagination struct {
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+	Next       string `json:"next,omitempty"` // Link to the next page
+	Prev       string `json:"prev,omitempty"` // Link to the previous page
+}
+
+// userCursor is the payload of a user listing cursor token
+type userCursor struct {
+	Direction string `json:"d"` // "next" or "prev"
+	Sort      string `json:"s"` // Sort the cursor was issued for, e.g. "-created_at"
+	Value     string `json:"v"`
+	ID        int    `json:"i"`
+}
+
+// listUsersQueryParams are the query parameters accepted when listing users
+var listUsersQueryParams = []string{
+	"limit", "cursor", "sort", "q", "username", "email",
+	"created_after", "created_before", "updated_after", "updated_before",
+}
+
+// GetAllUsersHandler handles listing users one page at a time.
+// Users can be filtered by username/email prefix and date ranges and
+// sorted by any field in mysql.IsUserSortField.
 func (s *Server) GetAllUsersHandler(c *gin.Context) {
-	users, err := s.db.GetAllUsers(c.Request.Context())
+	s.listUsers(c, s.db.ListUsers)
+}
+
+// ListDeletedUsersHandler handles listing soft-deleted users. It accepts
+// the same query parameters as GetAllUsersHandler.
+func (s *Server) ListDeletedUsersHandler(c *gin.Context) {
+	s.listUsers(c, s.db.ListDeletedUsers)
+}
+
+// listUsers responds with the page of users returned by list for the
+// request's query parameters
+func (s *Server) listUsers(c *gin.Context, list func(context.Context, mysql.ListUsersParams) (*mysql.UserPage, error)) {
+	q, err := s.parseListUsersQuery(c.Request.URL.Query())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to get users: " + err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
+	page, err := list(c.Request.Context(), q.params)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	pagination := Pagination{Limit: q.limit}
+	if n := len(page.Users); n > 0 {
+		if page.HasNext {
+			pagination.NextCursor, pagination.Next, err = s.pageLink(c, "next", q.sort, page.Users[n-1], q.limit)
+		}
+		if err == nil && page.HasPrev {
+			pagination.PrevCursor, pagination.Prev, err = s.pageLink(c, "prev", q.sort, page.Users[0], q.limit)
+		}
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"users": users,
+		"users":      page.Users,
+		"pagination": pagination,
 	})
 }
 
+// listUsersQuery is a validated user listing request
+type listUsersQuery struct {
+	params mysql.ListUsersParams
+	sort   string // As requested, e.g. "-created_at"
+	limit  int
+}
+
+// parseListUsersQuery validates the parameters of a user listing, given
+// as the query parameters in listUsersQueryParams
+func (s *Server) parseListUsersQuery(query url.Values) (*listUsersQuery, error) {
+	for key := range query {
+		if !slices.Contains(listUsersQueryParams, key) {
+			return nil, newProblem(http.StatusBadRequest, CodeInvalidRequest, "Unknown query parameter: "+key)
+		}
+	}
+
+	limit := defaultPageLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, newProblem(http.StatusBadRequest, CodeInvalidRequest, "Invalid limit")
+		}
+		limit = min(n, maxPageLimit)
+	}
+
+	sortParam := query.Get("sort")
+	if sortParam == "" {
+		sortParam = "-" + mysql.DefaultUserSort.Field
+	}
+	sort := mysql.UserSort{Field: strings.TrimPrefix(sortParam, "-"), Desc: strings.HasPrefix(sortParam, "-")}
+	if !mysql.IsUserSortField(sort.Field) {
+		return nil, newProblem(http.StatusBadRequest, CodeInvalidRequest, "Invalid sort field: "+sort.Field)
+	}
+
+	filter := mysql.UserFilter{
+		UsernamePrefix: query.Get("username"),
+		EmailPrefix:    query.Get("email"),
+		Search:         query.Get("q"),
+	}
+	ranges := map[string]**time.Time{
+		"created_after":  &filter.CreatedAfter,
+		"created_before": &filter.CreatedBefore,
+		"updated_after":  &filter.UpdatedAfter,
+		"updated_before": &filter.UpdatedBefore,
+	}
+	for key, dst := range ranges {
+		v := query.Get(key)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, newProblem(http.StatusBadRequest, CodeInvalidRequest, "Invalid "+key+": expected an RFC 3339 timestamp")
+		}
+		*dst = &t
+	}
+
+	params := mysql.ListUsersParams{Limit: limit, Filter: filter, Sort: sort}
+	if token := query.Get("cursor"); token != "" {
+		var cur userCursor
+		err := s.cursors.decode(token, &cur)
+		if err != nil || (cur.Direction != "next" && cur.Direction != "prev") || cur.Sort != sortParam {
+			return nil, errInvalidCursor
+		}
+
+		pos := &mysql.UserCursor{Value: cur.Value, ID: cur.ID}
+		if cur.Direction == "prev" {
+			params.Before = pos
+		} else {
+			params.After = pos
+		}
+	}
+
+	return &listUsersQuery{params: params, sort: sortParam, limit: limit}, nil
+}
+
+// pageCursor returns the cursor token continuing from user in direction
+func (s *Server) pageCursor(direction, sort string, user *mysql.User) (string, error) {
+	pos := mysql.CursorOf(user, strings.TrimPrefix(sort, "-"))
+	return s.cursors.encode(userCursor{Direction: direction, Sort: sort, Value: pos.Value, ID: pos.ID})
+}
+
+// pageLink returns the cursor token continuing from user in direction and
+// the link to the corresponding page. The link keeps the request's filters.
+func (s *Server) pageLink(c *gin.Context, direction, sort string, user *mysql.User, limit int) (string, string, error) {
+	token, err := s.pageCursor(direction, sort, user)
+	if err != nil {
+		return "", "", err
+	}
+
+	u := *c.Request.URL
+	q := u.Query()
+	q.Set("cursor", token)
+	q.Set("limit", strconv.Itoa(limit))
+	u.RawQuery = q.Encode()
+
+	return token, u.RequestURI(), nil
+}
+
 // UpdateUserHandler handles updating user information
 func (s *Server) UpdateUserHandler(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid user ID",
-		})
+		respondError(c, newProblem(http.StatusBadRequest, CodeInvalidRequest, "Invalid user ID"))
 		return
 	}
 
 	var req UpdateUserRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid request data: " + err.Error(),
-		})
+	if !bindJSON(c, &req) {
 		return
 	}
 
-	// Check if user exists
-	existingUser, err := s.db.GetUserByID(c.Request.Context(), id)
+	current, err := s.db.GetUserByID(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": "User not found",
-		})
+		respondError(c, err)
 		return
 	}
 
-	// Check if new email is already taken by another user
-	if req.Email != existingUser.Email {
-		userWithEmail, _ := s.db.GetUserByEmail(c.Request.Context(), req.Email)
-		if userWithEmail != nil && userWithEmail.ID != id {
-			c.JSON(http.StatusConflict, gin.H{
-				"error": "Email already taken by another user",
-			})
-			return
-		}
+	if !checkIfMatch(c, current) {
+		return
 	}
 
-	// Check if new username is already taken by another user
-	if req.Username != existingUser.Username {
-		userWithUsername, _ := s.db.GetUserByUsername(c.Request.Context(), req.Username)
-		if userWithUsername != nil && userWithUsername.ID != id {
-			c.JSON(http.StatusConflict, gin.H{
-				"error": "Username already taken by another user",
-			})
+	// Update user, relying on the unique keys to reject duplicates and on
+	// the version to reject writes racing with ours
+	user, err := s.db.UpdateUser(c.Request.Context(), id, current.Version, req.Username, req.Email)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.Header("ETag", userETag(user))
+	c.JSON(http.StatusOK, gin.H{
+		"message": "User updated successfully",
+		"user":    user,
+	})
+}
+
+// Media types accepted by PatchUserHandler
+const (
+	mergePatchContentType = "application/merge-patch+json"
+	jsonPatchContentType  = "application/json-patch+json"
+)
+
+// PatchUserHandler handles partially updating user information with a JSON
+// Merge Patch (RFC 7396) or a JSON Patch (RFC 6902). The patch is applied
+// to {"username": ..., "email": ...} and the result must satisfy the same
+// rules as UpdateUserRequest. Only the fields that change are written.
+func (s *Server) PatchUserHandler(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		respondError(c, newProblem(http.StatusBadRequest, CodeInvalidRequest, "Invalid user ID"))
+		return
+	}
+
+	contentType := c.ContentType()
+	if contentType != mergePatchContentType && contentType != jsonPatchContentType {
+		c.Header("Accept-Patch", mergePatchContentType+", "+jsonPatchContentType)
+		respondError(c, newProblem(http.StatusUnsupportedMediaType, CodeUnsupportedMediaType,
+			"Content-Type must be "+mergePatchContentType+" or "+jsonPatchContentType))
+		return
+	}
+
+	patch, err := readBody(c)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	current, err := s.db.GetUserByID(c.Request.Context(), id)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	if !checkIfMatch(c, current) {
+		return
+	}
+
+	doc, err := json.Marshal(UpdateUserRequest{Username: current.Username, Email: current.Email})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	var patched []byte
+	if contentType == mergePatchContentType {
+		patched, err = jsonpatch.MergePatch(doc, patch)
+		if err != nil {
+			respondError(c, newProblem(http.StatusBadRequest, CodeInvalidRequest, "Invalid merge patch: "+err.Error()))
 			return
 		}
+	} else {
+		ops, err := jsonpatch.DecodePatch(patch)
+		if err != nil {
+			respondError(c, newProblem(http.StatusBadRequest, CodeInvalidRequest, "Invalid JSON patch: "+err.Error()))
+			return
+		}
+		patched, err = ops.Apply(doc)
+		if err != nil {
+			// The patch is well-formed but cannot be applied, e.g. a failed
+			// "test" operation or a path that does not exist
+			respondError(c, newProblem(http.StatusUnprocessableEntity, CodePatchNotApplicable, "Failed to apply JSON patch: "+err.Error()))
+			return
+		}
+	}
+
+	var req UpdateUserRequest
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		respondError(c, bindProblem(err))
+		return
+	}
+	if err := binding.Validator.ValidateStruct(&req); err != nil {
+		respondError(c, bindProblem(err))
+		return
 	}
 
-	// Update user
-	user, err := s.db.UpdateUser(c.Request.Context(), id, req.Username, req.Email)
+	var changes mysql.UserChanges
+	if req.Username != current.Username {
+		changes.Username = &req.Username
+	}
+	if req.Email != current.Email {
+		changes.Email = &req.Email
+	}
+
+	user, err := s.db.PatchUser(c.Request.Context(), id, current.Version, changes)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to update user: " + err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
+	c.Header("ETag", userETag(user))
 	c.JSON(http.StatusOK, gin.H{
 		"message": "User updated successfully",
 		"user":    user,
@@ -176,38 +407,37 @@ func (s *Server) UpdatePasswordHandler(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid user ID",
-		})
+		respondError(c, newProblem(http.StatusBadRequest, CodeInvalidRequest, "Invalid user ID"))
 		return
 	}
 
 	var req UpdatePasswordRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid request data: " + err.Error(),
-		})
+	if !bindJSON(c, &req) {
 		return
 	}
 
 	// Check if user exists
-	_, err = s.db.GetUserByID(c.Request.Context(), id)
+	user, err := s.db.GetUserByID(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": "User not found",
-		})
+		respondError(c, err)
+		return
+	}
+
+	if !checkIfMatch(c, user) {
 		return
 	}
 
 	// Update password
-	err = s.db.UpdateUserPassword(c.Request.Context(), id, req.Password)
+	err = s.db.UpdateUserPassword(c.Request.Context(), id, user.Version, req.Password)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to update password: " + err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
+	// The conditional update moved the user exactly one version forward
+	user.Version++
+	c.Header("ETag", userETag(user))
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Password updated successfully",
 	})
@@ -218,17 +448,13 @@ func (s *Server) DeleteUserHandler(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid user ID",
-		})
+		respondError(c, newProblem(http.StatusBadRequest, CodeInvalidRequest, "Invalid user ID"))
 		return
 	}
 
 	err = s.db.DeleteUser(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": "User not found",
-		})
+		respondError(c, err)
 		return
 	}
 
@@ -236,3 +462,28 @@ func (s *Server) DeleteUserHandler(c *gin.Context) {
 		"message": "User deleted successfully",
 	})
 }
+
+// RestoreUserHandler handles restoring a soft-deleted user
+func (s *Server) RestoreUserHandler(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		respondError(c, newProblem(http.StatusBadRequest, CodeInvalidRequest, "Invalid user ID"))
+		return
+	}
+
+	user, err := s.db.RestoreUser(c.Request.Context(), id)
+	if errors.Is(err, mysql.ErrUserNotFound) {
+		respondError(c, newProblem(http.StatusNotFound, CodeUserNotFound, "Deleted user not found"))
+		return
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "User restored successfully",
+		"user":    user,
+	})
+}
@@ -0,0 +1,417 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"golang-backend/internal/auth"
+	"golang-backend/internal/database"
+)
+
+// userAPI is a server backed by the in-memory store, with an admin and a
+// regular user to make requests as
+type userAPI struct {
+	t          *testing.T
+	store      mysql.Service
+	handler    http.Handler
+	admin      *mysql.User
+	adminToken string
+	user       *mysql.User
+	userToken  string
+}
+
+func newUserAPI(t *testing.T) *userAPI {
+	t.Helper()
+	store := mysql.NewMemory(&auth.BcryptHasher{Cost: bcrypt.MinCost})
+	s, err := New(WithStore(store), WithConfig(testConfig()))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	api := &userAPI{t: t, store: store, handler: s.HTTPServer().Handler}
+	api.admin, api.adminToken = api.createUser(s, "admin", mysql.RoleAdmin)
+	api.user, api.userToken = api.createUser(s, "user")
+	return api
+}
+
+// createUser creates a user with roles and returns it with an access token
+func (api *userAPI) createUser(s *Server, username string, roles ...string) (*mysql.User, string) {
+	api.t.Helper()
+	ctx := context.Background()
+	user, err := api.store.CreateUser(ctx, username, username+"@example.com", "password123")
+	if err != nil {
+		api.t.Fatalf("failed to create user: %v", err)
+	}
+	for _, role := range roles {
+		if err := api.store.AssignRole(ctx, user.ID, role); err != nil {
+			api.t.Fatalf("failed to assign role: %v", err)
+		}
+	}
+	token, _, err := s.tokens.IssueAccessToken(user.ID, user.Username, roles)
+	if err != nil {
+		api.t.Fatalf("failed to issue token: %v", err)
+	}
+	return user, token
+}
+
+// do makes a request with token, if any, and headers given as name/value
+// pairs. Bodies are sent as JSON unless a Content-Type header is given.
+func (api *userAPI) do(method, path, token, body string, headers ...string) *httptest.ResponseRecorder {
+	api.t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	api.handler.ServeHTTP(w, req)
+	return w
+}
+
+// expect fails unless w has status, and for errors, the problem code
+func expect(t *testing.T, w *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("expected status %d, got %d: %s", status, w.Code, w.Body.String())
+	}
+	if code == "" {
+		return
+	}
+	var problem Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil || problem.Code != code {
+		t.Fatalf("expected problem %q, got %s", code, w.Body.String())
+	}
+}
+
+// decodeUser returns the "user" member of the body of w
+func decodeUser(t *testing.T, w *httptest.ResponseRecorder) *mysql.User {
+	t.Helper()
+	var body struct {
+		User *mysql.User `json:"user"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.User == nil {
+		t.Fatalf("expected a user, got %s", w.Body.String())
+	}
+	return body.User
+}
+
+func TestCreateUserHandler(t *testing.T) {
+	api := newUserAPI(t)
+
+	w := api.do("POST", "/api/v1/users/", "", `{"username":"alice","email":"alice@example.com","password":"secret123"}`)
+	expect(t, w, http.StatusCreated, "")
+	if strings.Contains(w.Body.String(), "password") {
+		t.Errorf("expected the password to be left out, got %s", w.Body.String())
+	}
+	created := decodeUser(t, w)
+	if created.Username != "alice" || created.Email != "alice@example.com" {
+		t.Errorf("unexpected user %+v", created)
+	}
+
+	stored, err := api.store.GetUserByUsername(context.Background(), "alice")
+	if err != nil {
+		t.Fatalf("expected the user to be stored: %v", err)
+	}
+	if err := api.store.VerifyPassword(context.Background(), stored, "secret123"); err != nil {
+		t.Errorf("expected the password to be set: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		code   string
+	}{
+		{"duplicate username", `{"username":"ALICE","email":"other@example.com","password":"secret123"}`, http.StatusConflict, CodeUsernameTaken},
+		{"duplicate email", `{"username":"bob","email":"Alice@example.com","password":"secret123"}`, http.StatusConflict, CodeEmailTaken},
+		{"invalid email", `{"username":"bob","email":"bob","password":"secret123"}`, http.StatusBadRequest, CodeValidationFailed},
+		{"short password", `{"username":"bob","email":"bob@example.com","password":"123"}`, http.StatusBadRequest, CodeValidationFailed},
+		{"malformed", `{`, http.StatusBadRequest, CodeInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expect(t, api.do("POST", "/api/v1/users/", "", tt.body), tt.status, tt.code)
+		})
+	}
+}
+
+func TestGetUserHandler(t *testing.T) {
+	api := newUserAPI(t)
+	path := "/api/v1/users/" + strconv.Itoa(api.user.ID)
+
+	w := api.do("GET", path, api.userToken, "")
+	expect(t, w, http.StatusOK, "")
+	if got := decodeUser(t, w); got.ID != api.user.ID || got.Username != "user" {
+		t.Errorf("unexpected user %+v", got)
+	}
+	etag := w.Header().Get("ETag")
+	if etag != `"1"` {
+		t.Errorf("expected ETag %q, got %q", `"1"`, etag)
+	}
+
+	w = api.do("GET", path, api.userToken, "", "If-None-Match", etag)
+	expect(t, w, http.StatusNotModified, "")
+	if w.Body.Len() != 0 {
+		t.Errorf("expected no body, got %s", w.Body.String())
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		token  string
+		status int
+		code   string
+	}{
+		{"as admin", path, api.adminToken, http.StatusOK, ""},
+		{"other user", "/api/v1/users/" + strconv.Itoa(api.admin.ID), api.userToken, http.StatusForbidden, CodeForbidden},
+		{"unauthenticated", path, "", http.StatusUnauthorized, CodeUnauthenticated},
+		{"unknown user", "/api/v1/users/999", api.adminToken, http.StatusNotFound, CodeUserNotFound},
+		{"invalid ID", "/api/v1/users/abc", api.adminToken, http.StatusBadRequest, CodeInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expect(t, api.do("GET", tt.path, tt.token, ""), tt.status, tt.code)
+		})
+	}
+}
+
+// listPage is the body of a user listing
+type listPage struct {
+	Users      []*mysql.User `json:"users"`
+	Pagination Pagination    `json:"pagination"`
+}
+
+func (api *userAPI) list(path, token string) listPage {
+	api.t.Helper()
+	w := api.do("GET", path, token, "")
+	expect(api.t, w, http.StatusOK, "")
+	var page listPage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		api.t.Fatalf("failed to decode page: %v", err)
+	}
+	return page
+}
+
+func pageUsernames(page listPage) []string {
+	names := make([]string, len(page.Users))
+	for i, u := range page.Users {
+		names[i] = u.Username
+	}
+	return names
+}
+
+func TestListUsersHandler(t *testing.T) {
+	api := newUserAPI(t)
+	for _, name := range []string{"list_c", "list_a", "list_e", "list_b", "list_d"} {
+		if _, err := api.store.CreateUser(context.Background(), name, name+"@example.com", "password123"); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+
+	// Follow the links forward, then back
+	first := api.list("/api/v1/users/?username=list_&sort=username&limit=2", api.adminToken)
+	if got := pageUsernames(first); !slices.Equal(got, []string{"list_a", "list_b"}) {
+		t.Fatalf("unexpected first page %v", got)
+	}
+	if first.Pagination.Limit != 2 || first.Pagination.Next == "" || first.Pagination.Prev != "" {
+		t.Fatalf("unexpected pagination %+v", first.Pagination)
+	}
+	next, err := url.Parse(first.Pagination.Next)
+	if err != nil || next.Query().Get("username") != "list_" || next.Query().Get("sort") != "username" {
+		t.Errorf("expected the link to keep the filters, got %s", first.Pagination.Next)
+	}
+
+	second := api.list(first.Pagination.Next, api.adminToken)
+	if got := pageUsernames(second); !slices.Equal(got, []string{"list_c", "list_d"}) {
+		t.Fatalf("unexpected second page %v", got)
+	}
+	last := api.list(second.Pagination.Next, api.adminToken)
+	if got := pageUsernames(last); !slices.Equal(got, []string{"list_e"}) || last.Pagination.Next != "" {
+		t.Fatalf("unexpected last page %v, %+v", got, last.Pagination)
+	}
+	back := api.list(last.Pagination.Prev, api.adminToken)
+	if got := pageUsernames(back); !slices.Equal(got, []string{"list_c", "list_d"}) {
+		t.Fatalf("unexpected previous page %v", got)
+	}
+
+	// Filters and sorting
+	filtered := []struct {
+		name string
+		path string
+		want []string
+	}{
+		{"descending", "/api/v1/users/?username=list_&sort=-username&limit=2", []string{"list_e", "list_d"}},
+		{"prefix in another case", "/api/v1/users/?username=LIST_A", []string{"list_a"}},
+		{"email", "/api/v1/users/?email=list_b@", []string{"list_b"}},
+		{"search", "/api/v1/users/?q=list_c&sort=id", []string{"list_c"}},
+		{"created after", "/api/v1/users/?username=list_&created_after=2100-01-01T00:00:00Z", []string{}},
+		{"default sort", "/api/v1/users/?username=list_", []string{"list_d", "list_b", "list_e", "list_a", "list_c"}},
+	}
+	for _, tt := range filtered {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pageUsernames(api.list(tt.path, api.adminToken)); !slices.Equal(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	// A cursor is only valid for the sort it was issued for
+	next.RawQuery = strings.Replace(next.RawQuery, "sort=username", "sort=email", 1)
+	invalid := []struct {
+		name   string
+		path   string
+		token  string
+		status int
+		code   string
+	}{
+		{"cursor for another sort", next.RequestURI(), api.adminToken, http.StatusBadRequest, CodeInvalidCursor},
+		{"tampered cursor", "/api/v1/users/?cursor=abc", api.adminToken, http.StatusBadRequest, CodeInvalidCursor},
+		{"unknown parameter", "/api/v1/users/?page=2", api.adminToken, http.StatusBadRequest, CodeInvalidRequest},
+		{"invalid sort", "/api/v1/users/?sort=password", api.adminToken, http.StatusBadRequest, CodeInvalidRequest},
+		{"invalid limit", "/api/v1/users/?limit=0", api.adminToken, http.StatusBadRequest, CodeInvalidRequest},
+		{"invalid time", "/api/v1/users/?created_after=yesterday", api.adminToken, http.StatusBadRequest, CodeInvalidRequest},
+		{"missing permission", "/api/v1/users/", api.userToken, http.StatusForbidden, CodeForbidden},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			expect(t, api.do("GET", tt.path, tt.token, ""), tt.status, tt.code)
+		})
+	}
+}
+
+func TestUpdateUserHandler(t *testing.T) {
+	api := newUserAPI(t)
+	path := "/api/v1/users/" + strconv.Itoa(api.user.ID)
+	body := `{"username":"renamed","email":"renamed@example.com"}`
+
+	expect(t, api.do("PUT", path, api.userToken, body), http.StatusPreconditionRequired, CodePreconditionRequired)
+	expect(t, api.do("PUT", path, api.userToken, body, "If-Match", `"2"`), http.StatusPreconditionFailed, CodeVersionConflict)
+	expect(t, api.do("PUT", path, api.userToken, `{"username":"admin","email":"renamed@example.com"}`, "If-Match", `"1"`), http.StatusConflict, CodeUsernameTaken)
+	expect(t, api.do("PUT", path, api.userToken, `{"username":"renamed"}`, "If-Match", `"1"`), http.StatusBadRequest, CodeValidationFailed)
+	expect(t, api.do("PUT", "/api/v1/users/999", api.adminToken, body, "If-Match", `"1"`), http.StatusNotFound, CodeUserNotFound)
+
+	w := api.do("PUT", path, api.userToken, body, "If-Match", `"1"`)
+	expect(t, w, http.StatusOK, "")
+	if got := decodeUser(t, w); got.Username != "renamed" || got.Email != "renamed@example.com" {
+		t.Errorf("unexpected user %+v", got)
+	}
+	if got := w.Header().Get("ETag"); got != `"2"` {
+		t.Errorf("expected ETag %q, got %q", `"2"`, got)
+	}
+
+	stored, err := api.store.GetUserByID(context.Background(), api.user.ID)
+	if err != nil || stored.Username != "renamed" || stored.Version != 2 {
+		t.Errorf("expected the update to be stored, got %+v, %v", stored, err)
+	}
+}
+
+func TestPatchUserHandlerWithStore(t *testing.T) {
+	api := newUserAPI(t)
+	path := "/api/v1/users/" + strconv.Itoa(api.user.ID)
+
+	w := api.do("PATCH", path, api.userToken, `{"email":"patched@example.com"}`,
+		"Content-Type", mergePatchContentType, "If-Match", `"1"`)
+	expect(t, w, http.StatusOK, "")
+	if got := decodeUser(t, w); got.Username != "user" || got.Email != "patched@example.com" {
+		t.Errorf("unexpected user %+v", got)
+	}
+
+	w = api.do("PATCH", path, api.userToken, `[{"op":"replace","path":"/username","value":"patched"}]`,
+		"Content-Type", jsonPatchContentType, "If-Match", w.Header().Get("ETag"))
+	expect(t, w, http.StatusOK, "")
+	if got := w.Header().Get("ETag"); got != `"3"` {
+		t.Errorf("expected ETag %q, got %q", `"3"`, got)
+	}
+
+	stored, err := api.store.GetUserByID(context.Background(), api.user.ID)
+	if err != nil || stored.Username != "patched" || stored.Email != "patched@example.com" {
+		t.Errorf("expected both patches to be stored, got %+v, %v", stored, err)
+	}
+
+	expect(t, api.do("PATCH", path, api.userToken, `{"email":"admin@example.com"}`,
+		"Content-Type", mergePatchContentType, "If-Match", `"3"`), http.StatusConflict, CodeEmailTaken)
+	expect(t, api.do("PATCH", path, api.userToken, `{"email":"other@example.com"}`,
+		"Content-Type", mergePatchContentType, "If-Match", `"1"`), http.StatusPreconditionFailed, CodeVersionConflict)
+	expect(t, api.do("PATCH", path, api.userToken, `{"email":"other@example.com"}`,
+		"If-Match", `"3"`), http.StatusUnsupportedMediaType, CodeUnsupportedMediaType)
+
+	large := `{"email":"other@example.com","padding":"` + strings.Repeat("x", maxRequestBodySize) + `"}`
+	expect(t, api.do("PATCH", path, api.userToken, large,
+		"Content-Type", mergePatchContentType, "If-Match", `"3"`), http.StatusRequestEntityTooLarge, CodeRequestTooLarge)
+}
+
+func TestUpdatePasswordHandler(t *testing.T) {
+	api := newUserAPI(t)
+	path := "/api/v1/users/" + strconv.Itoa(api.user.ID) + "/password"
+
+	expect(t, api.do("PATCH", path, api.userToken, `{"password":"123"}`, "If-Match", `"1"`), http.StatusBadRequest, CodeValidationFailed)
+	expect(t, api.do("PATCH", path, api.userToken, `{"password":"newsecret"}`), http.StatusPreconditionRequired, CodePreconditionRequired)
+	expect(t, api.do("PATCH", path, api.userToken, `{"password":"newsecret"}`, "If-Match", `"5"`), http.StatusPreconditionFailed, CodeVersionConflict)
+
+	w := api.do("PATCH", path, api.userToken, `{"password":"newsecret"}`, "If-Match", `"1"`)
+	expect(t, w, http.StatusOK, "")
+	if got := w.Header().Get("ETag"); got != `"2"` {
+		t.Errorf("expected ETag %q, got %q", `"2"`, got)
+	}
+
+	stored, err := api.store.GetUserByID(context.Background(), api.user.ID)
+	if err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+	if err := api.store.VerifyPassword(context.Background(), stored, "newsecret"); err != nil {
+		t.Errorf("expected the new password to be stored: %v", err)
+	}
+
+	// Only the user or an admin may change it
+	other := "/api/v1/users/" + strconv.Itoa(api.admin.ID) + "/password"
+	expect(t, api.do("PATCH", other, api.userToken, `{"password":"newsecret"}`, "If-Match", `"1"`), http.StatusForbidden, CodeForbidden)
+}
+
+func TestDeleteAndRestoreUserHandlers(t *testing.T) {
+	api := newUserAPI(t)
+	path := "/api/v1/users/" + strconv.Itoa(api.user.ID)
+	restore := path + "/restore"
+
+	expect(t, api.do("DELETE", "/api/v1/users/"+strconv.Itoa(api.admin.ID), api.userToken, ""), http.StatusForbidden, CodeForbidden)
+	expect(t, api.do("POST", restore, api.adminToken, ""), http.StatusNotFound, CodeUserNotFound)
+
+	expect(t, api.do("DELETE", path, api.userToken, ""), http.StatusOK, "")
+	expect(t, api.do("DELETE", path, api.adminToken, ""), http.StatusNotFound, CodeUserNotFound)
+	expect(t, api.do("GET", path, api.adminToken, ""), http.StatusNotFound, CodeUserNotFound)
+
+	if got := pageUsernames(api.list("/api/v1/users/", api.adminToken)); slices.Contains(got, "user") {
+		t.Errorf("expected deleted users to be left out, got %v", got)
+	}
+	deleted := api.list("/api/v1/admin/users/deleted", api.adminToken)
+	if len(deleted.Users) != 1 || deleted.Users[0].ID != api.user.ID || deleted.Users[0].DeletedAt == nil {
+		t.Fatalf("expected the deleted user, got %+v", deleted.Users)
+	}
+	expect(t, api.do("GET", "/api/v1/admin/users/deleted", api.userToken, ""), http.StatusForbidden, CodeForbidden)
+
+	// Deleted users keep their username and email until they are purged
+	expect(t, api.do("POST", "/api/v1/users/", "", `{"username":"user","email":"new@example.com","password":"secret123"}`), http.StatusConflict, CodeUsernameTaken)
+	expect(t, api.do("POST", "/api/v1/users/", "", `{"username":"newuser","email":"user@example.com","password":"secret123"}`), http.StatusConflict, CodeEmailTaken)
+
+	expect(t, api.do("POST", restore, api.userToken, ""), http.StatusForbidden, CodeForbidden)
+	w := api.do("POST", restore, api.adminToken, "")
+	expect(t, w, http.StatusOK, "")
+	if got := decodeUser(t, w); got.ID != api.user.ID || got.DeletedAt != nil {
+		t.Errorf("unexpected restored user %+v", got)
+	}
+	expect(t, api.do("GET", path, api.userToken, ""), http.StatusOK, "")
+	expect(t, api.do("POST", "/api/v1/users/abc/restore", api.adminToken, ""), http.StatusBadRequest, CodeInvalidRequest)
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"expvar"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiBasePath is the path every API version is mounted below
+const apiBasePath = "/api"
+
+// apiVersion is a set of routes mounted below apiBasePath
+type apiVersion struct {
+	prefix      string // Path below /api, e.g. "/v1"; empty for the unversioned routes
+	register    func(g *gin.RouterGroup)
+	deprecation *deprecation // Set once clients should move to a newer version
+}
+
+// deprecation describes when a deprecated version was deprecated, when it
+// will be removed and which version replaces it
+type deprecation struct {
+	since     time.Time
+	sunset    time.Time // Zero if no removal date is planned
+	successor string    // Path prefix of the replacing version, e.g. "/api/v1"
+}
+
+// The unversioned /api routes predate versioning and are kept as an alias
+// of v1 for clients that have not upgraded yet
+var (
+	legacyDeprecatedSince = time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC)
+	legacySunset          = time.Date(2027, time.April, 16, 0, 0, 0, 0, time.UTC)
+)
+
+// apiVersions returns every API version served. To introduce a new
+// version, append it here with its own register function and mark the
+// version it replaces as deprecated. Versions sharing handlers may differ
+// only in how they are registered.
+func (s *Server) apiVersions() []apiVersion {
+	return []apiVersion{
+		{prefix: "/v1", register: s.registerV1Routes},
+		{
+			prefix:   "",
+			register: s.registerV1Routes,
+			deprecation: &deprecation{
+				since:     legacyDeprecatedSince,
+				sunset:    legacySunset,
+				successor: "/api/v1",
+			},
+		},
+	}
+}
+
+// versionOf returns the API version serving the route path, or nil if
+// the route is not part of the API
+func (s *Server) versionOf(path string) *apiVersion {
+	var found *apiVersion
+	for _, v := range s.apiVersions() {
+		base := apiBasePath + v.prefix
+		if (path == base || strings.HasPrefix(path, base+"/")) && (found == nil || len(v.prefix) > len(found.prefix)) {
+			found = &v
+		}
+	}
+	return found
+}
+
+// deprecatedRequests counts requests to deprecated routes by method and
+// route, e.g. "GET /api/users/:id". It is published on /debug/vars.
+var deprecatedRequests = expvar.NewMap("deprecated_requests")
+
+// deprecated marks the routes of a group mounted at basePath as
+// deprecated. Responses carry the Deprecation (RFC 9745), Sunset
+// (RFC 8594) and successor Link headers, and every request is counted in
+// deprecatedRequests.
+func deprecated(basePath string, d deprecation) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "@"+strconv.FormatInt(d.since.Unix(), 10))
+		if !d.sunset.IsZero() {
+			c.Header("Sunset", d.sunset.UTC().Format(http.TimeFormat))
+		}
+		if d.successor != "" {
+			path := d.successor + strings.TrimPrefix(c.Request.URL.Path, basePath)
+			c.Header("Link", "<"+path+`>; rel="successor-version"`)
+		}
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		deprecatedRequests.Add(c.Request.Method+" "+route, 1)
+
+		c.Next()
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPIVersions(t *testing.T) {
+	s := &Server{}
+	h := s.RegisterRoutes()
+
+	post := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	// The current version is not deprecated
+	w := post("/api/v1/users/")
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected v1 route to exist, got status %d", w.Code)
+	}
+	if w.Header().Get("Deprecation") != "" {
+		t.Fatalf("expected no Deprecation header on v1, got %q", w.Header().Get("Deprecation"))
+	}
+
+	// The unversioned alias serves v1 with deprecation headers
+	before := deprecatedCount("POST /api/users/")
+	w = post("/api/users/")
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected legacy route to exist, got status %d", w.Code)
+	}
+	if got := w.Header().Get("Deprecation"); got != "@1792108800" {
+		t.Fatalf("unexpected Deprecation header %q", got)
+	}
+	if got := w.Header().Get("Sunset"); got != "Fri, 16 Apr 2027 00:00:00 GMT" {
+		t.Fatalf("unexpected Sunset header %q", got)
+	}
+	if got := w.Header().Get("Link"); got != `</api/v1/users/>; rel="successor-version"` {
+		t.Fatalf("unexpected Link header %q", got)
+	}
+	if got := deprecatedCount("POST /api/users/"); got != before+1 {
+		t.Fatalf("expected deprecated usage count %d, got %d", before+1, got)
+	}
+}
+
+func deprecatedCount(key string) int64 {
+	v := deprecatedRequests.Get(key)
+	if v == nil {
+		return 0
+	}
+	return v.(interface{ Value() int64 }).Value()
+}
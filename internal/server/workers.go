@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// workers tracks the background workers of the server, so that the
+// liveness probe fails when one of them stops or gets stuck
+type workers struct {
+	mu     sync.Mutex
+	states map[string]*workerState
+}
+
+type workerState struct {
+	interval time.Duration
+	lastRun  time.Time // When the worker last started a run
+	stopped  bool
+}
+
+func newWorkers() *workers {
+	return &workers{states: map[string]*workerState{}}
+}
+
+// run calls fn like runEvery, recording each run under name. A nil
+// *workers runs fn without tracking it.
+func (w *workers) run(ctx context.Context, name string, interval time.Duration, fn func(ctx context.Context)) {
+	if w == nil {
+		runEvery(ctx, interval, fn)
+		return
+	}
+
+	state := &workerState{interval: interval}
+	w.mu.Lock()
+	w.states[name] = state
+	w.mu.Unlock()
+	defer func() {
+		w.mu.Lock()
+		state.stopped = true
+		w.mu.Unlock()
+	}()
+
+	runEvery(ctx, interval, func(ctx context.Context) {
+		w.mu.Lock()
+		state.lastRun = time.Now()
+		w.mu.Unlock()
+		fn(ctx)
+	})
+}
+
+// check fails if a worker stopped, or has not started a run for twice its
+// interval, which means that a run is stuck
+func (w *workers) check(ctx context.Context) ([]string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	names := make([]string, 0, len(w.states))
+	for name := range w.states {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		state := w.states[name]
+		switch {
+		case state.stopped:
+			return nil, fmt.Errorf("%s stopped", name)
+		case !state.lastRun.IsZero() && time.Since(state.lastRun) > 2*state.interval:
+			return nil, fmt.Errorf("%s last ran %v ago", name, time.Since(state.lastRun).Round(time.Second))
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,134 @@
+// Package tracing configures OpenTelemetry tracing: the exporter spans are
+// sent to, and W3C Trace Context propagation.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ServiceName names the service in spans unless OTEL_SERVICE_NAME is set
+const ServiceName = "golang-backend"
+
+// TracerName is the instrumentation scope of the spans of the application
+const TracerName = "golang-backend"
+
+// Exporters of Options
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+	ExporterOTLP   = "otlp"
+)
+
+// Options configure tracing
+type Options struct {
+	// Exporter is one of the Exporter* constants, ExporterNone by default
+	Exporter string
+	// File is the file ExporterFile appends spans to
+	File string
+	// Protocol is the OTLP protocol, "grpc" or "http/protobuf" (default).
+	// The endpoint and headers are taken from the OTEL_EXPORTER_OTLP_*
+	// environment variables.
+	Protocol string
+}
+
+// Setup installs the global tracer provider exporting spans as configured
+// by opts, and the W3C Trace Context and Baggage propagators. The returned
+// function flushes pending spans and releases the exporter.
+//
+// Without an exporter spans are not recorded, but trace context is still
+// propagated.
+func Setup(ctx context.Context, opts Options) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	exporter, closeOutput, err := newExporter(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if exporter == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(ServiceName)))
+	if err != nil {
+		return nil, err
+	}
+	// Let OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence
+	if env, err := resource.New(ctx, resource.WithFromEnv()); err == nil {
+		res, _ = resource.Merge(res, env)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closeOutput != nil {
+			if cerr := closeOutput.Close(); err == nil {
+				err = cerr
+			}
+		}
+		return err
+	}, nil
+}
+
+// newExporter returns the exporter selected by opts, or nil for none,
+// along with the file it writes to if it must be closed
+func newExporter(ctx context.Context, opts Options) (sdktrace.SpanExporter, io.Closer, error) {
+	switch strings.ToLower(opts.Exporter) {
+	case "", ExporterNone:
+		return nil, nil, nil
+	case ExporterStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		return exporter, nil, err
+	case ExporterFile:
+		if opts.File == "" {
+			return nil, nil, fmt.Errorf("OTEL_TRACES_FILE is required by the %s exporter", ExporterFile)
+		}
+		f, err := os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open OTEL_TRACES_FILE: %w", err)
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return exporter, f, nil
+	case ExporterOTLP:
+		switch opts.Protocol {
+		case "", "http/protobuf":
+			exporter, err := otlptracehttp.New(ctx)
+			return exporter, nil, err
+		case "grpc":
+			exporter, err := otlptracegrpc.New(ctx)
+			return exporter, nil, err
+		default:
+			return nil, nil, fmt.Errorf("unsupported OTLP protocol %q", opts.Protocol)
+		}
+	default:
+		return nil, nil, fmt.Errorf("unsupported traces exporter %q", opts.Exporter)
+	}
+}
+
+// Tracer returns the tracer of the application from the global provider
+func Tracer() trace.Tracer {
+	return otel.Tracer(TracerName)
+}
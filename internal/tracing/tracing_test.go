@@ -0,0 +1,63 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+)
+
+func TestFileExporter(t *testing.T) {
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+
+	path := filepath.Join(t.TempDir(), "traces.json")
+	shutdown, err := Setup(context.Background(), Options{Exporter: ExporterFile, File: path})
+	if err != nil {
+		t.Fatalf("failed to set up tracing: %v", err)
+	}
+
+	_, span := Tracer().Start(context.Background(), "test span")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("failed to shut down tracing: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read traces: %v", err)
+	}
+	if !strings.Contains(string(data), `"Name":"test span"`) || !strings.Contains(string(data), ServiceName) {
+		t.Errorf("expected the span to be exported, got %s", data)
+	}
+}
+
+func TestSetup(t *testing.T) {
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+
+	shutdown, err := Setup(context.Background(), Options{})
+	if err != nil {
+		t.Fatalf("failed to set up tracing: %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("failed to shut down tracing: %v", err)
+	}
+
+	// Trace context is propagated even when spans are not exported
+	fields := otel.GetTextMapPropagator().Fields()
+	if !strings.Contains(strings.Join(fields, ","), "traceparent") {
+		t.Errorf("expected the W3C Trace Context propagator, got fields %v", fields)
+	}
+
+	for _, opts := range []Options{
+		{Exporter: "jaeger"},
+		{Exporter: ExporterFile},
+		{Exporter: ExporterOTLP, Protocol: "thrift"},
+	} {
+		if _, err := Setup(context.Background(), opts); err == nil {
+			t.Errorf("expected an error for %+v", opts)
+		}
+	}
+}
@@ -15,7 +15,7 @@ echo ""
 
 # Create a user
 echo "2. Creating a user..."
-CREATE_RESPONSE=$(curl -s -X POST "$BASE_URL/api/users/" \
+CREATE_RESPONSE=$(curl -s -X POST "$BASE_URL/api/v1/users/" \
   -H "Content-Type: application/json" \
   -d '{
     "username": "john_doe",
@@ -28,19 +28,37 @@ echo "$CREATE_RESPONSE" | jq .
 USER_ID=$(echo "$CREATE_RESPONSE" | jq -r '.user.id')
 echo ""
 
-# Get all users
-echo "3. Getting all users..."
-curl -s "$BASE_URL/api/users/" | jq .
+# Log in
+echo "3. Logging in..."
+LOGIN_RESPONSE=$(curl -s -X POST "$BASE_URL/api/v1/auth/login" \
+  -H "Content-Type: application/json" \
+  -d '{
+    "login": "john_doe",
+    "password": "password123"
+  }')
+echo "$LOGIN_RESPONSE" | jq .
+
+# Extract access token from response
+TOKEN=$(echo "$LOGIN_RESPONSE" | jq -r '.access_token')
+AUTH_HEADER="Authorization: Bearer $TOKEN"
 echo ""
 
+# Print the ETag of a user, required in If-Match when modifying it
+user_etag() {
+  curl -s -o /dev/null -D - "$BASE_URL/api/v1/users/$1" -H "$AUTH_HEADER" \
+    | awk 'tolower($1) == "etag:" { print $2 }' | tr -d '\r'
+}
+
 # Get specific user
 echo "4. Getting user with ID $USER_ID..."
-curl -s "$BASE_URL/api/users/$USER_ID" | jq .
+curl -s "$BASE_URL/api/v1/users/$USER_ID" -H "$AUTH_HEADER" | jq .
 echo ""
 
 # Update user
 echo "5. Updating user with ID $USER_ID..."
-curl -s -X PUT "$BASE_URL/api/users/$USER_ID" \
+curl -s -X PUT "$BASE_URL/api/v1/users/$USER_ID" \
+  -H "$AUTH_HEADER" \
+  -H "If-Match: $(user_etag "$USER_ID")" \
   -H "Content-Type: application/json" \
   -d '{
     "username": "john_updated",
@@ -50,7 +68,9 @@ echo ""
 
 # Update password
 echo "6. Updating password for user with ID $USER_ID..."
-curl -s -X PATCH "$BASE_URL/api/users/$USER_ID/password" \
+curl -s -X PATCH "$BASE_URL/api/v1/users/$USER_ID/password" \
+  -H "$AUTH_HEADER" \
+  -H "If-Match: $(user_etag "$USER_ID")" \
   -H "Content-Type: application/json" \
   -d '{
     "password": "newpassword123"
@@ -59,17 +79,17 @@ echo ""
 
 # Get updated user
 echo "7. Getting updated user with ID $USER_ID..."
-curl -s "$BASE_URL/api/users/$USER_ID" | jq .
+curl -s "$BASE_URL/api/v1/users/$USER_ID" -H "$AUTH_HEADER" | jq .
 echo ""
 
 # Delete user
 echo "8. Deleting user with ID $USER_ID..."
-curl -s -X DELETE "$BASE_URL/api/users/$USER_ID" | jq .
+curl -s -X DELETE "$BASE_URL/api/v1/users/$USER_ID" -H "$AUTH_HEADER" | jq .
 echo ""
 
 # Verify user is deleted
 echo "9. Verifying user is deleted..."
-curl -s "$BASE_URL/api/users/$USER_ID" | jq .
+curl -s "$BASE_URL/api/v1/users/$USER_ID" -H "$AUTH_HEADER" | jq .
 echo ""
 
 echo "=== API Test Complete ==="